@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	adaptiveCompression = flag.Bool("adaptive-compression", false, "with -compress, skip on-the-fly compression for clients whose recent downloads ran faster than -fast-client-rate")
+	fastClientRate      = flag.Int64("fast-client-rate", 10<<20, "bytes per second from which a client counts as fast enough that compressing costs more CPU than it saves time")
+)
+
+const (
+	// minSpeedSample is the smallest download used to estimate a client's
+	// speed; smaller ones mostly measure socket buffers.
+	minSpeedSample = 1 << 20
+	// clientSpeedTTL is how long an estimate is trusted without new samples.
+	clientSpeedTTL = 10 * time.Minute
+	// maxClientSpeeds bounds the number of clients tracked.
+	maxClientSpeeds = 10000
+)
+
+// clientSpeed is an exponentially weighted average of a client's recent
+// download rates, in bytes per second.
+type clientSpeed struct {
+	rate    float64
+	updated time.Time
+}
+
+// speedTable estimates per-client throughput from finished downloads.
+type speedTable struct {
+	mu      sync.Mutex
+	clients map[string]clientSpeed
+}
+
+var clientSpeeds = &speedTable{clients: make(map[string]clientSpeed)}
+
+// record adds a finished download of bytes that took d.
+func (t *speedTable) record(ip string, bytes int64, d time.Duration) {
+	if bytes < minSpeedSample || d <= 0 {
+		return
+	}
+	rate := float64(bytes) / d.Seconds()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, ok := t.clients[ip]
+	if ok && now.Sub(prev.updated) < clientSpeedTTL {
+		// Recent samples weigh more, so a client that moved networks is
+		// reclassified after a couple of downloads
+		rate = 0.5*prev.rate + 0.5*rate
+	} else if !ok && len(t.clients) >= maxClientSpeeds {
+		for other, s := range t.clients {
+			if now.Sub(s.updated) >= clientSpeedTTL {
+				delete(t.clients, other)
+			}
+		}
+		if len(t.clients) >= maxClientSpeeds {
+			return
+		}
+	}
+	t.clients[ip] = clientSpeed{rate: rate, updated: now}
+}
+
+// estimate returns the client's recent rate, if there is one.
+func (t *speedTable) estimate(ip string) (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.clients[ip]
+	if !ok || time.Since(s.updated) >= clientSpeedTTL {
+		return 0, false
+	}
+	return s.rate, true
+}
+
+// sentWriter counts the bytes handed to the connection, which measure the
+// client's speed better than the file's bytes when the body is compressed.
+type sentWriter struct {
+	w    io.Writer
+	sent *atomic.Int64
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.sent.Add(int64(n))
+	return n, err
+}
+
+// fastClient reports whether compression should be skipped for ip under
+// -adaptive-compression. Clients without an estimate get the static policy.
+func fastClient(ip string) bool {
+	if !*adaptiveCompression {
+		return false
+	}
+	rate, ok := clientSpeeds.estimate(ip)
+	return ok && rate >= float64(*fastClientRate)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFastClient(t *testing.T) {
+	setFlag(t, "adaptive-compression", "true")
+	setFlag(t, "fast-client-rate", "1000000")
+
+	tests := []struct {
+		name  string
+		ip    string
+		bytes int64
+		d     time.Duration
+		fast  bool
+	}{
+		{"fast", "192.0.2.1", 4 << 20, time.Second, true},
+		{"slow", "192.0.2.2", 4 << 20, 10 * time.Second, false},
+		{"sample too small", "192.0.2.3", 1 << 10, time.Millisecond, false},
+		{"unknown", "192.0.2.4", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSpeeds.record(tt.ip, tt.bytes, tt.d)
+			if got := fastClient(tt.ip); got != tt.fast {
+				t.Errorf("fastClient = %v, want %v", got, tt.fast)
+			}
+		})
+	}
+}
+
+// The estimate comes from the bytes on the wire, not the file's, and is
+// only kept under -adaptive-compression.
+func TestDownloadRecordsSentBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		adaptive string
+		ip       string
+		recorded bool
+	}{
+		{"adaptive", "true", "198.51.100.1", true},
+		{"static", "false", "198.51.100.2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "adaptive-compression", tt.adaptive)
+			r := httptest.NewRequest("GET", "/download?file=a.txt", nil)
+			r.RemoteAddr = tt.ip + ":1234"
+
+			d := downloads.start(r, "a.txt")
+			d.started = time.Now().Add(-time.Second)
+			d.bytes.Store(64 << 20)
+			d.sent.Store(2 << 20)
+			downloads.finish(d, downloadCompleted)
+
+			rate, ok := clientSpeeds.estimate(tt.ip)
+			if ok != tt.recorded {
+				t.Fatalf("recorded = %v, want %v", ok, tt.recorded)
+			}
+			if ok && rate > 4<<20 {
+				t.Errorf("rate = %.0f B/s, want about the 2 MiB/s sent", rate)
+			}
+		})
+	}
+}
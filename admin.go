@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin guards an admin endpoint with a bearer token from
+// -admin-token-file. Without any tokens configured the admin API is off.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens := currentConfig().adminTokens
+		if len(tokens) == 0 {
+			http.Error(w, "Admin API is not configured", http.StatusForbidden)
+			return
+		}
+
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !adminTokenValid(presented, tokens) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// adminTokenValid compares against every token in constant time so the
+// response time does not reveal how close a guess was.
+func adminTokenValid(presented string, tokens map[string]bool) bool {
+	valid := false
+	for token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// adminReloadHandler handles POST /admin/reload.
+func adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	changes, err := reloadConfig()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		enc.Encode(map[string]any{"reloaded": false, "error": err.Error()})
+		return
+	}
+	enc.Encode(map[string]any{"reloaded": true, "changes": changes})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"strings"
+)
+
+var basePath = flag.String("base-path", "", "path prefix the server is mounted under behind a proxy, e.g. /files-service; it is stripped from requests and added to generated URLs")
+
+// checkBasePath normalizes -base-path to "/prefix" without a trailing slash.
+func checkBasePath() error {
+	if *basePath == "" || *basePath == "/" {
+		*basePath = ""
+		return nil
+	}
+	if !strings.HasPrefix(*basePath, "/") || strings.ContainsAny(*basePath, "?#") {
+		return errors.New("-base-path must be an absolute path like /files-service")
+	}
+	*basePath = strings.TrimRight(*basePath, "/")
+	return nil
+}
+
+// urlPath turns a route path into the URL clients have to use.
+func urlPath(p string) string {
+	return *basePath + p
+}
+
+// withBasePath strips -base-path before routing. The bare prefix is
+// redirected to its slash form, as the landing page lives there; requests
+// outside the prefix get 404.
+func withBasePath(next http.Handler) http.Handler {
+	if *basePath == "" {
+		return next
+	}
+	stripped := http.StripPrefix(*basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == *basePath:
+			target := *basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, *basePath+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckBasePath(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"", "", true},
+		{"/", "", true},
+		{"/files-service", "/files-service", true},
+		{"/files-service/", "/files-service", true},
+		{"/a/b//", "/a/b", true},
+		{"files-service", "", false},
+		{"/files?x", "", false},
+		{"/files#x", "", false},
+	}
+	for _, tt := range tests {
+		setFlag(t, "base-path", tt.value)
+		err := checkBasePath()
+		if (err == nil) != tt.ok {
+			t.Errorf("-base-path=%q: error = %v, want ok = %v", tt.value, err, tt.ok)
+			continue
+		}
+		if tt.ok && *basePath != tt.want {
+			t.Errorf("-base-path=%q normalized to %q, want %q", tt.value, *basePath, tt.want)
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	dir := newTestDir(t)
+	setFlag(t, "staging-dir", filepath.Join(dir, "staging"))
+	writeTestFile(t, "a.txt", "hello")
+	setFlag(t, "base-path", "/files-service/")
+	if err := checkBasePath(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("index")) })
+	mux.HandleFunc("GET /download", downloadHandler)
+	mux.HandleFunc("POST /jobs", createJobHandler)
+	handler := withBasePath(mux)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		status   int
+		body     string
+		location string
+	}{
+		{"download under prefix", "GET", "/files-service/download?file=a.txt", http.StatusOK, "hello", ""},
+		{"landing page", "GET", "/files-service/", http.StatusOK, "index", ""},
+		{"bare prefix redirects", "GET", "/files-service?x=1", http.StatusMovedPermanently, "", "/files-service/?x=1"},
+		{"outside prefix", "GET", "/download?file=a.txt", http.StatusNotFound, "", ""},
+		{"prefix lookalike", "GET", "/files-servicex/download?file=a.txt", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.ServeHTTP, newRequest(tt.method, tt.target))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+
+	t.Run("generated url", func(t *testing.T) {
+		rec := serve(handler.ServeHTTP, newRequest("POST", "/files-service/jobs?file=a.txt"))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+		}
+		location := rec.Header().Get("Location")
+		id, ok := strings.CutPrefix(location, "/files-service/jobs?id=")
+		if !ok {
+			t.Fatalf("Location = %q, want it under the base path", location)
+		}
+		// Let staging finish before the directory is removed
+		deadline := time.Now().Add(5 * time.Second)
+		for lookupJob(id).status().State == jobStaging && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
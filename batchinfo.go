@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var maxBatchInfo = flag.Int("max-batch-info", 1000, "most file names accepted by one POST /batch-info request")
+
+const (
+	// batchInfoWorkers bounds the concurrent stats of one request.
+	batchInfoWorkers = 16
+	maxBatchInfoBody = 1 << 20
+)
+
+type batchInfoEntry struct {
+	Name     string     `json:"name"`
+	Exists   bool       `json:"exists"`
+	Size     int64      `json:"size"`
+	Modified *time.Time `json:"modified,omitempty"`
+	// Error is set for names that break the file name rules; they are
+	// reported rather than failing the whole batch
+	Error string `json:"error,omitempty"`
+}
+
+// batchInfoHandler handles POST /batch-info with a JSON array of file
+// names. Results come back in request order. Every name goes through the
+// same checks as ?file=, and only regular files count as existing.
+func batchInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchInfoBody)).Decode(&names); err != nil {
+		writeValidationError(w, invalidParam("body", "must be a JSON array of file names"))
+		return
+	}
+	if len(names) > *maxBatchInfo {
+		writeValidationError(w, invalidParam("body", fmt.Sprintf("at most %d names are allowed per request, got %d", *maxBatchInfo, len(names))))
+		return
+	}
+
+	results := make([]batchInfoEntry, len(names))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(batchInfoWorkers, len(names)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = batchInfo(names[i])
+			}
+		}()
+	}
+	for i := range names {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(results)
+}
+
+func batchInfo(name string) batchInfoEntry {
+	entry := batchInfoEntry{Name: name}
+	filePath, verr := validateFileParam("name", name)
+	if verr != nil {
+		entry.Error = verr.Reason
+		return entry
+	}
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Batch info stat failed", "file", name, "error", err)
+			entry.Error = "cannot stat file"
+		}
+		return entry
+	}
+	if stat.Mode().IsRegular() {
+		modified := stat.ModTime()
+		entry.Exists, entry.Size, entry.Modified = true, stat.Size(), &modified
+		entry.Name = filepath.ToSlash(filepath.Clean(name))
+	}
+	return entry
+}
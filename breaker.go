@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	breakerThreshold = flag.Int("breaker-threshold", 5, "consecutive storage errors that open the circuit breaker (0 = disabled)")
+	breakerWindow    = flag.Duration("breaker-window", 30*time.Second, "window in which consecutive storage errors are counted")
+	breakerProbe     = flag.Duration("breaker-probe-interval", 5*time.Second, "how often storage is probed while the breaker is open")
+)
+
+// circuitBreaker fails downloads fast once storage keeps returning errors,
+// instead of letting every request run into the same broken mount.
+type circuitBreaker struct {
+	mu           sync.Mutex
+	failures     int
+	firstFailure time.Time
+	open         bool
+}
+
+var storageBreaker = &circuitBreaker{}
+
+// allow reports whether requests may touch storage.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.open
+}
+
+// success resets the consecutive failure count.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// failure records a storage error and opens the breaker once the threshold
+// is reached inside the window.
+func (b *circuitBreaker) failure(err error) {
+	if *breakerThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.failures == 0 || now.Sub(b.firstFailure) > *breakerWindow {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+
+	if !b.open && b.failures >= *breakerThreshold {
+		b.open = true
+		slog.Error("Storage circuit breaker opened", "consecutive_errors", b.failures, "last_error", err)
+		go b.probeUntilHealthy()
+	}
+}
+
+// probeUntilHealthy periodically checks the download directory and closes
+// the breaker once it is readable again.
+func (b *circuitBreaker) probeUntilHealthy() {
+	ticker := time.NewTicker(*breakerProbe)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		dir, err := os.Open(downloadDir)
+		if err == nil {
+			_, err = dir.Readdirnames(1)
+			dir.Close()
+		}
+		// An empty directory reads as io.EOF, which is still healthy.
+		if err != nil && err != io.EOF {
+			slog.Warn("Storage probe failed, circuit breaker stays open", "error", err)
+			continue
+		}
+
+		b.mu.Lock()
+		b.open = false
+		b.failures = 0
+		b.mu.Unlock()
+		slog.Info("Storage probe succeeded, circuit breaker closed")
+		return
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "flag"
+
+var bufferBudget = flag.Int64("buffer-budget", 0, "memory in bytes shared by the read buffers of all active downloads; each download gets budget/active, clamped to 4 KiB..1 MiB (0 = fixed 32 KiB buffers)")
+
+const (
+	defaultBufferSize = 32 << 10
+	minBufferSize     = 4 << 10
+	maxBufferSize     = 1 << 20
+)
+
+// streamBufferSize picks the read buffer for a download starting now.
+//
+// With a budget B and N active downloads (this one included) the size is
+// B/N rounded down to a multiple of 4 KiB and clamped to [4 KiB, 1 MiB]. A
+// lone download gets large reads for throughput; under heavy load buffers
+// shrink so their total stays near B. The minimum means the budget can be
+// exceeded once N > B/4 KiB.
+func streamBufferSize() int {
+	if *bufferBudget <= 0 {
+		return defaultBufferSize
+	}
+
+	active := int64(max(downloads.activeCount(), 1))
+	size := *bufferBudget / active
+	size -= size % minBufferSize
+	return int(min(max(size, minBufferSize), maxBufferSize))
+}
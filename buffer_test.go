@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withActiveDownloads registers n idle downloads for the rest of the test.
+func withActiveDownloads(tb testing.TB, n int) {
+	tb.Helper()
+	for range n {
+		d := downloads.start(httptest.NewRequest("GET", "/download?file=idle", nil), "idle")
+		tb.Cleanup(func() { downloads.finish(d, downloadCancelled) })
+	}
+}
+
+func TestStreamBufferSize(t *testing.T) {
+	tests := []struct {
+		budget string
+		active int
+		want   int
+	}{
+		{"0", 10, defaultBufferSize},
+		{"8388608", 1, maxBufferSize},
+		{"8388608", 16, 512 << 10},
+		{"1048576", 3, 340 << 10},
+		{"8388608", 5000, minBufferSize},
+		{"100000", 1, 96 << 10},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("budget %s, %d active", tt.budget, tt.active), func(t *testing.T) {
+			setFlag(t, "buffer-budget", tt.budget)
+			withActiveDownloads(t, tt.active)
+			if got := streamBufferSize(); got != tt.want {
+				t.Errorf("buffer = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkDownloadUnderLoad streams a medium file while other downloads
+// are active, which shrinks the buffer under -buffer-budget.
+func BenchmarkDownloadUnderLoad(b *testing.B) {
+	for _, active := range []int{0, 64, 1024} {
+		b.Run(fmt.Sprintf("active=%d", active), func(b *testing.B) {
+			newTestDir(b)
+			content := strings.Repeat("x", 8<<20)
+			writeTestFile(b, "a.bin", content)
+			setFlag(b, "buffer-budget", "67108864")
+			withActiveDownloads(b, active)
+
+			b.SetBytes(int64(len(content)))
+			b.ResetTimer()
+			for range b.N {
+				rec := httptest.NewRecorder()
+				downloadHandler(rec, newRequest("GET", "/download?file=a.bin"))
+				if rec.Body.Len() != len(content) {
+					b.Fatalf("sent %d bytes", rec.Body.Len())
+				}
+			}
+		})
+	}
+}
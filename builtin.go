@@ -0,0 +1,97 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// builtinFS holds default pages compiled into the binary. A file of the
+// same name in the download directory always takes precedence.
+//
+//go:embed builtin
+var builtinFS embed.FS
+
+var builtinFiles = flag.String("builtin-files", "index.html,404.html", "comma separated built-in files served when the download directory has no file of that name (empty = disk only)")
+
+// servedFile is what serveFile streams from: a file on disk or a built-in
+// one.
+type servedFile interface {
+	io.ReaderAt
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// binaryModTime stands in for the modification time of built-in files,
+// which embed leaves zero, so they still get stable validators.
+var binaryModTime = func() time.Time {
+	if exe, err := os.Executable(); err == nil {
+		if stat, err := os.Stat(exe); err == nil {
+			return stat.ModTime()
+		}
+	}
+	return time.Now()
+}()
+
+// builtinAllowed reports whether name may fall back to a built-in file.
+func builtinAllowed(name string) bool {
+	for _, allowed := range strings.Split(*builtinFiles, ",") {
+		if strings.TrimSpace(allowed) == name && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// openServed opens filePath, from the -memory-cache if it qualifies, falling
+// back to the built-in file called name when the file does not exist on
+// disk and name is in -builtin-files.
+func openServed(name, filePath string) (servedFile, error) {
+	if cached, ok := memCache.open(filePath); ok {
+		return cached, nil
+	}
+	file, err := os.Open(filePath)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) || !builtinAllowed(name) {
+		return nil, err
+	}
+	embedded, embedErr := builtinFS.Open("builtin/" + name)
+	if embedErr != nil {
+		return nil, err
+	}
+	return builtinFile{embedded.(servedFile)}, nil
+}
+
+// readBuiltin returns the content of a built-in file allowed by
+// -builtin-files.
+func readBuiltin(name string) ([]byte, bool) {
+	if !builtinAllowed(name) {
+		return nil, false
+	}
+	data, err := builtinFS.ReadFile("builtin/" + name)
+	return data, err == nil
+}
+
+type builtinFile struct {
+	servedFile
+}
+
+func (f builtinFile) Stat() (fs.FileInfo, error) {
+	info, err := f.servedFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return builtinInfo{info}, nil
+}
+
+type builtinInfo struct {
+	fs.FileInfo
+}
+
+func (info builtinInfo) ModTime() time.Time { return binaryModTime }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	cacheControl = flag.String("cache-control", "no-cache", "Cache-Control policy for downloads: no-cache, no-store, immutable, max-age=N or a literal header value")
+
+	// defaultCacheControl is the expanded -cache-control policy, set in main.
+	defaultCacheControl = "no-cache"
+	cacheControlByExt   = make(map[string]string)
+
+	// hashedName matches content-hashed build artifacts such as
+	// "app.a1b2c3d4.js"; nil disables the detection.
+	hashedName = regexp.MustCompile(defaultHashedNamePattern)
+)
+
+const (
+	defaultHashedNamePattern = `[.\-_][0-9a-fA-F]{8,}\.`
+	immutableCacheControl    = "public, max-age=31536000, immutable"
+)
+
+func init() {
+	flag.Func("cache-control-ext", "per-extension Cache-Control policy as `.ext=policy` (repeatable)", func(s string) error {
+		ext, policy, found := strings.Cut(s, "=")
+		if !found || !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("want .ext=policy, got %q", s)
+		}
+		value, err := cachePolicy(policy)
+		if err != nil {
+			return err
+		}
+		cacheControlByExt[strings.ToLower(ext)] = value
+		return nil
+	})
+	flag.Func("hashed-name-pattern", "regexp matched against the base name to detect content-hashed files, which are cached as immutable; matches of digits alone, such as dates, are ignored (empty disables; default "+defaultHashedNamePattern+")", func(s string) error {
+		if s == "" {
+			hashedName = nil
+			return nil
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		hashedName = re
+		return nil
+	})
+}
+
+// cachePolicy expands a preset name into a Cache-Control value. Anything
+// that is not a preset is used verbatim.
+func cachePolicy(policy string) (string, error) {
+	policy = strings.TrimSpace(policy)
+	switch policy {
+	case "no-cache", "no-store":
+		return policy, nil
+	case "immutable":
+		return immutableCacheControl, nil
+	}
+
+	if age, found := strings.CutPrefix(policy, "max-age="); found {
+		n, err := strconv.Atoi(age)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("invalid max-age in cache policy %q", policy)
+		}
+		return fmt.Sprintf("public, max-age=%d", n), nil
+	}
+
+	if policy == "" || !validHeaderValue(policy) {
+		return "", fmt.Errorf("invalid cache policy %q", policy)
+	}
+	return policy, nil
+}
+
+// cacheControlFor returns the Cache-Control value for a download. An
+// extension override wins over hashed-name detection, which wins over the
+// server-wide policy.
+func cacheControlFor(name string) string {
+	if value, ok := cacheControlByExt[strings.ToLower(filepath.Ext(name))]; ok {
+		return value
+	}
+	if hasContentHash(filepath.Base(name)) {
+		return immutableCacheControl
+	}
+	return defaultCacheControl
+}
+
+// hasContentHash reports whether base matches hashedName with something
+// other than digits: a date or timestamp such as "log-20241015.txt" fits
+// the pattern but changes with the content no more than any other name.
+func hasContentHash(base string) bool {
+	for rest := base; hashedName != nil; {
+		loc := hashedName.FindStringIndex(rest)
+		if loc == nil {
+			return false
+		}
+		if strings.IndexFunc(rest[loc[0]:loc[1]], unicode.IsLetter) >= 0 {
+			return true
+		}
+		// Matches may overlap, as in "log-20241015.a1b2c3d4.js"
+		if loc[0] >= len(rest) {
+			return false
+		}
+		rest = rest[loc[0]+1:]
+	}
+	return false
+}
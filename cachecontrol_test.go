@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestHasContentHash(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"app.a1b2c3d4.js", true},
+		{"vendor-0123456789abcdef.css", true},
+		{"font_DEADBEEF.woff2", true},
+		{"log-20241015.txt", false},
+		{"backup_20241015123045.tar", false},
+		{"log-20241015.a1b2c3d4.js", true},
+		{"app.abc123.js", false},
+		{"readme.txt", false},
+	}
+	for _, tt := range tests {
+		if got := hasContentHash(tt.name); got != tt.want {
+			t.Errorf("hasContentHash(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCacheControlForHashedNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"assets/app.a1b2c3d4.js", immutableCacheControl},
+		{"logs/log-20241015.txt", defaultCacheControl},
+	}
+	for _, tt := range tests {
+		if got := cacheControlFor(tt.name); got != tt.want {
+			t.Errorf("cacheControlFor(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// capabilities describes the optional features of this instance so clients
+// can adapt to it. It must only carry feature switches and limits, never
+// secrets, since GET /capabilities needs no authentication.
+type capabilities struct {
+	Ranges           bool     `json:"ranges"`
+	OffsetParam      bool     `json:"offset_param"`
+	Compression      []string `json:"compression"`
+	CompressOnTheFly bool     `json:"compress_on_the_fly"`
+	Precompressed    bool     `json:"precompressed"`
+	Digest           bool     `json:"digest"`
+	Trailers         []string `json:"trailers"`
+	ResumableZip     bool     `json:"resumable_zip"`
+	ReadOnly         bool     `json:"read_only"`
+	GRPC             bool     `json:"grpc"`
+	MaxRequestFiles  int      `json:"max_request_files"`
+	MaxRequestBytes  int64    `json:"max_request_bytes"`
+
+	Auth   authCapabilities   `json:"auth"`
+	Upload uploadCapabilities `json:"upload"`
+}
+
+type authCapabilities struct {
+	TLS                bool `json:"tls"`
+	ClientCertificates bool `json:"client_certificates"`
+	APIKeys            bool `json:"api_keys"`
+	AdminAPI           bool `json:"admin_api"`
+}
+
+type uploadCapabilities struct {
+	Enabled   bool   `json:"enabled"`
+	Raw       bool   `json:"raw_put"`
+	MaxSize   int64  `json:"max_size"`
+	Collision string `json:"collision"`
+	Subdirs   bool   `json:"subdirs"`
+}
+
+// currentCapabilities derives the capabilities from the effective
+// configuration, including settings reloaded at runtime.
+func currentCapabilities() capabilities {
+	cfg := currentConfig()
+
+	// /download-compressed always gzips; -compress negotiates per request
+	compression := []string{"gzip"}
+	if *compressOnTheFly && dictContent != nil {
+		compression = append(compression, "dcz")
+	}
+	trailers := []string{}
+	if *checksumTrailer {
+		trailers = append(trailers, checksumTrailerName)
+	}
+	if *bytesServedTrailer {
+		trailers = append(trailers, bytesServedTrailerName)
+	}
+
+	uploads := *uploadEnabled && !*readOnly
+	return capabilities{
+		Ranges:           true,
+		OffsetParam:      true,
+		Compression:      compression,
+		CompressOnTheFly: *compressOnTheFly,
+		Precompressed:    *precompressed,
+		Digest:           *sendDigest,
+		Trailers:         trailers,
+		ResumableZip:     *zipCache,
+		ReadOnly:         *readOnly,
+		GRPC:             *grpcAddr != "",
+		MaxRequestFiles:  *maxRequestFiles,
+		MaxRequestBytes:  *maxRequestBytes,
+		Auth: authCapabilities{
+			TLS:                *tlsCert != "",
+			ClientCertificates: *clientCA != "",
+			APIKeys:            len(cfg.tiers.byKey) > 0,
+			AdminAPI:           len(cfg.adminTokens) > 0,
+		},
+		Upload: uploadCapabilities{
+			Enabled:   uploads,
+			Raw:       uploads,
+			MaxSize:   *maxUploadSize,
+			Collision: *uploadCollision,
+			Subdirs:   *uploadSubdirs,
+		},
+	}
+}
+
+// capabilitiesHandler handles GET /capabilities.
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(currentCapabilities())
+}
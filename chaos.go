@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+var (
+	chaosEnabled    = flag.Bool("chaos", false, "inject the faults configured by the -chaos-* flags into downloads; for testing clients only")
+	chaosLatencyMin = flag.Duration("chaos-latency-min", 0, "with -chaos, least extra delay before a download starts")
+	chaosLatencyMax = flag.Duration("chaos-latency-max", 0, "with -chaos, most extra delay before a download starts")
+	chaosErrorRate  = flag.Float64("chaos-error-rate", 0, "with -chaos, probability (0-1) that a download fails with 500 or 503 before any byte is sent")
+	chaosAbortRate  = flag.Float64("chaos-abort-rate", 0, "with -chaos, probability (0-1) that a download is cut off at a random point")
+)
+
+// checkChaos validates the -chaos-* flags. They do nothing without -chaos,
+// so a stray setting cannot turn fault injection on by itself.
+func checkChaos() error {
+	if !*chaosEnabled {
+		return nil
+	}
+	switch {
+	case *chaosLatencyMin < 0 || *chaosLatencyMax < *chaosLatencyMin:
+		return errors.New("-chaos-latency-max must be at least -chaos-latency-min, which must not be negative")
+	case *chaosErrorRate < 0 || *chaosErrorRate > 1:
+		return errors.New("-chaos-error-rate must be between 0 and 1")
+	case *chaosAbortRate < 0 || *chaosAbortRate > 1:
+		return errors.New("-chaos-abort-rate must be between 0 and 1")
+	}
+	slog.Warn("CHAOS MODE ACTIVE: downloads will be delayed and fail on purpose",
+		"latency_min", *chaosLatencyMin, "latency_max", *chaosLatencyMax,
+		"error_rate", *chaosErrorRate, "abort_rate", *chaosAbortRate)
+	return nil
+}
+
+// chaosBeforeServe delays the request and may fail it. It reports false when
+// it answered the request itself.
+func chaosBeforeServe(w http.ResponseWriter, r *http.Request) bool {
+	if !*chaosEnabled {
+		return true
+	}
+
+	if delay := *chaosLatencyMin + rand.N(*chaosLatencyMax-*chaosLatencyMin+1); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return false
+		}
+	}
+
+	if rand.Float64() < *chaosErrorRate {
+		status := http.StatusInternalServerError
+		if rand.IntN(2) == 0 {
+			status = http.StatusServiceUnavailable
+			w.Header().Set("Retry-After", "1")
+		}
+		slog.Info("Chaos: failing download", "file", r.URL.Query().Get("file"), "status", status)
+		http.Error(w, "Injected failure", status)
+		return false
+	}
+	return true
+}
+
+// chaosAbortPoint returns after how many of length bytes the download is
+// cut off, or -1 to let it complete.
+func chaosAbortPoint(length int64) int64 {
+	if !*chaosEnabled || length == 0 || rand.Float64() >= *chaosAbortRate {
+		return -1
+	}
+	return rand.Int64N(length)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"testing"
+)
+
+func TestCharset(t *testing.T) {
+	newTestDir(t)
+	charsetExt := flag.Lookup("charset-ext").Value
+	if err := charsetExt.Set(".csv=windows-1252"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(charsetByExt, ".csv") })
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"notes.txt", "plain text", "text/plain; charset=utf-8"},
+		{"data.json", `{"a":1}`, "application/json; charset=utf-8"},
+		{"table.csv", "a,b\n1,2\n", "text/csv; charset=windows-1252"},
+		{"TABLE.CSV", "a,b\n1,2\n", "text/csv; charset=windows-1252"},
+		{"bom8.csv", "\xef\xbb\xbfa,b\n", "text/csv; charset=utf-8"},
+		{"le.txt", "\xff\xfeh\x00i\x00", "text/plain; charset=utf-16le"},
+		{"be.txt", "\xfe\xff\x00h\x00i", "text/plain; charset=utf-16be"},
+		{"short.txt", "\xff", "text/plain; charset=utf-8"},
+		{"empty.txt", "", "text/plain; charset=utf-8"},
+		{"image.png", "\xff\xfe", "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTestFile(t, tt.name, tt.content)
+			rec := serve(downloadHandler, newRequest("GET", "/download?inline=true&file="+tt.name))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("Content-Type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("attachment", func(t *testing.T) {
+		rec := serve(downloadHandler, newRequest("GET", "/download?file=le.txt"))
+		if got := rec.Header().Get("Content-Type"); got != "application/octet-stream" {
+			t.Errorf("Content-Type = %q, want application/octet-stream", got)
+		}
+	})
+
+	t.Run("invalid flag", func(t *testing.T) {
+		for _, value := range []string{"csv=utf-8", ".csv", ".csv=utf 8", ".csv="} {
+			if err := charsetExt.Set(value); err == nil {
+				t.Errorf("-charset-ext=%s accepted", value)
+			}
+		}
+	})
+}
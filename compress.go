@@ -0,0 +1,45 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var compressOnTheFly = flag.Bool("compress", false, "gzip compressible files on the fly for clients that accept it (ranged requests are always sent uncompressed)")
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+		return gz
+	},
+}
+
+// compressible reports whether a file's type is worth compressing. Formats
+// that are already compressed (images, archives, video) are left alone.
+func compressible(name string) bool {
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(name)))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "json"), strings.HasSuffix(mediaType, "xml"), strings.HasSuffix(mediaType, "javascript"):
+		return true
+	}
+	return false
+}
+
+// newEncoder wraps w in an encoder for a Content-Encoding chosen by
+// serveFile. release must be called once the encoder is no longer used.
+func newEncoder(encoding string, w io.Writer) (enc io.WriteCloser, release func()) {
+	if encoding == "dcz" {
+		dcz := newDCZWriter(w)
+		return dcz, dcz.release
+	}
+	gz := gzipWriters.Get().(*gzip.Writer)
+	gz.Reset(w)
+	return gz, func() { gzipWriters.Put(gz) }
+}
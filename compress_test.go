@@ -0,0 +1,116 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// On-the-fly compression never applies to a Range or offset request, which
+// gets identity bytes with a valid Content-Range instead.
+func TestCompressionSkippedForRanges(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "compress", "true")
+	content := strings.Repeat("compress me please\n", 500)
+	writeTestFile(t, "a.txt", content)
+
+	tests := []struct {
+		name         string
+		url          string
+		headers      []string
+		status       int
+		encoding     string
+		acceptRanges string
+		body         string
+	}{
+		{"whole", "/download?file=a.txt", []string{"Accept-Encoding", "gzip"}, http.StatusOK, "gzip", "none", content},
+		{"range", "/download?file=a.txt", []string{"Accept-Encoding", "gzip", "Range", "bytes=19-37"}, http.StatusPartialContent, "", "bytes", content[19:38]},
+		{"offset", "/download?file=a.txt&offset=19", []string{"Accept-Encoding", "gzip"}, http.StatusOK, "", "bytes", content[19:]},
+		{"gzip not accepted", "/download?file=a.txt", nil, http.StatusOK, "", "bytes", content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest("GET", tt.url, tt.headers...))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if got := rec.Header().Get("Accept-Ranges"); got != tt.acceptRanges {
+				t.Errorf("Accept-Ranges = %q, want %q", got, tt.acceptRanges)
+			}
+
+			var body io.Reader = rec.Body
+			if tt.encoding == "gzip" {
+				if got := rec.Header().Get("Content-Length"); got != "" {
+					t.Errorf("Content-Length = %q on a compressed body", got)
+				}
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("body of %d bytes differs from the expected %d", len(got), len(tt.body))
+			}
+		})
+	}
+}
+
+// /download-compressed always gzips the whole file, while /download stays
+// byte-exact and honours ranges.
+func TestCompressedEndpoint(t *testing.T) {
+	newTestDir(t)
+	content := strings.Repeat("endpoint\n", 300)
+	writeTestFile(t, "a.bin", content)
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		url      string
+		headers  []string
+		status   int
+		encoding string
+		body     string
+	}{
+		{"compressed", compressedDownloadHandler, "/download-compressed?file=a.bin", nil, http.StatusOK, "gzip", content},
+		{"compressed ignores range", compressedDownloadHandler, "/download-compressed?file=a.bin", []string{"Range", "bytes=0-9"}, http.StatusOK, "gzip", content},
+		{"compressed rejects offset", compressedDownloadHandler, "/download-compressed?file=a.bin&offset=5", nil, http.StatusBadRequest, "", ""},
+		{"compressed validates name", compressedDownloadHandler, "/download-compressed?file=../a.bin", nil, http.StatusBadRequest, "", ""},
+		{"plain", downloadHandler, "/download?file=a.bin", []string{"Accept-Encoding", "gzip"}, http.StatusOK, "", content},
+		{"plain range", downloadHandler, "/download?file=a.bin", []string{"Range", "bytes=0-9"}, http.StatusPartialContent, "", content[:10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.handler, newRequest("GET", tt.url, tt.headers...))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status >= 400 {
+				return
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			var body io.Reader = rec.Body
+			if tt.encoding == "gzip" {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			if got, _ := io.ReadAll(body); string(got) != tt.body {
+				t.Errorf("body of %d bytes, want %d", len(got), len(tt.body))
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// concatHandler handles GET /concat?file=a&file=b and streams the files back
+// to back as one body, e.g. to reassemble split archives. Every file is
+// opened before the response starts so Content-Length is exact; a missing
+// file fails the request unless ?skip-missing=true. A Range refers to the
+// concatenated stream, so interrupted downloads can be resumed.
+func concatHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	names := query["file"]
+	if len(names) == 0 {
+		writeValidationError(w, invalidParam("file", "is required"))
+		return
+	}
+	if verr := checkFileCount(len(names)); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+	skipMissing := query.Get("skip-missing") == "true"
+
+	type part struct {
+		name    string
+		file    *os.File
+		size    int64
+		modTime time.Time
+	}
+	var parts []part
+	defer func() {
+		for _, p := range parts {
+			p.file.Close()
+		}
+	}()
+
+	var total int64
+	var skipped []string
+	for _, name := range names {
+		filePath, verr := validateFileParam("file", name)
+		if verr != nil {
+			writeValidationError(w, verr)
+			return
+		}
+
+		file, err := os.Open(filePath)
+		var stat os.FileInfo
+		if err == nil {
+			stat, err = file.Stat()
+			if err == nil && !stat.Mode().IsRegular() {
+				err = errNotAFile
+			}
+			if err != nil {
+				file.Close()
+			}
+		}
+		if err != nil {
+			if skipMissing && (os.IsNotExist(err) || err == errNotAFile) {
+				skipped = append(skipped, name)
+				continue
+			}
+			if os.IsNotExist(err) || err == errNotAFile {
+				http.Error(w, fmt.Sprintf("File not found: %s", name), http.StatusNotFound)
+			} else {
+				writeStorageError(w, name, err)
+			}
+			return
+		}
+
+		parts = append(parts, part{name: name, file: file, size: stat.Size(), modTime: stat.ModTime()})
+		total += stat.Size()
+	}
+	if verr := checkTotalSize(total); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	// The validator covers every part, so a resumed download notices when
+	// any of them changed
+	tag := sha256.New()
+	var modTime time.Time
+	sizes := make([]int64, len(parts))
+	for i, p := range parts {
+		fmt.Fprintf(tag, "%s\x00%d\x00%d\n", p.name, p.size, p.modTime.UnixNano())
+		if p.modTime.After(modTime) {
+			modTime = p.modTime
+		}
+		sizes[i] = p.size
+	}
+	etag := `"` + hex.EncodeToString(tag.Sum(nil)[:16]) + `"`
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="concat.bin"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	if len(skipped) > 0 {
+		w.Header().Set("X-Skipped-Files", strings.Join(skipped, ","))
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, etag, modTime) {
+		rangeHeader = ""
+	}
+	start, length := int64(0), total
+	status := http.StatusOK
+	rangeStart, rangeLength, ok, err := parseRange(rangeHeader, total)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+		http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if ok {
+		start, length = rangeStart, rangeLength
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, total))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	applyExtraHeaders(w)
+	w.WriteHeader(status)
+
+	for _, span := range concatSpans(sizes, start, length) {
+		p := parts[span.part]
+		// The size is fixed by the header already sent; a file that
+		// shrank since cannot be made up for, so the response is cut short
+		n, err := io.Copy(w, io.NewSectionReader(p.file, span.offset, span.length))
+		if err == nil && n < span.length {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			slog.Warn("Concatenated download aborted", "file", p.name, "error", err)
+			return
+		}
+	}
+	slog.Debug("Completed concatenated download", "files", len(parts), "bytes", length)
+}
+
+// concatSpan is the piece of one part that falls inside a requested range.
+type concatSpan struct {
+	part   int
+	offset int64
+	length int64
+}
+
+// concatSpans maps the byte range [start, start+length) of the
+// concatenation of parts with the given sizes onto the parts themselves.
+// Empty parts and parts outside the range are left out.
+func concatSpans(sizes []int64, start, length int64) []concatSpan {
+	var spans []concatSpan
+	end := start + length
+	var partStart int64
+	for i, size := range sizes {
+		partEnd := partStart + size
+		if partEnd > start && partStart < end {
+			from := max(start, partStart)
+			to := min(end, partEnd)
+			spans = append(spans, concatSpan{part: i, offset: from - partStart, length: to - from})
+		}
+		if partEnd >= end {
+			break
+		}
+		partStart = partEnd
+	}
+	return spans
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestConcat(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "part1", "hello ")
+	writeTestFile(t, "part2", "world")
+
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		body    string
+		skipped string
+	}{
+		{"two files", "file=part1&file=part2", http.StatusOK, "hello world", ""},
+		{"order kept", "file=part2&file=part1", http.StatusOK, "worldhello ", ""},
+		{"missing file", "file=part1&file=nope&file=part2", http.StatusNotFound, "", ""},
+		{"missing skipped", "file=part1&file=nope&file=part2&skip-missing=true", http.StatusOK, "hello world", "nope"},
+		{"traversal", "file=part1&file=../part2", http.StatusBadRequest, "", ""},
+		{"no files", "", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(concatHandler, newRequest("GET", "/concat?"+tt.query))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if got, want := rec.Header().Get("Content-Length"), "11"; got != want {
+				t.Errorf("Content-Length = %q, want %q", got, want)
+			}
+			if got := rec.Header().Get("X-Skipped-Files"); got != tt.skipped {
+				t.Errorf("X-Skipped-Files = %q, want %q", got, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestConcatRange(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "p1", "abc")
+	writeTestFile(t, "p2", "defg")
+	writeTestFile(t, "p3", "hij")
+	const url = "/concat?file=p1&file=p2&file=p3"
+	etag := serve(concatHandler, newRequest("GET", url)).Header().Get("ETag")
+
+	tests := []struct {
+		name         string
+		headers      []string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"within first file", []string{"Range", "bytes=0-1"}, http.StatusPartialContent, "ab", "bytes 0-1/10"},
+		{"within middle file", []string{"Range", "bytes=4-5"}, http.StatusPartialContent, "ef", "bytes 4-5/10"},
+		{"spanning two files", []string{"Range", "bytes=2-4"}, http.StatusPartialContent, "cde", "bytes 2-4/10"},
+		{"spanning three files", []string{"Range", "bytes=1-8"}, http.StatusPartialContent, "bcdefghi", "bytes 1-8/10"},
+		{"file boundary", []string{"Range", "bytes=3-6"}, http.StatusPartialContent, "defg", "bytes 3-6/10"},
+		{"open ended", []string{"Range", "bytes=7-"}, http.StatusPartialContent, "hij", "bytes 7-9/10"},
+		{"suffix", []string{"Range", "bytes=-2"}, http.StatusPartialContent, "ij", "bytes 8-9/10"},
+		{"end clamped", []string{"Range", "bytes=5-100"}, http.StatusPartialContent, "fghij", "bytes 5-9/10"},
+		{"unsatisfiable", []string{"Range", "bytes=10-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"if-range match", []string{"Range", "bytes=2-4", "If-Range", etag}, http.StatusPartialContent, "cde", "bytes 2-4/10"},
+		{"if-range stale", []string{"Range", "bytes=2-4", "If-Range", `"stale"`}, http.StatusOK, "abcdefghij", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(concatHandler, newRequest("GET", url, tt.headers...))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if tt.status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(len(tt.body)); got != want {
+				t.Errorf("Content-Length = %q, want %q", got, want)
+			}
+		})
+	}
+}
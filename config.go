@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+var (
+	configFile     = flag.String("config", "", "optional JSON file with settings that can be reloaded at runtime (headers, allowed_referers, total_rate, per_file_limit, tiers, default_tier)")
+	adminTokenFile = flag.String("admin-token-file", "", "file with one admin bearer token per line; the admin API is disabled without it")
+)
+
+// runtimeConfig holds the settings that can change without a restart. It is
+// built from the flags, overlaid with -config, and swapped atomically.
+type runtimeConfig struct {
+	headers      []staticHeader
+	refererHosts []string
+	totalRate    int64
+	perFileLimit int
+	adminTokens  map[string]bool
+	tiers        *tierTable
+}
+
+// configFileContents mirrors the -config file. Absent fields keep the value
+// given on the command line.
+type configFileContents struct {
+	Headers         []string `json:"headers"`
+	AllowedReferers []string `json:"allowed_referers"`
+	TotalRate       *int64   `json:"total_rate"`
+	PerFileLimit    *int     `json:"per_file_limit"`
+
+	Tiers       map[string]tierConfig `json:"tiers"`
+	DefaultTier string                `json:"default_tier"`
+}
+
+var (
+	activeConfig atomic.Pointer[runtimeConfig]
+
+	// reloadMu serializes reloads so summaries describe a single change.
+	reloadMu sync.Mutex
+)
+
+func currentConfig() *runtimeConfig {
+	return activeConfig.Load()
+}
+
+// loadRuntimeConfig reads the mutable settings from their sources.
+func loadRuntimeConfig() (*runtimeConfig, error) {
+	cfg := &runtimeConfig{
+		headers:      slices.Clone(extraHeaders),
+		refererHosts: parseHostList(*allowedReferers),
+		totalRate:    *totalRate,
+		perFileLimit: *perFileLimit,
+		adminTokens:  make(map[string]bool),
+	}
+	cfg.tiers, _ = newTierTable(nil, "")
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var contents configFileContents
+		if err := json.Unmarshal(data, &contents); err != nil {
+			return nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+
+		if contents.Headers != nil {
+			cfg.headers = nil
+			for _, h := range contents.Headers {
+				sh, err := parseStaticHeader(h)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", *configFile, err)
+				}
+				cfg.headers = append(cfg.headers, sh)
+			}
+		}
+		if contents.AllowedReferers != nil {
+			cfg.refererHosts = parseHostList(strings.Join(contents.AllowedReferers, ","))
+		}
+		if contents.TotalRate != nil {
+			cfg.totalRate = *contents.TotalRate
+		}
+		if contents.PerFileLimit != nil {
+			cfg.perFileLimit = *contents.PerFileLimit
+		}
+		if cfg.tiers, err = newTierTable(contents.Tiers, contents.DefaultTier); err != nil {
+			return nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+
+	if *adminTokenFile != "" {
+		file, err := os.Open(*adminTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				cfg.adminTokens[line] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// applyRuntimeConfig installs cfg and propagates it to components that keep
+// their own state.
+func applyRuntimeConfig(cfg *runtimeConfig) {
+	activeConfig.Store(cfg)
+	egress.setRate(cfg.totalRate)
+}
+
+// reloadConfig re-reads the mutable settings and returns a description of
+// what changed. On error the running configuration is left untouched.
+func reloadConfig() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := loadRuntimeConfig()
+	if err != nil {
+		slog.Error("Configuration reload failed", "error", err)
+		return nil, err
+	}
+
+	changes := diffConfig(currentConfig(), next)
+	applyRuntimeConfig(next)
+
+	if len(changes) == 0 {
+		slog.Info("Configuration reloaded, nothing changed")
+	} else {
+		slog.Info("Configuration reloaded", "changes", strings.Join(changes, "; "))
+	}
+	return changes, nil
+}
+
+func diffConfig(old, next *runtimeConfig) []string {
+	changes := []string{}
+
+	formatHeaders := func(hs []staticHeader) string {
+		l := headerList(hs)
+		return l.String()
+	}
+	if a, b := formatHeaders(old.headers), formatHeaders(next.headers); a != b {
+		changes = append(changes, fmt.Sprintf("headers: [%s] -> [%s]", a, b))
+	}
+	if a, b := strings.Join(old.refererHosts, ","), strings.Join(next.refererHosts, ","); a != b {
+		changes = append(changes, fmt.Sprintf("allowed_referers: [%s] -> [%s]", a, b))
+	}
+	if old.totalRate != next.totalRate {
+		changes = append(changes, fmt.Sprintf("total_rate: %d -> %d", old.totalRate, next.totalRate))
+	}
+	if old.perFileLimit != next.perFileLimit {
+		changes = append(changes, fmt.Sprintf("per_file_limit: %d -> %d", old.perFileLimit, next.perFileLimit))
+	}
+	if a, b := old.tiers.describe(), next.tiers.describe(); a != b {
+		changes = append(changes, fmt.Sprintf("tiers: %s -> %s", a, b))
+	}
+
+	added, removed := 0, 0
+	for token := range next.adminTokens {
+		if !old.adminTokens[token] {
+			added++
+		}
+	}
+	for token := range old.adminTokens {
+		if !next.adminTokens[token] {
+			removed++
+		}
+	}
+	if added > 0 || removed > 0 {
+		changes = append(changes, fmt.Sprintf("admin tokens: %d added, %d removed", added, removed))
+	}
+
+	return changes
+}
+
+// watchSIGHUP reloads the configuration whenever the process gets SIGHUP.
+func watchSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			slog.Info("Received SIGHUP, reloading configuration")
+			reloadConfig()
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var maxConns = flag.Int("max-conns", 0, "maximum simultaneous client connections; further connections wait in the listen backlog (0 = unlimited)")
+
+// openConns is the number of accepted connections not yet closed.
+var openConns atomic.Int64
+
+// limitListener counts open connections and, with a limit, stops accepting
+// while it is reached, like x/net/netutil.LimitListener. Waiting clients
+// stay in the kernel's listen backlog rather than using a file descriptor.
+type limitListener struct {
+	net.Listener
+	sem       chan struct{} // nil when unlimited
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLimitListener(l net.Listener, n int) *limitListener {
+	ll := &limitListener{Listener: l, done: make(chan struct{})}
+	if n > 0 {
+		ll.sem = make(chan struct{}, n)
+	}
+	return ll
+}
+
+func (l *limitListener) acquire() bool {
+	if l.sem == nil {
+		return true
+	}
+	select {
+	case <-l.done:
+		return false
+	case l.sem <- struct{}{}:
+		return true
+	}
+}
+
+func (l *limitListener) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	if !l.acquire() {
+		// The listener is closed; let the embedded Accept report it
+		return l.Listener.Accept()
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	openConns.Add(1)
+	return &limitConn{Conn: c, release: l.release}, nil
+}
+
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(func() {
+		openConns.Add(-1)
+		c.release()
+	})
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptAll accepts connections from l until it is closed.
+func acceptAll(l net.Listener) <-chan net.Conn {
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	return accepted
+}
+
+// countAccepted returns how many connections are accepted within wait.
+func countAccepted(accepted <-chan net.Conn, wait time.Duration, conns *[]net.Conn) int {
+	n := 0
+	timeout := time.After(wait)
+	for {
+		select {
+		case c := <-accepted:
+			*conns = append(*conns, c)
+			n++
+		case <-timeout:
+			return n
+		}
+	}
+}
+
+func TestLimitListener(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		accepted int
+	}{
+		{"unlimited", 0, 3},
+		{"limit reached", 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := newLimitListener(inner, tt.limit)
+			defer l.Close()
+			accepted := acceptAll(l)
+			before := openConns.Load()
+
+			for range 3 {
+				c, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close()
+			}
+			var conns []net.Conn
+			if n := countAccepted(accepted, 100*time.Millisecond, &conns); n != tt.accepted {
+				t.Fatalf("accepted %d connections, want %d", n, tt.accepted)
+			}
+			if got := openConns.Load() - before; got != int64(tt.accepted) {
+				t.Errorf("open connections = %d, want %d", got, tt.accepted)
+			}
+
+			// Closing one frees a slot for the connection held back
+			conns[0].Close()
+			want := 0
+			if tt.accepted < 3 {
+				want = 1
+			}
+			if n := countAccepted(accepted, 100*time.Millisecond, &conns); n != want {
+				t.Errorf("accepted %d more after a close, want %d", n, want)
+			}
+			for _, c := range conns {
+				c.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	dashboardEnabled = flag.Bool("dashboard", false, "serve a live stats page at GET / instead of index.html")
+	dashboardAuth    = flag.Bool("dashboard-auth", false, "require an admin token for the dashboard, as a bearer token or as the Basic auth password")
+)
+
+const (
+	// dashboardRefresh is how often the page reloads itself.
+	dashboardRefresh = 5 * time.Second
+	// dashboardRecent bounds the finished downloads listed.
+	dashboardRecent = 20
+)
+
+var dashboardTemplate = template.Must(template.New("dashboard.html").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"seconds": func(s float64) string {
+		return time.Duration(s * float64(time.Second)).Round(100 * time.Millisecond).String()
+	},
+}).ParseFS(builtinFS, "builtin/dashboard.html"))
+
+type dashboardData struct {
+	RefreshSeconds int
+	Uptime         time.Duration
+	Draining       bool
+	ReadOnly       bool
+	ActiveWorkers  int
+	AllowedWorkers int
+	QueueLength    int
+	Active         []activeDownloadInfo
+	Recent         []finishedDownload
+}
+
+// dashboardHandler handles GET / with -dashboard. It shows the same numbers
+// as /health and GET /admin/downloads, without client addresses.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	active, recent := downloads.snapshot()
+	if len(recent) > dashboardRecent {
+		recent = recent[:dashboardRecent]
+	}
+
+	data := dashboardData{
+		RefreshSeconds: int(dashboardRefresh.Seconds()),
+		Uptime:         time.Since(serverStart).Round(time.Second),
+		Draining:       draining.Load(),
+		ReadOnly:       *readOnly,
+		ActiveWorkers:  workers.activeCount(),
+		AllowedWorkers: allowedWorkers(),
+		QueueLength:    queueLength(),
+		Active:         active,
+		Recent:         recent,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		slog.Warn("Failed to render dashboard", "error", err)
+	}
+}
+
+// requireDashboardAuth guards the dashboard with the admin tokens.
+// Browsers cannot attach bearer tokens to a plain page load, so the token is
+// also accepted as the password of Basic auth, which they prompt for.
+func requireDashboardAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens := currentConfig().adminTokens
+		if len(tokens) == 0 {
+			http.Error(w, "Admin API is not configured", http.StatusForbidden)
+			return
+		}
+
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, presented, ok = r.BasicAuth()
+		}
+		if !ok || !adminTokenValid(presented, tokens) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dashboard", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// formatBytes renders a byte count with a binary unit.
+func formatBytes(n any) string {
+	var v float64
+	switch n := n.(type) {
+	case int64:
+		v = float64(n)
+	case float64:
+		v = n
+	}
+	const units = "KMGTPE"
+	if v < 1024 {
+		return fmt.Sprintf("%.0f B", v)
+	}
+	i := -1
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", v, units[i])
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Shared dictionary compression follows Compression Dictionary Transport
+// (RFC 9842). Go has no Brotli encoder that accepts a custom dictionary, so
+// only the zstd flavour, "dcz", is offered; clients without the dictionary
+// get plain gzip.
+var (
+	compressionDict      = flag.String("compression-dict", "", "file used as a shared zstd dictionary for -compress; clients holding it get dcz responses (empty = disabled)")
+	compressionDictMatch = flag.String("compression-dict-match", "/download*", "URL pattern, below -base-path, sent in Use-As-Dictionary telling clients which requests the dictionary applies to")
+)
+
+// maxDictionarySize bounds -compression-dict. Every encoder keeps its own
+// reference to the dictionary as history, so it should stay small.
+const maxDictionarySize = 16 << 20
+
+// dczMagic starts every dcz body, followed by the dictionary's SHA-256.
+var dczMagic = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+
+var (
+	dictContent []byte
+	dictHash    [sha256.Size]byte
+	// dictHashField is the hash as the structured field byte sequence
+	// clients send in Available-Dictionary
+	dictHashField string
+	dictEncoders  sync.Pool
+)
+
+// loadCompressionDict reads -compression-dict. The file is used byte for
+// byte as compression history, so the best dictionary is simply a sample of
+// typical content, e.g. a few representative files concatenated, or one
+// trained with "zstd --train" and stored raw.
+func loadCompressionDict() error {
+	if *compressionDict == "" {
+		return nil
+	}
+	if !*compressOnTheFly {
+		return errors.New("-compression-dict requires -compress")
+	}
+	content, err := os.ReadFile(*compressionDict)
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 || len(content) > maxDictionarySize {
+		return fmt.Errorf("dictionary must be between 1 byte and %d bytes, got %d", maxDictionarySize, len(content))
+	}
+
+	dictContent = content
+	dictHash = sha256.Sum256(content)
+	dictHashField = ":" + base64.StdEncoding.EncodeToString(dictHash[:]) + ":"
+	dictEncoders.New = func() any {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderDictRaw(0, dictContent), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			panic(err) // the options are fixed and were checked at startup
+		}
+		return enc
+	}
+	dictEncoders.Put(dictEncoders.New())
+	return nil
+}
+
+// dictionaryAvailable reports whether the client holds our dictionary and
+// can decode dcz.
+func dictionaryAvailable(r *http.Request) bool {
+	return dictContent != nil &&
+		strings.TrimSpace(r.Header.Get("Available-Dictionary")) == dictHashField &&
+		acceptsEncoding(r, "dcz")
+}
+
+// advertiseDictionary points clients that do not have the dictionary yet
+// at GET /compression-dictionary.
+func advertiseDictionary(w http.ResponseWriter) {
+	if dictContent != nil {
+		w.Header().Add("Vary", "Available-Dictionary")
+		w.Header().Add("Link", "<"+urlPath("/compression-dictionary")+`>; rel="compression-dictionary"`)
+	}
+}
+
+// dictionaryHandler handles GET /compression-dictionary.
+func dictionaryHandler(w http.ResponseWriter, r *http.Request) {
+	etag := `"` + base64.RawURLEncoding.EncodeToString(dictHash[:]) + `"`
+	w.Header().Set("Use-As-Dictionary", "match="+strconv.Quote(urlPath(*compressionDictMatch)))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if etagWeakMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(dictContent)))
+	w.Write(dictContent)
+}
+
+// dczWriter writes the dcz header ahead of the zstd stream.
+type dczWriter struct {
+	enc           *zstd.Encoder
+	out           io.Writer
+	headerWritten bool
+}
+
+func newDCZWriter(out io.Writer) *dczWriter {
+	enc := dictEncoders.Get().(*zstd.Encoder)
+	enc.Reset(out)
+	return &dczWriter{enc: enc, out: out}
+}
+
+func (d *dczWriter) writeHeader() error {
+	if d.headerWritten {
+		return nil
+	}
+	d.headerWritten = true
+	if _, err := d.out.Write(dczMagic); err != nil {
+		return err
+	}
+	_, err := d.out.Write(dictHash[:])
+	return err
+}
+
+func (d *dczWriter) Write(p []byte) (int, error) {
+	if err := d.writeHeader(); err != nil {
+		return 0, err
+	}
+	return d.enc.Write(p)
+}
+
+func (d *dczWriter) Close() error {
+	if err := d.writeHeader(); err != nil {
+		return err
+	}
+	return d.enc.Close()
+}
+
+// release returns the encoder to the pool.
+func (d *dczWriter) release() {
+	d.enc.Reset(nil)
+	dictEncoders.Put(d.enc)
+}
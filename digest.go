@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	sendDigest      = flag.Bool("digest", false, "send a Digest: sha-256=<base64> header (RFC 3230) with uncompressed downloads")
+	digestSyncLimit = flag.Int64("digest-sync-limit", 16<<20, "files up to this many bytes are hashed before responding when no digest is cached; larger ones are hashed in the background and get the header once done")
+	alwaysDigest    = flag.Bool("always-digest", false, "hash files of any size before responding when no digest is cached")
+)
+
+// digestBackgroundWorkers bounds how many large files are hashed at once.
+const digestBackgroundWorkers = 2
+
+// cachedDigest is the Digest header value for one version of a file.
+type cachedDigest struct {
+	size    int64
+	modTime time.Time
+	value   string
+}
+
+var (
+	digestsMu sync.Mutex
+	digests   = make(map[string]cachedDigest)
+	// digestsPending holds paths being hashed in the background.
+	digestsPending = make(map[string]bool)
+
+	digestSlots = make(chan struct{}, digestBackgroundWorkers)
+)
+
+// fileDigest returns the Digest header value for the file at filePath. A
+// cached value is used while the size and modification time match. Without
+// one, small files (or any file with -always-digest) are hashed now; larger
+// files report false and are hashed in the background for later requests.
+func fileDigest(filePath string, file io.ReaderAt, stat os.FileInfo) (string, bool) {
+	if value, ok := lookupDigest(filePath, stat); ok {
+		return value, true
+	}
+
+	if !*alwaysDigest && stat.Size() > *digestSyncLimit {
+		hashInBackground(filePath)
+		return "", false
+	}
+
+	value, err := computeDigest(file, stat.Size())
+	if err != nil {
+		slog.Warn("Failed to compute digest", "path", filePath, "error", err)
+		return "", false
+	}
+	storeDigest(filePath, stat, value)
+	return value, true
+}
+
+// lookupDigest returns the cached digest of this version of filePath
+// without computing one.
+func lookupDigest(filePath string, stat os.FileInfo) (string, bool) {
+	digestsMu.Lock()
+	cached, ok := digests[filePath]
+	digestsMu.Unlock()
+	if ok && cached.size == stat.Size() && cached.modTime.Equal(stat.ModTime()) {
+		return cached.value, true
+	}
+	return "", false
+}
+
+func computeDigest(file io.ReaderAt, size int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, size)); err != nil {
+		return "", err
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+func storeDigest(filePath string, stat os.FileInfo, value string) {
+	digestsMu.Lock()
+	digests[filePath] = cachedDigest{size: stat.Size(), modTime: stat.ModTime(), value: value}
+	digestsMu.Unlock()
+}
+
+// hashInBackground computes the digest of filePath unless that is already
+// under way.
+func hashInBackground(filePath string) {
+	digestsMu.Lock()
+	if digestsPending[filePath] {
+		digestsMu.Unlock()
+		return
+	}
+	digestsPending[filePath] = true
+	digestsMu.Unlock()
+
+	go func() {
+		defer func() {
+			digestsMu.Lock()
+			delete(digestsPending, filePath)
+			digestsMu.Unlock()
+		}()
+
+		digestSlots <- struct{}{}
+		defer func() { <-digestSlots }()
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			return
+		}
+
+		start := time.Now()
+		value, err := computeDigest(file, stat.Size())
+		if err != nil {
+			slog.Warn("Failed to compute digest", "path", filePath, "error", err)
+			return
+		}
+		storeDigest(filePath, stat, value)
+		slog.Debug("Computed digest", "path", filePath, "size", stat.Size(), "duration", time.Since(start))
+	}()
+}
+
+// forgetDigest drops the cached digest of filePath.
+func forgetDigest(filePath string) {
+	digestsMu.Lock()
+	defer digestsMu.Unlock()
+	delete(digests, filePath)
+}
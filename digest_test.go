@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sha256Digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestDigestHeader(t *testing.T) {
+	content := strings.Repeat("digest me\n", 100)
+	tests := []struct {
+		name    string
+		flags   []string
+		headers []string
+		want    string
+	}{
+		{"disabled", nil, nil, ""},
+		{"whole file", []string{"digest", "true"}, nil, sha256Digest(content)},
+		{"range still describes the file", []string{"digest", "true"}, []string{"Range", "bytes=0-9"}, sha256Digest(content)},
+		{"large file skipped", []string{"digest", "true", "digest-sync-limit", "10"}, nil, ""},
+		{"large file forced", []string{"digest", "true", "digest-sync-limit", "10", "always-digest", "true"}, nil, sha256Digest(content)},
+		{"compressed body", []string{"digest", "true", "compress", "true"}, []string{"Accept-Encoding", "gzip"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDir(t)
+			writeTestFile(t, "a.txt", content)
+			for i := 0; i < len(tt.flags); i += 2 {
+				setFlag(t, tt.flags[i], tt.flags[i+1])
+			}
+
+			rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt", tt.headers...))
+			if rec.Code >= 300 {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := rec.Header().Get("Digest"); got != tt.want {
+				t.Errorf("Digest = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// A large file is hashed in the background and gets the header on a later
+// request; a changed file gets a fresh digest instead of the cached one.
+func TestDigestCache(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "digest", "true")
+	setFlag(t, "digest-sync-limit", "10")
+	content := strings.Repeat("large\n", 100)
+	path := writeTestFile(t, "a.txt", content)
+
+	get := func() string {
+		rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		return rec.Header().Get("Digest")
+	}
+
+	if got := get(); got != "" {
+		t.Fatalf("first request Digest = %q, want none while hashing", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	got := get()
+	for got == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		got = get()
+	}
+	if want := sha256Digest(content); got != want {
+		t.Fatalf("Digest = %q, want %q", got, want)
+	}
+
+	setFlag(t, "digest-sync-limit", "1048576")
+	changed := strings.Repeat("LARGE\n", 100)
+	writeTestFile(t, "a.txt", changed)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if got, want := get(), sha256Digest(changed); got != want {
+		t.Errorf("Digest after change = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system holding path.
+func freeSpace(path string) (uint64, bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, true, err
+	}
+	return st.Bavail * uint64(st.Bsize), true, nil
+}
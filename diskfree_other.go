@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// freeSpace is only implemented on Linux; elsewhere it reports false.
+func freeSpace(path string) (uint64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	defaultDisposition = flag.String("disposition", "attachment", "default Content-Disposition for downloads: attachment or inline")
+
+	dispositionByExt = make(map[string]string)
+	charsetByExt     = make(map[string]string)
+)
+
+func init() {
+	flag.Func("disposition-ext", "per-extension Content-Disposition as `.ext=inline|attachment` (repeatable)", func(s string) error {
+		ext, mode, found := strings.Cut(s, "=")
+		if !found || !strings.HasPrefix(ext, ".") || !validDisposition(mode) {
+			return fmt.Errorf("want .ext=inline or .ext=attachment, got %q", s)
+		}
+		dispositionByExt[strings.ToLower(ext)] = mode
+		return nil
+	})
+	flag.Func("charset-ext", "charset for inline text files with an extension as `.ext=charset`, e.g. .csv=windows-1252 (repeatable; default utf-8)", func(s string) error {
+		ext, charset, found := strings.Cut(s, "=")
+		if !found || !strings.HasPrefix(ext, ".") || !validCharset(charset) {
+			return fmt.Errorf("want .ext=charset, got %q", s)
+		}
+		charsetByExt[strings.ToLower(ext)] = strings.ToLower(charset)
+		return nil
+	})
+}
+
+// validCharset accepts names made of the characters IANA charset names use.
+func validCharset(charset string) bool {
+	if charset == "" {
+		return false
+	}
+	for _, c := range charset {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("-_.:+", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func validDisposition(mode string) bool {
+	return mode == "attachment" || mode == "inline"
+}
+
+// dispositionFor picks attachment or inline for a download. Precedence:
+// the ?inline= query parameter, then an -disposition-ext override, then the
+// -disposition default.
+func dispositionFor(r *http.Request, name string) string {
+	if v := r.URL.Query().Get("inline"); v != "" {
+		if inline, err := strconv.ParseBool(v); err == nil {
+			if inline {
+				return "inline"
+			}
+			return "attachment"
+		}
+	}
+
+	if mode, ok := dispositionByExt[strings.ToLower(filepath.Ext(name))]; ok {
+		return mode
+	}
+	return *defaultDisposition
+}
+
+// contentDisposition builds the Content-Disposition header for a file. The
+// quoted filename is an ASCII-only fallback with quotes, backslashes and
+// control characters replaced, so no name can break out of the header. When
+// that loses anything, the exact name follows as RFC 5987 filename*.
+func contentDisposition(disposition, name string) string {
+	base := filepath.Base(name)
+
+	var fallback strings.Builder
+	for _, r := range base {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			fallback.WriteByte('_')
+		} else {
+			fallback.WriteRune(r)
+		}
+	}
+
+	header := fmt.Sprintf("%s; filename=\"%s\"", disposition, fallback.String())
+	if fallback.String() != base {
+		header += "; filename*=UTF-8''" + encodeRFC5987(base)
+	}
+	return header
+}
+
+// encodeRFC5987 percent-encodes every byte outside attr-char.
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// contentTypeFor returns the type to send for a download. Attachments stay
+// application/octet-stream; inline files need their real type to render.
+// Text types also get a charset, see charsetFor.
+func contentTypeFor(disposition, name string, file io.ReaderAt) string {
+	if disposition != "inline" {
+		return "application/octet-stream"
+	}
+	t := mime.TypeByExtension(filepath.Ext(name))
+	if t == "" {
+		return "application/octet-stream"
+	}
+	if !compressible(name) {
+		return t
+	}
+
+	mediaType, params, err := mime.ParseMediaType(t)
+	if err != nil {
+		return t
+	}
+	params["charset"] = charsetFor(name, file)
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// charsetFor picks the charset of a text file: a byte order mark wins, then
+// an -charset-ext override, then UTF-8.
+func charsetFor(name string, file io.ReaderAt) string {
+	var bom [3]byte
+	n, _ := file.ReadAt(bom[:], 0)
+	switch {
+	case n >= 3 && bom == [3]byte{0xef, 0xbb, 0xbf}:
+		return "utf-8"
+	case n >= 2 && bom[0] == 0xfe && bom[1] == 0xff:
+		return "utf-16be"
+	case n >= 2 && bom[0] == 0xff && bom[1] == 0xfe:
+		return "utf-16le"
+	}
+	if charset, ok := charsetByExt[strings.ToLower(filepath.Ext(name))]; ok {
+		return charset
+	}
+	return "utf-8"
+}
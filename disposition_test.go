@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"`},
+		{"dir/report.pdf", `attachment; filename="report.pdf"`},
+		{`say "hi".txt`, `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`},
+		{"a\r\nSet-Cookie: x=1.txt", `attachment; filename="a__Set-Cookie: x=1.txt"; filename*=UTF-8''a%0D%0ASet-Cookie%3A%20x%3D1.txt`},
+		{"bell\x07.txt", `attachment; filename="bell_.txt"; filename*=UTF-8''bell%07.txt`},
+		{`back\slash.txt`, `attachment; filename="back_slash.txt"; filename*=UTF-8''back%5Cslash.txt`},
+		{"报告.txt", `attachment; filename="__.txt"; filename*=UTF-8''%E6%8A%A5%E5%91%8A.txt`},
+	}
+	for _, tt := range tests {
+		got := contentDisposition("attachment", tt.name)
+		if got != tt.want {
+			t.Errorf("contentDisposition(%q) =\n  %s\nwant\n  %s", tt.name, got, tt.want)
+		}
+		if strings.ContainsAny(got, "\r\n\x00\x07") {
+			t.Errorf("contentDisposition(%q) contains control characters", tt.name)
+		}
+	}
+}
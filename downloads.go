@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	recentDownloads = flag.Int("recent-downloads", 100, "number of finished downloads kept for GET /admin/downloads")
+	redactIPs       = flag.Bool("redact-ips", false, "mask client addresses in GET /admin/downloads (IPv4 to /24, IPv6 to /48)")
+)
+
+const (
+	downloadCompleted = "completed"
+	downloadCancelled = "cancelled" // the client went away
+	downloadAborted   = "aborted"   // the server gave up, e.g. slow client
+	downloadFailed    = "failed"    // storage error
+)
+
+// activeDownload is a transfer that is currently streaming.
+type activeDownload struct {
+	id      uint64
+	file    string
+	ip      string
+	started time.Time
+	bytes   atomic.Int64
+	sent    atomic.Int64 // on the wire, after any content encoding
+}
+
+type activeDownloadInfo struct {
+	ID             string  `json:"id"`
+	File           string  `json:"file"`
+	IP             string  `json:"ip"`
+	Bytes          int64   `json:"bytes"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+type finishedDownload struct {
+	File            string    `json:"file"`
+	Status          string    `json:"status"`
+	Bytes           int64     `json:"bytes"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	FinishedAt      time.Time `json:"finished_at"`
+}
+
+// downloadRegistry tracks active transfers and a ring buffer of the most
+// recently finished ones.
+type downloadRegistry struct {
+	nextID atomic.Uint64
+
+	mu     sync.Mutex
+	active map[uint64]*activeDownload
+	recent []finishedDownload
+	next   int
+
+	// totals counts finished downloads and their bytes by status
+	totals map[string]*downloadTotals
+}
+
+type downloadTotals struct {
+	count int64
+	bytes int64
+}
+
+var downloads = &downloadRegistry{
+	active: make(map[uint64]*activeDownload),
+	totals: make(map[string]*downloadTotals),
+}
+
+// clientIP is the address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func (reg *downloadRegistry) start(r *http.Request, file string) *activeDownload {
+	d := &activeDownload{
+		id:      reg.nextID.Add(1),
+		file:    file,
+		ip:      clientIP(r),
+		started: time.Now(),
+	}
+
+	reg.mu.Lock()
+	reg.active[d.id] = d
+	reg.mu.Unlock()
+	return d
+}
+
+func (reg *downloadRegistry) finish(d *activeDownload, status string) {
+	entry := finishedDownload{
+		File:            d.file,
+		Status:          status,
+		Bytes:           d.bytes.Load(),
+		DurationSeconds: time.Since(d.started).Seconds(),
+		FinishedAt:      time.Now(),
+	}
+
+	if status == downloadCompleted {
+		downloadDurations.record(entry.DurationSeconds, entry.Bytes)
+	}
+	statsd.recordDownload(status, entry.Bytes, time.Since(d.started))
+	if status == downloadCompleted && *adaptiveCompression {
+		clientSpeeds.record(d.ip, d.sent.Load(), time.Since(d.started))
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	delete(reg.active, d.id)
+
+	t := reg.totals[status]
+	if t == nil {
+		t = &downloadTotals{}
+		reg.totals[status] = t
+	}
+	t.count++
+	t.bytes += entry.Bytes
+
+	if *recentDownloads <= 0 {
+		return
+	}
+	if len(reg.recent) < *recentDownloads {
+		reg.recent = append(reg.recent, entry)
+		return
+	}
+	reg.recent[reg.next] = entry
+	reg.next = (reg.next + 1) % len(reg.recent)
+}
+
+func (reg *downloadRegistry) activeCount() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return len(reg.active)
+}
+
+// snapshot returns the active transfers, oldest first, and the finished
+// ones, newest first.
+func (reg *downloadRegistry) snapshot() ([]activeDownloadInfo, []finishedDownload) {
+	reg.mu.Lock()
+	active := make([]*activeDownload, 0, len(reg.active))
+	for _, d := range reg.active {
+		active = append(active, d)
+	}
+	recent := make([]finishedDownload, 0, len(reg.recent))
+	for i := range reg.recent {
+		// Walk backwards from the most recent write
+		idx := (reg.next - 1 - i + 2*len(reg.recent)) % len(reg.recent)
+		recent = append(recent, reg.recent[idx])
+	}
+	reg.mu.Unlock()
+
+	slices.SortFunc(active, func(a, b *activeDownload) int { return a.started.Compare(b.started) })
+
+	now := time.Now()
+	infos := make([]activeDownloadInfo, 0, len(active))
+	for _, d := range active {
+		elapsed := now.Sub(d.started).Seconds()
+		info := activeDownloadInfo{
+			ID:             strconv.FormatUint(d.id, 10),
+			File:           d.file,
+			IP:             d.ip,
+			Bytes:          d.bytes.Load(),
+			ElapsedSeconds: elapsed,
+		}
+		if elapsed > 0 {
+			info.BytesPerSecond = float64(info.Bytes) / elapsed
+		}
+		if *redactIPs {
+			info.IP = redactIP(info.IP)
+		}
+		infos = append(infos, info)
+	}
+	return infos, recent
+}
+
+// counters returns the finished download totals and the number of active
+// transfers.
+func (reg *downloadRegistry) counters() (map[string]downloadTotals, int) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	totals := make(map[string]downloadTotals, len(reg.totals))
+	for status, t := range reg.totals {
+		totals[status] = *t
+	}
+	return totals, len(reg.active)
+}
+
+// redactIP keeps only the network part of an address.
+func redactIP(addr string) string {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return "redacted"
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+// adminDownloadsHandler handles GET /admin/downloads.
+func adminDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	active, recent := downloads.snapshot()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]any{"active": active, "recent": recent})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// cancellingWriter cancels the request context once limit bytes are
+// written, as if the client went away mid-transfer.
+type cancellingWriter struct {
+	*httptest.ResponseRecorder
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (c *cancellingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseRecorder.Write(p)
+	if c.Body.Len() >= c.limit {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestDownloadOutcomeCounters(t *testing.T) {
+	newTestDir(t)
+	size := 1 << 20
+	writeTestFile(t, "a.bin", strings.Repeat("x", size))
+
+	tests := []struct {
+		name    string
+		limit   int
+		outcome string
+	}{
+		{"completed", size, downloadCompleted},
+		{"client cancelled", 64 << 10, downloadCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := downloads.counters()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			w := &cancellingWriter{ResponseRecorder: httptest.NewRecorder(), limit: tt.limit, cancel: cancel}
+			downloadHandler(w, newRequest("GET", "/download?file=a.bin").WithContext(ctx))
+
+			after, _ := downloads.counters()
+			if got := after[tt.outcome].count - before[tt.outcome].count; got != 1 {
+				t.Errorf("%s downloads grew by %d, want 1", tt.outcome, got)
+			}
+			sent := int64(w.Body.Len())
+			if got := after[tt.outcome].bytes - before[tt.outcome].bytes; got != sent {
+				t.Errorf("%s bytes grew by %d, want the %d sent", tt.outcome, got, sent)
+			}
+			if tt.outcome == downloadCancelled && sent >= int64(size) {
+				t.Errorf("cancelled download sent all %d bytes", sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// draining is set while the instance is being taken out of rotation. New
+// downloads are refused; in-flight ones run to completion.
+var draining atomic.Bool
+
+// adminDrainHandler handles POST /admin/drain.
+func adminDrainHandler(w http.ResponseWriter, r *http.Request) {
+	if !draining.Swap(true) {
+		slog.Info("Drain mode enabled, refusing new downloads", "in_flight", workers.activeCount())
+	}
+	writeDrainState(w)
+}
+
+// adminUndrainHandler handles POST /admin/undrain.
+func adminUndrainHandler(w http.ResponseWriter, r *http.Request) {
+	if draining.Swap(false) {
+		slog.Info("Drain mode disabled, accepting downloads again")
+	}
+	writeDrainState(w)
+}
+
+func writeDrainState(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"draining":         draining.Load(),
+		"active_downloads": workers.activeCount(),
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var duCacheTTL = flag.Duration("du-cache-ttl", time.Minute, "how long a /du result is reused while the directory itself is unchanged")
+
+type duResponse struct {
+	Dir   string `json:"dir"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files"`
+	// Partial is true when some subdirectories could not be read, so the
+	// totals are a lower bound.
+	Partial    bool      `json:"partial"`
+	Cached     bool      `json:"cached"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+type duEntry struct {
+	result  duResponse
+	dirMod  time.Time
+	expires time.Time
+}
+
+var (
+	duCacheMu sync.Mutex
+	duCache   = make(map[string]duEntry)
+)
+
+// diskUsage totals the regular files below dirPath. Hidden entries are
+// skipped like everywhere else, and symbolic links are not followed.
+func diskUsage(dirPath string) (size int64, files int, partial bool) {
+	filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			partial = true
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path != dirPath && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+		files++
+		return nil
+	})
+	return size, files, partial
+}
+
+// forgetDiskUsage drops cached totals for every directory containing path.
+func forgetDiskUsage(path string) {
+	duCacheMu.Lock()
+	defer duCacheMu.Unlock()
+	for dir := range duCache {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			delete(duCache, dir)
+		}
+	}
+}
+
+// duHandler handles GET /du?dir=<subdir>.
+func duHandler(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("dir")
+	dirPath, err := resolveDownloadPath(dir)
+	if err != nil {
+		http.Error(w, "Invalid directory", http.StatusBadRequest)
+		return
+	}
+
+	stat, err := os.Stat(dirPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !stat.IsDir() {
+		http.Error(w, "Not a directory", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	duCacheMu.Lock()
+	entry, ok := duCache[dirPath]
+	duCacheMu.Unlock()
+
+	var resp duResponse
+	if ok && entry.dirMod.Equal(stat.ModTime()) && now.Before(entry.expires) {
+		resp = entry.result
+		resp.Cached = true
+	} else {
+		resp = duResponse{Dir: strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+dir)), "/"), ComputedAt: now}
+		resp.Size, resp.Files, resp.Partial = diskUsage(dirPath)
+
+		duCacheMu.Lock()
+		duCache[dirPath] = duEntry{result: resp, dirMod: stat.ModTime(), expires: now.Add(*duCacheTTL)}
+		duCacheMu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"sync"
+	"time"
+)
+
+var totalRate = flag.Int64("total-rate", 0, "cap on total download bytes per second across all clients (0 = unlimited)")
+
+// tokenBucket is a byte budget shared by every active download. Each grant
+// is capped at the stream's fair share of the bucket so one fast client
+// cannot drain it while others wait.
+type tokenBucket struct {
+	mu      sync.Mutex
+	rate    float64 // bytes per second
+	burst   float64
+	tokens  float64
+	last    time.Time
+	streams int
+}
+
+// egress is the shared bucket for all downloads. Its rate follows the
+// runtime configuration; a zero rate means unlimited.
+var egress = &tokenBucket{last: time.Now()}
+
+// setRate changes the bucket's rate, keeping 100ms worth of burst so
+// bursts stay small.
+func (b *tokenBucket) setRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rate = float64(rate)
+	b.burst = max(float64(rate)/10, 1)
+	b.tokens = min(b.tokens, b.burst)
+}
+
+// limited reports whether a rate is configured.
+func (b *tokenBucket) limited() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate > 0
+}
+
+func (b *tokenBucket) join() {
+	b.mu.Lock()
+	b.streams++
+	b.mu.Unlock()
+}
+
+func (b *tokenBucket) leave() {
+	b.mu.Lock()
+	b.streams--
+	b.mu.Unlock()
+}
+
+// take reserves between 1 and n bytes and blocks until the reservation is
+// covered. The bucket may go negative: each caller sleeps off its own debt,
+// so streams are served in the order they asked.
+func (b *tokenBucket) take(ctx context.Context, n int) (int, error) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return n, nil
+	}
+
+	share := max(1, int(b.burst)/max(1, b.streams))
+	want := min(n, share)
+	b.tokens -= float64(want)
+	debt := -b.tokens
+	b.mu.Unlock()
+
+	if debt <= 0 {
+		return want, nil
+	}
+
+	timer := time.NewTimer(time.Duration(debt / b.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return want, nil
+	case <-ctx.Done():
+		// Hand the unused reservation back.
+		b.mu.Lock()
+		b.tokens += float64(want)
+		b.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// throttledWriter paces writes through the shared egress bucket.
+type throttledWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	bucket *tokenBucket
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := t.bucket.take(t.ctx, len(p)-written)
+		if err != nil {
+			return written, err
+		}
+		n, err = t.w.Write(p[written : written+n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileETag derives an entity tag from size and modification time. The
+// identity bytes of a file get a strong tag. A transformed representation
+// (the gzip sidecar) gets a weak one: it is equivalent content, not the same
+// bytes, so it must never satisfy a strong comparison such as If-Range.
+//
+// Byte ranges keep the strong tag. A 206 is part of the same representation,
+// and clients resuming with If-Range depend on the tag being strong.
+func fileETag(stat os.FileInfo, transformed bool) string {
+	tag := fmt.Sprintf(`"%x-%x"`, stat.Size(), stat.ModTime().UnixNano())
+	if transformed {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// etagWeakMatch reports whether an If-None-Match header matches etag using
+// the weak comparison from RFC 7232 section 2.3.2.
+func etagWeakMatch(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// etagStrongMatch reports whether two tags are identical and both strong.
+func etagStrongMatch(a, b string) bool {
+	return a == b && !strings.HasPrefix(a, "W/") && strings.HasPrefix(a, `"`)
+}
+
+// notModified evaluates the cache validators of a GET or HEAD. Per RFC 7232
+// section 6, If-Modified-Since is only consulted when the request carries no
+// If-None-Match, even if the latter does not match.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagWeakMatch(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have one second resolution.
+	return !modTime.Truncate(time.Second).After(since)
+}
+
+// ifRangeMatches evaluates an If-Range header, which carries either an
+// entity tag (compared strongly) or the exact Last-Modified date.
+func ifRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagStrongMatch(ifRange, etag)
+	}
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return modTime.Truncate(time.Second).Equal(date)
+}
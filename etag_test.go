@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// If-None-Match decides on its own whenever it is present; If-Modified-Since
+// only counts without it.
+func TestConditionalGet(t *testing.T) {
+	newTestDir(t)
+	path := writeTestFile(t, "a.txt", "hello")
+	modified := time.Date(2024, 10, 15, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := os.Stat(path)
+	etag := fileETag(stat, false)
+	later := modified.Add(time.Hour).Format(http.TimeFormat)
+	earlier := modified.Add(-time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"etag match, date match", []string{"If-None-Match", etag, "If-Modified-Since", later}, http.StatusNotModified},
+		{"etag match, date stale", []string{"If-None-Match", etag, "If-Modified-Since", earlier}, http.StatusNotModified},
+		{"etag mismatch, date match", []string{"If-None-Match", `"other"`, "If-Modified-Since", later}, http.StatusOK},
+		{"etag mismatch, date stale", []string{"If-None-Match", `"other"`, "If-Modified-Since", earlier}, http.StatusOK},
+		{"weak etag", []string{"If-None-Match", "W/" + etag}, http.StatusNotModified},
+		{"etag in list", []string{"If-None-Match", `"other", ` + etag}, http.StatusNotModified},
+		{"wildcard", []string{"If-None-Match", "*"}, http.StatusNotModified},
+		{"date only, match", []string{"If-Modified-Since", modified.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"date only, stale", []string{"If-Modified-Since", earlier}, http.StatusOK},
+		{"invalid date", []string{"If-Modified-Since", "yesterday"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt", tt.headers...))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if tt.want == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 with a body of %d bytes", rec.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	allowedExts = make(map[string]bool)
+	deniedExts  = make(map[string]bool)
+)
+
+var errExtensionDenied = errors.New("file type not allowed")
+
+func init() {
+	flag.Func("allow-ext", "comma separated extensions that may be downloaded, e.g. `.pdf,.zip` (repeatable; empty = all)", extListFlag(allowedExts))
+	flag.Func("deny-ext", "comma separated extensions that may never be downloaded, e.g. `.env,.key` (repeatable; checked before -allow-ext)", extListFlag(deniedExts))
+}
+
+func extListFlag(set map[string]bool) func(string) error {
+	return func(s string) error {
+		for _, ext := range strings.Split(s, ",") {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if ext == "" {
+				continue
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			set[ext] = true
+		}
+		return nil
+	}
+}
+
+// extensionAllowed applies -deny-ext and then -allow-ext to name. Files
+// without an extension only pass when there is no allowlist.
+func extensionAllowed(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if deniedExts[ext] {
+		return false
+	}
+	return len(allowedExts) == 0 || allowedExts[ext]
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// setExtFilters applies -allow-ext and -deny-ext values for the rest of
+// the test.
+func setExtFilters(t *testing.T, allow, deny string) {
+	t.Helper()
+	oldAllow, oldDeny := maps.Clone(allowedExts), maps.Clone(deniedExts)
+	clear(allowedExts)
+	clear(deniedExts)
+	t.Cleanup(func() {
+		clear(allowedExts)
+		clear(deniedExts)
+		maps.Copy(allowedExts, oldAllow)
+		maps.Copy(deniedExts, oldDeny)
+	})
+	if err := extListFlag(allowedExts)(allow); err != nil {
+		t.Fatal(err)
+	}
+	if err := extListFlag(deniedExts)(deny); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtensionAllowed(t *testing.T) {
+	tests := []struct {
+		allow, deny string
+		name        string
+		want        bool
+	}{
+		{"", "", "a.env", true},
+		{"", ".env,.key", "a.env", false},
+		{"", ".env,.key", "A.KEY", false},
+		{"", "env", "a.env", false},
+		{"", ".env", "a.txt", true},
+		{".pdf,.zip", "", "a.pdf", true},
+		{".pdf,.zip", "", "a.txt", false},
+		{".pdf,.zip", "", "noext", false},
+		{".pdf", ".pdf", "a.pdf", false},
+	}
+	for _, tt := range tests {
+		setExtFilters(t, tt.allow, tt.deny)
+		if got := extensionAllowed(tt.name); got != tt.want {
+			t.Errorf("allow %q, deny %q: extensionAllowed(%q) = %v, want %v", tt.allow, tt.deny, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadExtensionFilter(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "secret.env", "TOKEN=1")
+	writeTestFile(t, "notes.txt", "notes")
+	setExtFilters(t, "", ".env")
+
+	tests := []struct {
+		file string
+		want int
+	}{
+		{"notes.txt", http.StatusOK},
+		{"secret.env", http.StatusForbidden},
+		{"missing.env", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		rec := serve(downloadHandler, newRequest("GET", "/download?file="+tt.file))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.file, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestListingExtensionFilter(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "secret.env", "TOKEN=1")
+	writeTestFile(t, "notes.txt", "notes")
+	setExtFilters(t, "", ".env")
+
+	rec := serve(filesHandler, newRequest("GET", "/files?format=text"))
+	if got := rec.Body.String(); strings.Contains(got, "secret.env") || !strings.Contains(got, "notes.txt") {
+		t.Errorf("listing = %q, want notes.txt only", got)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var fileHeadersOnGet = flag.Bool("file-headers", false, "send the X-File-* metadata headers on GET downloads too, not only on HEAD")
+
+// setFileHeaders describes the file itself in X-File-Modified, X-File-Size
+// and, once a digest is cached, X-File-SHA256, so clients can discover it
+// with a HEAD request. Unlike Content-Length and Last-Modified they do not
+// change with ranges or encodings, and they reveal nothing /files does not.
+// No hash is computed for them.
+func setFileHeaders(h http.Header, r *http.Request, filePath string, stat fs.FileInfo) {
+	if r.Method != http.MethodHead && !*fileHeadersOnGet {
+		return
+	}
+	h.Set("X-File-Modified", stat.ModTime().UTC().Format(time.RFC3339))
+	h.Set("X-File-Size", strconv.FormatInt(stat.Size(), 10))
+
+	if digest, ok := lookupDigest(filePath, stat); ok {
+		if sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(digest, "sha-256=")); err == nil {
+			h.Set("X-File-SHA256", hex.EncodeToString(sum))
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileHeaders(t *testing.T) {
+	newTestDir(t)
+	content := "0123456789"
+	path := writeTestFile(t, "a.txt", content)
+	modified := time.Date(2024, 10, 15, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(path, modified, modified)
+	sum := sha256.Sum256([]byte(content))
+
+	tests := []struct {
+		name     string
+		method   string
+		headers  []string
+		flags    []string
+		wantMeta bool
+		sha      string
+	}{
+		{"head", "HEAD", nil, nil, true, ""},
+		{"get", "GET", nil, nil, false, ""},
+		{"get with -file-headers", "GET", nil, []string{"file-headers", "true"}, true, ""},
+		{"range keeps the full size", "GET", []string{"Range", "bytes=0-3"}, []string{"file-headers", "true"}, true, ""},
+		// The GET with -digest caches the hash the next HEAD reports
+		{"get caching a digest", "GET", nil, []string{"digest", "true"}, false, ""},
+		{"head with cached digest", "HEAD", nil, nil, true, hex.EncodeToString(sum[:])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < len(tt.flags); i += 2 {
+				setFlag(t, tt.flags[i], tt.flags[i+1])
+			}
+			rec := serve(downloadHandler, newRequest(tt.method, "/download?file=a.txt", tt.headers...))
+			if rec.Code >= 300 {
+				t.Fatalf("status = %d", rec.Code)
+			}
+
+			want := map[string]string{"X-File-Size": "", "X-File-Modified": "", "X-File-SHA256": tt.sha}
+			if tt.wantMeta {
+				want["X-File-Size"] = "10"
+				want["X-File-Modified"] = "2024-10-15T12:00:00Z"
+			}
+			for header, value := range want {
+				if got := rec.Header().Get(header); got != value {
+					t.Errorf("%s = %q, want %q", header, got, value)
+				}
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		rec := serve(downloadHandler, newRequest("HEAD", "/download?file=nope.txt"))
+		if rec.Code != http.StatusNotFound || rec.Header().Get("X-File-Size") != "" {
+			t.Errorf("status = %d, X-File-Size = %q", rec.Code, rec.Header().Get("X-File-Size"))
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	followMax  = flag.Duration("follow-max", 30*time.Minute, "longest a ?follow=true download keeps waiting for appended data; it replaces -download-timeout for such downloads")
+	followPoll = flag.Duration("follow-poll", 500*time.Millisecond, "how often a ?follow=true download checks for appended data")
+)
+
+// followWriteTimeout bounds each write while following. The server's own
+// WriteTimeout covers the whole response and would end long tails early.
+const followWriteTimeout = time.Minute
+
+// followRequested reports whether r is a /download with ?follow=true.
+func followRequested(r *http.Request) bool {
+	return r.URL.Path == "/download" && r.URL.Query().Get("follow") == "true"
+}
+
+// followFile streams filePath like "tail -f": once EOF is reached it keeps
+// polling for appended bytes until the client goes away, the file shrinks
+// or -follow-max elapses. An open-ended Range or ?offset picks the start.
+// The storage breaker, -verify-on-serve, the per-file cap and .meta headers
+// apply as they do to other downloads.
+func followFile(w http.ResponseWriter, r *http.Request, name, filePath string) {
+	if !storageAvailable(w) {
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			downloadNotFound(w, r)
+		} else {
+			writeStorageError(w, name, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		writeStorageError(w, name, err)
+		return
+	}
+	if stat.IsDir() {
+		writeValidationError(w, errIsDirectory("file"))
+		return
+	}
+
+	meta, release, ok := admitDownload(w, r, name, filePath, file, stat)
+	if !ok {
+		return
+	}
+	defer release()
+
+	var start int64
+	if offset, ok, err := parseOffset(r.URL.Query().Get("offset")); err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	} else if ok {
+		start = offset
+	} else if rangeStart, _, ok, err := parseRange(r.Header.Get("Range"), stat.Size()); ok && err == nil {
+		start = rangeStart
+	}
+	if start > stat.Size() {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", stat.Size()))
+		http.Error(w, "Offset beyond end of file", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	disposition := dispositionFor(r, name)
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, name))
+	w.Header().Set("Content-Type", contentTypeFor(disposition, name, file))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	applyExtraHeaders(w)
+	meta.applyHeaders(w.Header())
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+	rc := http.NewResponseController(w)
+	deadline := time.NewTimer(*followMax)
+	defer deadline.Stop()
+	poll := time.NewTicker(*followPoll)
+	defer poll.Stop()
+
+	position := start
+	rotated := false
+	buffer := make([]byte, defaultBufferSize)
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			rc.SetWriteDeadline(time.Now().Add(followWriteTimeout))
+			if _, err := w.Write(buffer[:n]); err != nil {
+				slog.Info("Follow ended by write error", "file", name, "bytes_sent", position-start, "error", err)
+				return
+			}
+			position += int64(n)
+			rc.Flush()
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			storageBreaker.failure(err)
+			slog.Error("Read error while following", "file", name, "error", err)
+			return
+		}
+
+		if rotated {
+			slog.Info("Followed file was rotated, ending stream", "file", name)
+			return
+		}
+
+		// At EOF: wait for more data
+		select {
+		case <-ctx.Done():
+			slog.Debug("Follow ended by client", "file", name, "bytes_sent", position-start)
+			return
+		case <-deadline.C:
+			slog.Debug("Follow reached -follow-max", "file", name, "bytes_sent", position-start)
+			return
+		case <-poll.C:
+		}
+
+		// A file that shrank was truncated; one replaced at the same path
+		// was rotated. Either way the bytes we would read next no longer
+		// continue what was sent.
+		if current, err := file.Stat(); err == nil && current.Size() < position {
+			slog.Info("Followed file was truncated, ending stream", "file", name)
+			return
+		}
+		if current, err := os.Stat(filePath); err != nil || !os.SameFile(current, stat) {
+			// Send what was appended before the rotation, then stop
+			rotated = true
+		}
+	}
+}
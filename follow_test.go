@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFollowFileDirectory(t *testing.T) {
+	newTestDir(t)
+	dir := filepath.Dir(writeTestFile(t, "logs/a.log", ""))
+
+	rec := serve(func(w http.ResponseWriter, r *http.Request) {
+		followFile(w, r, "logs", dir)
+	}, httptest.NewRequest("GET", "/download?file=logs&follow=true", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestQueuedTimeout(t *testing.T) {
+	setFlag(t, "download-timeout", "20m")
+	setFlag(t, "follow-max", "30m")
+
+	tests := []struct {
+		url  string
+		want time.Duration
+	}{
+		{"/download?file=a.log", 20 * time.Minute},
+		{"/download?file=a.log&follow=false", 20 * time.Minute},
+		{"/download?file=a.log&follow=true", 30*time.Minute + writeTimeoutSlack},
+		{"/concat?files=a.log&follow=true", 20 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := queuedTimeout(httptest.NewRequest("GET", tt.url, nil)); got != tt.want {
+			t.Errorf("%s: timeout = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+// A follow outlives -download-timeout and keeps sending appended data
+// until -follow-max.
+func TestFollowOutlivesDownloadTimeout(t *testing.T) {
+	newTestDir(t)
+	path := writeTestFile(t, "a.log", "first\n")
+	setFlag(t, "download-timeout", "20ms")
+	setFlag(t, "follow-max", "300ms")
+	setFlag(t, "follow-poll", "10ms")
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		f.WriteString("second\n")
+		f.Close()
+	}()
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/download?file=a.log&follow=true", nil)
+	enqueue(rec, r, func(w http.ResponseWriter, r *http.Request) {
+		followFile(w, r, "a.log", path)
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Body.String(); got != "first\nsecond\n" {
+		t.Errorf("body = %q, want both lines", got)
+	}
+}
+
+// Following a file goes through the same checks as downloading it.
+func TestFollowChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, path string)
+		status int
+	}{
+		{"storage breaker open", func(t *testing.T, path string) {
+			setBreakerOpen(true)
+			t.Cleanup(func() { setBreakerOpen(false) })
+		}, http.StatusServiceUnavailable},
+		{"checksum mismatch", func(t *testing.T, path string) {
+			setFlag(t, "verify-on-serve", "true")
+			writeTestFile(t, "a.log.sha256", strings.Repeat("0", 64))
+		}, http.StatusInternalServerError},
+		{"file at its limit", func(t *testing.T, path string) {
+			setFlag(t, "per-file-mode", "reject")
+			writeTestFile(t, "a.log.meta", `{"max_concurrent": 1}`)
+			release, err := downloadsPerFile.acquire(context.Background(), path, 1, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(release)
+		}, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDir(t)
+			path := writeTestFile(t, "a.log", "first\n")
+			tt.setup(t, path)
+
+			rec := serve(func(w http.ResponseWriter, r *http.Request) {
+				followFile(w, r, "a.log", path)
+			}, newRequest("GET", "/download?file=a.log&follow=true"))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestFollowMetaHeaders(t *testing.T) {
+	newTestDir(t)
+	path := writeTestFile(t, "a.log", "first\n")
+	writeTestFile(t, "a.log.meta", `{"headers": {"X-Stream": "app"}}`)
+	setFlag(t, "follow-max", "10ms")
+	setFlag(t, "follow-poll", "5ms")
+
+	rec := serve(func(w http.ResponseWriter, r *http.Request) {
+		followFile(w, r, "a.log", path)
+	}, newRequest("GET", "/download?file=a.log&follow=true"))
+	if got := rec.Header().Get("X-Stream"); got != "app" {
+		t.Errorf("X-Stream = %q, want the .meta header", got)
+	}
+	if rec.Body.String() != "first\n" {
+		t.Errorf("body = %q", rec.Body)
+	}
+}
+
+// setBreakerOpen opens or closes the storage breaker without the probe a
+// real opening starts.
+func setBreakerOpen(open bool) {
+	storageBreaker.mu.Lock()
+	defer storageBreaker.mu.Unlock()
+	storageBreaker.open = open
+}
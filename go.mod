@@ -1,3 +1,17 @@
 module atc4-hq-server
 
 go 1.25.1
+
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/klauspost/compress v1.20.1
+	golang.org/x/text v0.41.0
+	google.golang.org/grpc v1.84.0
+	google.golang.org/protobuf v1.36.11
+)
+
+require (
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800 // indirect
+)
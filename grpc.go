@@ -0,0 +1,152 @@
+package main
+
+//go:generate protoc -I filespb --go_out=filespb --go_opt=paths=source_relative --go-grpc_out=filespb --go-grpc_opt=paths=source_relative files.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"atc4-hq-server/filespb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var grpcAddr = flag.String("grpc-addr", "", "address for the gRPC metadata service atc4.files.v1.Files, e.g. :8081; it uses the same TLS, client certificates, -allowed-hosts and extension filters as HTTP (empty = disabled)")
+
+// fileServer answers filespb.Files from the same lookups as GET /files.
+// Files whose extension is filtered out are treated as if they were absent.
+type fileServer struct {
+	filespb.UnimplementedFilesServer
+}
+
+func (fileServer) GetFileInfo(ctx context.Context, req *filespb.GetFileInfoRequest) (*filespb.FileInfo, error) {
+	if !extensionAllowed(req.GetName()) {
+		return nil, status.Error(codes.PermissionDenied, errExtensionDenied.Error())
+	}
+	info, err := statDownload(req.GetName())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return fileInfoMessage(info), nil
+}
+
+func (fileServer) ListFiles(ctx context.Context, req *filespb.ListFilesRequest) (*filespb.ListFilesResponse, error) {
+	files, err := listFiles(req.GetDir())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	reply := &filespb.ListFilesResponse{}
+	for _, f := range files {
+		reply.Files = append(reply.Files, fileInfoMessage(f))
+	}
+	return reply, nil
+}
+
+func fileInfoMessage(info FileInfo) *filespb.FileInfo {
+	return &filespb.FileInfo{Name: info.Name, Size: info.Size, Modified: timestamppb.New(info.Modified)}
+}
+
+// grpcError maps lookup errors to status codes, keeping internal paths out
+// of what clients see.
+func grpcError(err error) error {
+	switch {
+	case err == errInvalidPath, err == errNotAFile:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, "not found")
+	default:
+		slog.Error("gRPC storage error", "error", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// grpcAccess applies the checks the HTTP handler chain makes before any
+// route: -allowed-hosts against :authority, -allowed-client-cns against the
+// client certificate, and maintenance mode.
+func grpcAccess(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	authority := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(":authority"); len(values) > 0 {
+		authority = values[0]
+	}
+	if !hostHeaderAllowed(authority) {
+		slog.Info("Rejected gRPC call for unknown host", "authority", authority, "method", info.FullMethod)
+		return nil, status.Error(codes.PermissionDenied, "misdirected request")
+	}
+	if allowedCNs != nil {
+		var state *tls.ConnectionState
+		if p, ok := peer.FromContext(ctx); ok {
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				state = &tlsInfo.State
+			}
+		}
+		if cn := verifiedCN(state); !allowedCNs[cn] {
+			slog.Warn("Rejected client certificate", "cn", cn, "method", info.FullMethod)
+			return nil, status.Error(codes.PermissionDenied, "client certificate not allowed")
+		}
+	}
+	if maintenance.Load() != nil {
+		return nil, status.Error(codes.Unavailable, "under maintenance")
+	}
+	return handler(ctx, req)
+}
+
+// newGRPCServer builds the gRPC server. It serves TLS, and requires client
+// certificates, whenever the HTTP server does; tlsConfig is the HTTP
+// server's configuration, nil for plain HTTP.
+func newGRPCServer(tlsConfig *tls.Config) (*grpc.Server, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		config := tlsConfig.Clone()
+		config.Certificates = []tls.Certificate{cert}
+		creds = credentials.NewTLS(config)
+	}
+	gs := grpc.NewServer(grpc.Creds(creds), grpc.UnaryInterceptor(grpcAccess))
+	filespb.RegisterFilesServer(gs, fileServer{})
+	return gs, nil
+}
+
+// serveGRPC starts the gRPC listener when -grpc-addr is set and stops it
+// when server shuts down. It must run after configureTLS and
+// requireAllowedHost have parsed their flags.
+func serveGRPC(server *http.Server) {
+	if *grpcAddr == "" {
+		return
+	}
+
+	gs, err := newGRPCServer(server.TLSConfig)
+	if err != nil {
+		fatal("Failed to set up gRPC", "error", err)
+	}
+	listener, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		fatal("Failed to listen for gRPC", "addr", *grpcAddr, "error", err)
+	}
+	server.RegisterOnShutdown(gs.GracefulStop)
+	slog.Info("Serving gRPC metadata", "addr", *grpcAddr, "tls", server.TLSConfig != nil)
+
+	// Serve backs off on temporary accept errors itself and returns once
+	// the listener is closed by GracefulStop
+	go func() {
+		if err := gs.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			slog.Error("gRPC server stopped", "error", err)
+		}
+	}()
+}
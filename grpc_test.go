@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"atc4-hq-server/filespb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialFiles serves the Files service in memory and returns a client for it.
+func dialFiles(t *testing.T, opts ...grpc.DialOption) filespb.FilesClient {
+	t.Helper()
+	gs, err := newGRPCServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	go gs.Serve(listener)
+	t.Cleanup(gs.Stop)
+
+	opts = append(opts,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+	conn, err := grpc.NewClient("passthrough:///files.example.com", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return filespb.NewFilesClient(conn)
+}
+
+func TestGRPCGetFileInfo(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "hello")
+	writeTestFile(t, "secret.key", "k")
+	writeTestFile(t, "sub/b.txt", "b")
+	deniedExts[".key"] = true
+	t.Cleanup(func() { delete(deniedExts, ".key") })
+	client := dialFiles(t)
+
+	tests := []struct {
+		name string
+		file string
+		code codes.Code
+	}{
+		{"file", "a.txt", codes.OK},
+		{"missing", "nope.txt", codes.NotFound},
+		{"directory", "sub", codes.InvalidArgument},
+		{"outside", "../a.txt", codes.InvalidArgument},
+		{"denied extension", "secret.key", codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := client.GetFileInfo(context.Background(), &filespb.GetFileInfoRequest{Name: tt.file})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+			if err == nil && (info.GetName() != tt.file || info.GetSize() != 5) {
+				t.Errorf("info = %v", info)
+			}
+		})
+	}
+}
+
+func TestGRPCListFiles(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "a")
+	writeTestFile(t, "secret.key", "k")
+	writeTestFile(t, ".upload-tmp", "x")
+	deniedExts[".key"] = true
+	t.Cleanup(func() { delete(deniedExts, ".key") })
+
+	reply, err := dialFiles(t).ListFiles(context.Background(), &filespb.ListFilesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.GetFiles()) != 1 || reply.GetFiles()[0].GetName() != "a.txt" {
+		t.Errorf("files = %v, want only a.txt", reply.GetFiles())
+	}
+}
+
+func TestGRPCAllowedHosts(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "hello")
+	allowedHostList = parseHostList("files.example.com")
+	t.Cleanup(func() { allowedHostList = nil })
+
+	tests := []struct {
+		name      string
+		authority string
+		code      codes.Code
+	}{
+		{"allowed", "files.example.com:8081", codes.OK},
+		{"other host", "evil.example.net", codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dialFiles(t, grpc.WithAuthority(tt.authority))
+			_, err := client.GetFileInfo(context.Background(), &filespb.GetFileInfoRequest{Name: "a.txt"})
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+		})
+	}
+}
+
+// peerWithCN is a call context from a TLS client whose verified
+// certificate has common name cn; "" means a connection without TLS.
+func peerWithCN(cn string) context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(":authority", "files.example.com"))
+	if cn == "" {
+		return peer.NewContext(ctx, &peer.Peer{})
+	}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+func TestGRPCAccess(t *testing.T) {
+	allowedCNs = map[string]bool{"ops": true}
+	t.Cleanup(func() { allowedCNs = nil })
+
+	tests := []struct {
+		name        string
+		cn          string
+		maintenance bool
+		code        codes.Code
+	}{
+		{"allowed client", "ops", false, codes.OK},
+		{"other client", "guest", false, codes.PermissionDenied},
+		{"no certificate", "", false, codes.PermissionDenied},
+		{"maintenance", "ops", true, codes.Unavailable},
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: filespb.Files_ListFiles_FullMethodName}
+	ok := func(ctx context.Context, req any) (any, error) { return nil, nil }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maintenance {
+				maintenance.Store(&maintenanceState{Message: "test"})
+				t.Cleanup(func() { maintenance.Store(nil) })
+			}
+			_, err := grpcAccess(peerWithCN(tt.cn), nil, info, ok)
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+		})
+	}
+}
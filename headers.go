@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// headerList is a repeatable "-header 'Name: value'" flag.
+type headerList []staticHeader
+
+type staticHeader struct {
+	name  string
+	value string
+}
+
+var extraHeaders headerList
+
+func init() {
+	flag.Var(&extraHeaders, "header", "extra `Name: value` response header for downloads (repeatable)")
+}
+
+// Headers that describe the body framing are owned by the handler and
+// cannot be overridden from the command line.
+var reservedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Range":     true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+	"Connection":        true,
+}
+
+func (h *headerList) String() string {
+	parts := make([]string, len(*h))
+	for i, sh := range *h {
+		parts[i] = sh.name + ": " + sh.value
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h *headerList) Set(s string) error {
+	sh, err := parseStaticHeader(s)
+	if err != nil {
+		return err
+	}
+	*h = append(*h, sh)
+	return nil
+}
+
+func parseStaticHeader(s string) (staticHeader, error) {
+	name, value, found := strings.Cut(s, ":")
+	if !found {
+		return staticHeader{}, fmt.Errorf("header %q: want \"Name: value\"", s)
+	}
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+
+	if !validHeaderName(name) {
+		return staticHeader{}, fmt.Errorf("header %q: invalid name", s)
+	}
+	if !validHeaderValue(value) {
+		return staticHeader{}, fmt.Errorf("header %q: invalid value", s)
+	}
+
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if reservedHeaders[name] {
+		return staticHeader{}, fmt.Errorf("header %q: %s is set by the server", s, name)
+	}
+	return staticHeader{name: name, value: value}, nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue rejects control characters other than horizontal tab.
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// applyExtraHeaders sets the configured static headers, replacing any
+// default the handler has already set.
+func applyExtraHeaders(w http.ResponseWriter) {
+	for _, sh := range currentConfig().headers {
+		w.Header().Set(sh.name, sh.value)
+	}
+}
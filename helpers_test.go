@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDir switches into a fresh directory holding an empty download
+// directory and installs the default runtime configuration.
+func newTestDir(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir(downloadDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadRuntimeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyRuntimeConfig(cfg)
+	return dir
+}
+
+// useAdminToken enables the admin API with token for the rest of the test.
+// It must run after newTestDir.
+func useAdminToken(t testing.TB, token string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "admin-token-file", path)
+	cfg, err := loadRuntimeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyRuntimeConfig(cfg)
+}
+
+// writeTestFile creates name inside the download directory and returns
+// its absolute path.
+func writeTestFile(t testing.TB, name, content string) string {
+	t.Helper()
+	path, err := filepath.Abs(filepath.Join(downloadDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setFlag sets a command line flag for the rest of the test.
+func setFlag(t testing.TB, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag -%s", name)
+	}
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		t.Fatalf("-%s=%s: %v", name, value, err)
+	}
+	t.Cleanup(func() { f.Value.Set(old) })
+}
+
+// newRequest builds a request with headers given as name, value pairs.
+func newRequest(method, target string, headers ...string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	return r
+}
+
+// gzipped returns content gzip compressed.
+func gzipped(t *testing.T, content string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// serve runs handler on a request and returns the recorded response.
+func serve(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var allowedHosts = flag.String("allowed-hosts", "", "comma separated Host header values the server answers to, e.g. files.example.com,*.example.org; others get 421 (empty = any host)")
+
+// allowedHostList is -allowed-hosts parsed, nil when every host is accepted.
+var allowedHostList []string
+
+// hostHeaderAllowed checks a Host header or :authority, which may carry a
+// port, against allowedHostList. Without -allowed-hosts every host passes.
+func hostHeaderAllowed(hostport string) bool {
+	if allowedHostList == nil {
+		return true
+	}
+	host := hostport
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return hostAllowed(strings.TrimSuffix(host, "."), allowedHostList)
+}
+
+// requireAllowedHost rejects requests for hosts outside -allowed-hosts with
+// 421 Misdirected Request. It runs before everything else, so a rebound DNS
+// name pointing a browser at the server cannot reach any route.
+func requireAllowedHost(next http.Handler) http.Handler {
+	allowedHostList = parseHostList(*allowedHosts)
+	if allowedHostList == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hostHeaderAllowed(r.Host) {
+			slog.Info("Rejected request for unknown host", "host", r.Host, "path", r.URL.Path)
+			http.Error(w, "Misdirected request", http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
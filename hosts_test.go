@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAllowedHosts(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name string
+		list string
+		host string
+		want int
+	}{
+		{"unset accepts any", "", "evil.example", http.StatusOK},
+		{"exact", "files.example.com", "files.example.com", http.StatusOK},
+		{"exact with port", "files.example.com", "files.example.com:8080", http.StatusOK},
+		{"case and trailing dot", "files.example.com", "FILES.Example.com.", http.StatusOK},
+		{"disallowed", "files.example.com", "evil.example", http.StatusMisdirectedRequest},
+		{"rebound ip", "files.example.com", "127.0.0.1:8080", http.StatusMisdirectedRequest},
+		{"wildcard subdomain", "*.example.org", "cdn.example.org", http.StatusOK},
+		{"wildcard nested", "*.example.org", "a.b.example.org", http.StatusOK},
+		{"wildcard skips apex", "*.example.org", "example.org", http.StatusMisdirectedRequest},
+		{"wildcard lookalike", "*.example.org", "badexample.org", http.StatusMisdirectedRequest},
+		{"second entry", "files.example.com, *.example.org", "cdn.example.org", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "allowed-hosts", tt.list)
+			t.Cleanup(func() { allowedHostList = nil })
+			r := newRequest("GET", "/health")
+			r.Host = tt.host
+			rec := serve(requireAllowedHost(ok).ServeHTTP, r)
+			if rec.Code != tt.want {
+				t.Errorf("Host %q: status = %d, want %d", tt.host, rec.Code, tt.want)
+			}
+		})
+	}
+}
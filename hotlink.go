@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	allowedReferers   = flag.String("allowed-referers", "", "comma separated hosts allowed to link downloads, e.g. example.com,*.example.org (empty = no hotlink protection)")
+	allowEmptyReferer = flag.Bool("allow-empty-referer", true, "accept downloads without a Referer when hotlink protection is on")
+)
+
+// parseHostList splits a comma separated host list, lower-casing entries and
+// dropping blanks.
+func parseHostList(list string) []string {
+	var hosts []string
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// hostAllowed matches host against the allowlist. A "*.example.com" entry
+// matches any subdomain of example.com but not example.com itself.
+func hostAllowed(host string, allowlist []string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range allowlist {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// refererAllowed applies hotlink protection. Direct API clients send no
+// Referer and are accepted unless -allow-empty-referer=false. A browser that
+// strips the Referer while embedding the file cross-site still gives itself
+// away through Sec-Fetch-Site, so that case is rejected too.
+func refererAllowed(r *http.Request) bool {
+	refererHosts := currentConfig().refererHosts
+	if len(refererHosts) == 0 {
+		return true
+	}
+
+	referer := r.Header.Get("Referer")
+	if referer == "" {
+		if r.Header.Get("Sec-Fetch-Site") == "cross-site" && r.Header.Get("Sec-Fetch-Mode") != "navigate" {
+			return false
+		}
+		return *allowEmptyReferer
+	}
+
+	u, err := url.Parse(referer)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	return hostAllowed(u.Hostname(), refererHosts)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var idempotencyTTL = flag.Duration("idempotency-ttl", 24*time.Hour, "how long the result of an upload with an Idempotency-Key is replayed to retries with the same key")
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyReplayHeader marks a response that repeats a stored result.
+	idempotencyReplayHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeys bounds the stored results.
+	maxIdempotencyKeys = 10000
+	// maxIdempotencyKeyLength bounds a single key.
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyScope is what a stored result is looked up by. Keys are chosen
+// by clients, so each client only ever sees results for its own keys.
+type idempotencyScope struct {
+	client string
+	key    string
+}
+
+// idempotencyClient identifies the client of r: the verified certificate's
+// common name with mutual TLS, otherwise the remote address.
+func idempotencyClient(r *http.Request) string {
+	if cn := clientCN(r); cn != "" {
+		return "cn:" + cn
+	}
+	return "ip:" + clientIP(r)
+}
+
+// idempotentResult is the stored outcome of a request. done is closed once
+// the first request with the key has finished; until then retries wait.
+type idempotentResult struct {
+	request     string // method and URL the key was first used with
+	bodySum     []byte // SHA-256 of that request's body
+	done        chan struct{}
+	stored      bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+var (
+	idempotentMu      sync.Mutex
+	idempotentResults = make(map[idempotencyScope]*idempotentResult)
+)
+
+// withIdempotency makes a request carrying an Idempotency-Key safe to
+// retry: the first request with a key runs normally and its response is
+// kept for -idempotency-ttl, later ones get that response back with
+// Idempotent-Replayed: true instead of running again. Server errors are not
+// kept, so a retry after one runs again. Keys are scoped to the client, and
+// reusing one with a different body is refused with 422.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeValidationError(w, invalidParam(idempotencyKeyHeader, "must be at most 255 bytes"))
+			return
+		}
+		request := r.Method + " " + r.URL.RequestURI()
+		scope := idempotencyScope{client: idempotencyClient(r), key: key}
+
+		for {
+			result, first := claimIdempotencyKey(scope, request)
+			if result == nil {
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, "Too many pending idempotency keys", http.StatusServiceUnavailable)
+				return
+			}
+			if first {
+				runIdempotent(w, r, next, scope, result)
+				return
+			}
+			if result.request != request {
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+
+			select {
+			case <-result.done:
+			case <-r.Context().Done():
+				return
+			}
+			if !result.stored {
+				// The first attempt failed on the server side; run again
+				continue
+			}
+			// Only now is the body read, since a retry that runs again
+			// needs it intact
+			sum := newBodyHash(r.Body)
+			if !bytes.Equal(sum.finish(), result.bodySum) {
+				http.Error(w, "Idempotency-Key was already used with a different body", http.StatusUnprocessableEntity)
+				return
+			}
+			slog.Info("Replaying idempotent request", "key", key, "request", request)
+			w.Header().Set("Content-Type", result.contentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(result.body)))
+			w.Header().Set(idempotencyReplayHeader, "true")
+			w.WriteHeader(result.status)
+			w.Write(result.body)
+			return
+		}
+	}
+}
+
+// claimIdempotencyKey returns the live result for key and whether the
+// caller is the first to use it, in which case it must run the request.
+// It returns nil when the table is full.
+func claimIdempotencyKey(scope idempotencyScope, request string) (*idempotentResult, bool) {
+	idempotentMu.Lock()
+	defer idempotentMu.Unlock()
+
+	now := time.Now()
+	if result, ok := idempotentResults[scope]; ok {
+		if result.expires.IsZero() || now.Before(result.expires) {
+			return result, false
+		}
+		delete(idempotentResults, scope)
+	}
+	if len(idempotentResults) >= maxIdempotencyKeys {
+		for k, result := range idempotentResults {
+			if !result.expires.IsZero() && now.After(result.expires) {
+				delete(idempotentResults, k)
+			}
+		}
+		if len(idempotentResults) >= maxIdempotencyKeys {
+			return nil, false
+		}
+	}
+	result := &idempotentResult{request: request, done: make(chan struct{})}
+	idempotentResults[scope] = result
+	return result, true
+}
+
+// runIdempotent serves the first request with key and stores its response.
+func runIdempotent(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, scope idempotencyScope, result *idempotentResult) {
+	rec := &capturingRecorder{responseRecorder: responseRecorder{ResponseWriter: w}}
+	sum := newBodyHash(r.Body)
+	r.Body = sum
+	finished := false
+	defer func() {
+		// Whatever the handler left unread still belongs to the body
+		bodySum := sum.finish()
+		idempotentMu.Lock()
+		// A panic or a client that left mid-request leaves nothing to replay
+		if finished && rec.statusCode() < 500 && r.Context().Err() == nil {
+			result.stored = true
+			result.bodySum = bodySum
+			result.status = rec.statusCode()
+			result.contentType = rec.Header().Get("Content-Type")
+			result.body = rec.body.Bytes()
+			result.expires = time.Now().Add(*idempotencyTTL)
+		} else {
+			delete(idempotentResults, scope)
+		}
+		idempotentMu.Unlock()
+		close(result.done)
+	}()
+	next(rec, r)
+	finished = true
+}
+
+// bodyHash hashes a request body as it is read. At most -max-upload-size
+// bytes and one more are hashed, which is as much as an upload handler reads
+// before refusing the body.
+type bodyHash struct {
+	body io.ReadCloser
+	sum  hash.Hash
+	left int64
+}
+
+func newBodyHash(body io.ReadCloser) *bodyHash {
+	return &bodyHash{body: body, sum: sha256.New(), left: *maxUploadSize + 1}
+}
+
+func (b *bodyHash) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.body.Read(p)
+	b.sum.Write(p[:n])
+	b.left -= int64(n)
+	return n, err
+}
+
+func (b *bodyHash) Close() error {
+	return b.body.Close()
+}
+
+// finish reads the rest of the body and returns its hash.
+func (b *bodyHash) finish() []byte {
+	io.Copy(io.Discard, b)
+	return b.sum.Sum(nil)
+}
+
+// capturingRecorder keeps a copy of the body it passes through.
+type capturingRecorder struct {
+	responseRecorder
+	body bytes.Buffer
+}
+
+func (rec *capturingRecorder) Write(p []byte) (int, error) {
+	rec.body.Write(p)
+	return rec.responseRecorder.Write(p)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	type call struct {
+		url      string
+		key      string
+		status   int
+		replayed bool
+	}
+	tests := []struct {
+		name    string
+		ttl     string
+		fail    bool
+		calls   []call
+		handled int32
+	}{
+		{"no key", "1h", false, []call{
+			{"/upload?file=a", "", http.StatusCreated, false},
+			{"/upload?file=a", "", http.StatusCreated, false},
+		}, 2},
+		{"duplicate key", "1h", false, []call{
+			{"/upload?file=a", "k1", http.StatusCreated, false},
+			{"/upload?file=a", "k1", http.StatusCreated, true},
+			{"/upload?file=a", "k1", http.StatusCreated, true},
+		}, 1},
+		{"distinct keys", "1h", false, []call{
+			{"/upload?file=a", "k1", http.StatusCreated, false},
+			{"/upload?file=a", "k2", http.StatusCreated, false},
+		}, 2},
+		{"key reused for another request", "1h", false, []call{
+			{"/upload?file=a", "k1", http.StatusCreated, false},
+			{"/upload?file=b", "k1", http.StatusUnprocessableEntity, false},
+		}, 1},
+		{"server error not kept", "1h", true, []call{
+			{"/upload?file=a", "k1", http.StatusInternalServerError, false},
+			{"/upload?file=a", "k1", http.StatusInternalServerError, false},
+		}, 2},
+		{"expired", "1ns", false, []call{
+			{"/upload?file=a", "k1", http.StatusCreated, false},
+			{"/upload?file=a", "k1", http.StatusCreated, false},
+		}, 2},
+		{"key too long", "1h", false, []call{
+			{"/upload?file=a", strings.Repeat("k", maxIdempotencyKeyLength+1), http.StatusBadRequest, false},
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "idempotency-ttl", tt.ttl)
+			var handled atomic.Int32
+			handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+				n := handled.Add(1)
+				if tt.fail {
+					http.Error(w, "disk full", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"upload":%d}`, n)
+			})
+
+			var firstBody string
+			for i, c := range tt.calls {
+				key := c.key
+				if key != "" && len(key) <= maxIdempotencyKeyLength {
+					key = t.Name() + "/" + key
+					t.Cleanup(func() { forgetIdempotencyKey(key) })
+				}
+				rec := serve(handler, newRequest("POST", c.url, idempotencyKeyHeader, key))
+				if rec.Code != c.status {
+					t.Fatalf("call %d: status = %d, want %d", i, rec.Code, c.status)
+				}
+				if replayed := rec.Header().Get(idempotencyReplayHeader) == "true"; replayed != c.replayed {
+					t.Errorf("call %d: replayed = %v, want %v", i, replayed, c.replayed)
+				}
+				if i == 0 {
+					firstBody = rec.Body.String()
+				} else if c.replayed && rec.Body.String() != firstBody {
+					t.Errorf("call %d: replayed body %q, want %q", i, rec.Body, firstBody)
+				}
+			}
+			if got := handled.Load(); got != tt.handled {
+				t.Errorf("handler ran %d times, want %d", got, tt.handled)
+			}
+		})
+	}
+}
+
+// A retry arriving while the first request is still running waits for it
+// and gets its result instead of running in parallel.
+func TestIdempotencyKeyConcurrentRetry(t *testing.T) {
+	key := t.Name()
+	t.Cleanup(func() { forgetIdempotencyKey(key) })
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled atomic.Int32
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		if handled.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("stored"))
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve(handler, newRequest("POST", "/upload?file=a", idempotencyKeyHeader, key))
+	}()
+	<-started
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+
+	rec := serve(handler, newRequest("POST", "/upload?file=a", idempotencyKeyHeader, key))
+	wg.Wait()
+	if rec.Code != http.StatusCreated || rec.Body.String() != "stored" || rec.Header().Get(idempotencyReplayHeader) != "true" {
+		t.Errorf("retry got %d %q replayed=%q, want the stored result", rec.Code, rec.Body, rec.Header().Get(idempotencyReplayHeader))
+	}
+	if got := handled.Load(); got != 1 {
+		t.Errorf("handler ran %d times, want 1", got)
+	}
+}
+
+// A key only replays for the client that used it, and only for the same
+// body.
+func TestIdempotencyKeyScope(t *testing.T) {
+	key := t.Name()
+	t.Cleanup(func() { forgetIdempotencyKey(key) })
+	var handled atomic.Int32
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%d:%s", handled.Add(1), body)
+	})
+	upload := func(remoteAddr, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/upload?file=a", strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(idempotencyKeyHeader, key)
+		return serve(handler, r)
+	}
+
+	upload("192.0.2.1:1000", "first")
+	tests := []struct {
+		name       string
+		remoteAddr string
+		body       string
+		status     int
+		replayed   bool
+	}{
+		{"retry", "192.0.2.1:2000", "first", http.StatusCreated, true},
+		{"different body", "192.0.2.1:2000", "second", http.StatusUnprocessableEntity, false},
+		{"other client", "192.0.2.2:1000", "first", http.StatusCreated, false},
+	}
+	for _, tt := range tests {
+		rec := upload(tt.remoteAddr, tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if replayed := rec.Header().Get(idempotencyReplayHeader) == "true"; replayed != tt.replayed {
+			t.Errorf("%s: replayed = %v, want %v", tt.name, replayed, tt.replayed)
+		}
+	}
+	if got := handled.Load(); got != 2 {
+		t.Errorf("handler ran %d times, want 2", got)
+	}
+}
+
+func forgetIdempotencyKey(key string) {
+	idempotentMu.Lock()
+	defer idempotentMu.Unlock()
+	for scope := range idempotentResults {
+		if scope.key == key {
+			delete(idempotentResults, scope)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var replacedWarning = flag.Bool("replaced-warning", false, `send "Warning: 199" on the first response after a file was replaced at the same path, e.g. by an atomic rename during a deploy`)
+
+// maxTrackedFiles bounds fileIdentities; when full it starts over, which
+// only means a replacement right after goes unnoticed.
+const maxTrackedFiles = 10000
+
+var (
+	identitiesMu sync.Mutex
+	// fileIdentities holds the file last served for each path, by
+	// absolute path.
+	fileIdentities = make(map[string]fs.FileInfo)
+
+	// replacedBetween and replacedDuring count replacements for /metrics.
+	replacedBetween, replacedDuring atomic.Int64
+)
+
+// noteFileIdentity records which file is served for filePath and logs when
+// it is a different file from the one served last time, since clients
+// holding the old ETag or Last-Modified will now see a mismatch. Built-in
+// files have no identity and are skipped.
+func noteFileIdentity(h http.Header, name, filePath string, stat fs.FileInfo) {
+	if _, builtin := stat.(builtinInfo); builtin {
+		return
+	}
+
+	identitiesMu.Lock()
+	previous, seen := fileIdentities[filePath]
+	if !seen && len(fileIdentities) >= maxTrackedFiles {
+		clear(fileIdentities)
+	}
+	fileIdentities[filePath] = stat
+	identitiesMu.Unlock()
+
+	if !seen || os.SameFile(previous, stat) {
+		return
+	}
+	replacedBetween.Add(1)
+	slog.Info("File was replaced since it was last served", "file", name,
+		"old_size", previous.Size(), "old_modified", previous.ModTime(),
+		"new_size", stat.Size(), "new_modified", stat.ModTime())
+	if *replacedWarning {
+		h.Set("Warning", `199 - "file was replaced since it was last served"`)
+	}
+}
+
+// checkReplacedDuring logs when filePath no longer names the file that was
+// opened for a download, because it was replaced or removed. The transfer
+// itself stays consistent since it reads from the descriptor opened at the
+// start.
+func checkReplacedDuring(name, filePath string, opened fs.FileInfo) {
+	if _, builtin := opened.(builtinInfo); builtin {
+		return
+	}
+	current, err := os.Stat(filePath)
+	if err == nil && os.SameFile(current, opened) {
+		return
+	}
+	replacedDuring.Add(1)
+	slog.Info("File was replaced or removed during download, the old version was sent", "file", name, "size", opened.Size(), "modified", opened.ModTime())
+}
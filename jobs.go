@@ -0,0 +1,405 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	stagingDir = flag.String("staging-dir", filepath.Join(os.TempDir(), "atc4-staging"), "directory holding staged snapshots for download jobs")
+	jobTTL     = flag.Duration("job-ttl", time.Hour, "how long a download job and its staged snapshot are kept")
+
+	maxStagingJobs = flag.Int("max-staging-jobs", 4, "most POST /jobs snapshots copied at once; further requests get 429")
+	maxStagedBytes = flag.Int64("max-staged-bytes", 10<<30, "most bytes all live snapshots may hold together; a job that would go beyond it gets 507")
+)
+
+const (
+	jobStaging = "staging"
+	jobReady   = "ready"
+	jobFailed  = "failed"
+
+	// stageAttempts bounds how often a copy is retried when the source
+	// changes underneath it.
+	stageAttempts = 3
+)
+
+var errSourceChanged = errors.New("source file changed while staging")
+
+var (
+	errTooManyJobs   = errors.New("too many jobs staging")
+	errStagingIsFull = errors.New("staging directory is full")
+)
+
+// job is an asynchronous download. The requested file is copied into the
+// staging directory first so the download is served from a stable snapshot
+// even if the original is modified afterwards.
+type job struct {
+	ID        string
+	File      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	stagedPath string
+	total      int64
+	copied     atomic.Int64
+
+	mu    sync.Mutex
+	state string
+	err   string
+}
+
+type jobStatus struct {
+	ID          string    `json:"id"`
+	File        string    `json:"file"`
+	State       string    `json:"state"`
+	Error       string    `json:"error,omitempty"`
+	BytesCopied int64     `json:"bytes_copied"`
+	TotalBytes  int64     `json:"total_bytes"`
+	Progress    float64   `json:"progress"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := jobStatus{
+		ID:          j.ID,
+		File:        j.File,
+		State:       j.state,
+		Error:       j.err,
+		BytesCopied: j.copied.Load(),
+		TotalBytes:  j.total,
+		CreatedAt:   j.CreatedAt,
+		ExpiresAt:   j.ExpiresAt,
+	}
+	if s.TotalBytes > 0 {
+		s.Progress = float64(s.BytesCopied) / float64(s.TotalBytes)
+	} else if s.State == jobReady {
+		s.Progress = 1
+	}
+	return s
+}
+
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err != nil {
+		j.state = jobFailed
+		j.err = err.Error()
+		return
+	}
+	j.state = jobReady
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*job)
+
+	// jobAdmission makes checking the limits and starting the job one
+	// step for POST /jobs.
+	jobAdmission sync.Mutex
+)
+
+func init() {
+	go sweepJobs()
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func lookupJob(id string) *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return jobs[id]
+}
+
+// checkJobLimits reports whether a snapshot of size bytes fits within
+// -max-staging-jobs and -max-staged-bytes. Failed jobs hold nothing.
+func checkJobLimits(size int64) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	staging, staged := 0, size
+	for _, j := range jobs {
+		switch s := j.status(); s.State {
+		case jobStaging:
+			staging++
+			staged += s.TotalBytes
+		case jobReady:
+			staged += s.TotalBytes
+		}
+	}
+	switch {
+	case staging >= *maxStagingJobs:
+		return errTooManyJobs
+	case staged > *maxStagedBytes:
+		return errStagingIsFull
+	}
+	return nil
+}
+
+// createJobHandler handles POST /jobs?file=<name> and starts staging.
+func createJobHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("file")
+	filePath, verr := validateFileParam("file", fileName)
+	if verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	stat, err := os.Stat(filePath)
+	if err != nil || !stat.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	jobAdmission.Lock()
+	err = checkJobLimits(stat.Size())
+	var j *job
+	if err == nil {
+		j = startJob(fileName, filePath, stat.Size(), nil)
+	}
+	jobAdmission.Unlock()
+	switch err {
+	case errTooManyJobs:
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many jobs staging, please try again later", http.StatusTooManyRequests)
+		return
+	case errStagingIsFull:
+		slog.Warn("Refusing job, staging limit reached", "file", fileName, "size", stat.Size(), "max_staged_bytes", *maxStagedBytes)
+		http.Error(w, "Not enough staging space for this file", http.StatusInsufficientStorage)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", urlPath("/jobs?id="+j.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j.status())
+}
+
+// startJob registers a job for fileName and stages it in the background.
+// onDone, if not nil, runs once staging has finished either way.
+func startJob(fileName, filePath string, size int64, onDone func(*job)) *job {
+	now := time.Now()
+	j := &job{
+		ID:        newJobID(),
+		File:      fileName,
+		CreatedAt: now,
+		ExpiresAt: now.Add(*jobTTL),
+		total:     size,
+		state:     jobStaging,
+	}
+	j.stagedPath = filepath.Join(*stagingDir, j.ID)
+
+	jobsMu.Lock()
+	jobs[j.ID] = j
+	jobsMu.Unlock()
+
+	go func() {
+		err := stageFile(j, filePath)
+		if err != nil {
+			slog.Error("Staging failed", "file", fileName, "job", j.ID, "error", err)
+		} else {
+			slog.Info("Staged file", "file", fileName, "job", j.ID)
+		}
+		j.finish(err)
+		if onDone != nil {
+			onDone(j)
+		}
+	}()
+	return j
+}
+
+// jobStatusHandler handles GET /jobs?id=<id>.
+func jobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	j := lookupJob(r.URL.Query().Get("id"))
+	if j == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j.status())
+}
+
+// jobDownloadHandler handles GET /jobs/download?id=<id> and serves the staged
+// snapshot once the job is ready.
+func jobDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	j := lookupJob(r.URL.Query().Get("id"))
+	if j == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch s := j.status(); s.State {
+	case jobReady:
+		serveFile(w, r, filepath.Base(j.File), j.stagedPath)
+	case jobFailed:
+		http.Error(w, fmt.Sprintf("Staging failed: %s", s.Error), http.StatusInternalServerError)
+	default:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "File is still being staged", http.StatusConflict)
+	}
+}
+
+// stageFile copies src into the job's staging path, tracking progress. The
+// copy is retried when the source is modified while it is being read.
+func stageFile(j *job, src string) error {
+	if err := os.MkdirAll(*stagingDir, dirPerm); err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := copySnapshot(j, src)
+		if err == nil {
+			err = stageSidecars(j, src)
+		}
+		if err != errSourceChanged || attempt == stageAttempts {
+			if err != nil {
+				removeStaged(j)
+			}
+			return err
+		}
+		slog.Warn("Source changed while staging, retrying", "file", j.File, "job", j.ID)
+	}
+}
+
+func copySnapshot(j *job, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	before, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(j.stagedPath)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.total = before.Size()
+	j.mu.Unlock()
+	j.copied.Store(0)
+
+	_, err = io.Copy(out, io.TeeReader(in, progressWriter{&j.copied}))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	after, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return errSourceChanged
+	}
+	return nil
+}
+
+// stagedSidecars are the sidecars serveFile looks up next to the file it
+// serves, so a snapshot needs its own copies of them.
+var stagedSidecars = []string{".meta", ".sha256", ".gz"}
+
+// stageSidecars copies the sidecars that exist for src next to the staged
+// snapshot.
+func stageSidecars(j *job, src string) error {
+	for _, suffix := range stagedSidecars {
+		if err := copySidecar(src+suffix, j.stagedPath+suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func copySidecar(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// removeStaged removes a job's snapshot and its sidecars.
+func removeStaged(j *job) error {
+	err := os.Remove(j.stagedPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, suffix := range stagedSidecars {
+		os.Remove(j.stagedPath + suffix)
+	}
+	return nil
+}
+
+// progressWriter counts bytes written through it.
+type progressWriter struct {
+	n *atomic.Int64
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	p.n.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// sweepJobs removes expired jobs and their staged snapshots.
+func sweepJobs() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		var expired []*job
+
+		jobsMu.Lock()
+		for id, j := range jobs {
+			if now.After(j.ExpiresAt) {
+				expired = append(expired, j)
+				delete(jobs, id)
+			}
+		}
+		jobsMu.Unlock()
+
+		for _, j := range expired {
+			if err := removeStaged(j); err != nil {
+				slog.Error("Failed to remove staged file", "job", j.ID, "error", err)
+			}
+			slog.Info("Expired job", "job", j.ID, "file", j.File)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stageForTest stages name through a job and waits for it to finish.
+func stageForTest(t *testing.T, name, filePath string) *job {
+	t.Helper()
+	done := make(chan *job, 1)
+	j := startJob(name, filePath, 0, func(j *job) { done <- j })
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("staging did not finish")
+	}
+	if s := j.status(); s.State != jobReady {
+		t.Fatalf("job state = %s (%s), want %s", s.State, s.Error, jobReady)
+	}
+	return j
+}
+
+// A snapshot is served with its sidecars even after the original ones
+// change or go away.
+func TestJobDownloadSidecars(t *testing.T) {
+	dir := newTestDir(t)
+	setFlag(t, "staging-dir", filepath.Join(dir, "staging"))
+	path := writeTestFile(t, "report.dat", "a,b\n1,2\n")
+	writeTestFile(t, "report.dat.meta", `{"content_type": "text/csv", "headers": {"X-Report": "q3"}}`)
+
+	j := stageForTest(t, "report.dat", path)
+	os.Remove(path + ".meta")
+
+	rec := serve(jobDownloadHandler, httptest.NewRequest("GET", "/jobs/download?id="+j.ID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	tests := []struct {
+		header, want string
+	}{
+		{"Content-Type", "text/csv"},
+		{"X-Report", "q3"},
+	}
+	for _, tt := range tests {
+		if got := rec.Header().Get(tt.header); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+
+	if err := removeStaged(j); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{j.stagedPath, j.stagedPath + ".meta"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after removal", p)
+		}
+	}
+}
+
+func TestJobLimits(t *testing.T) {
+	dir := newTestDir(t)
+	setFlag(t, "staging-dir", filepath.Join(dir, "staging"))
+	writeTestFile(t, "a.txt", "0123456789")
+
+	jobsMu.Lock()
+	saved := jobs
+	jobs = map[string]*job{"busy": {state: jobStaging, total: 5}}
+	jobsMu.Unlock()
+	t.Cleanup(func() {
+		jobsMu.Lock()
+		jobs = saved
+		jobsMu.Unlock()
+	})
+
+	tests := []struct {
+		maxJobs, maxBytes string
+		want              int
+	}{
+		{"1", "100", http.StatusTooManyRequests},
+		{"2", "14", http.StatusInsufficientStorage},
+		{"2", "15", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		setFlag(t, "max-staging-jobs", tt.maxJobs)
+		setFlag(t, "max-staged-bytes", tt.maxBytes)
+		rec := serve(createJobHandler, newRequest("POST", "/jobs?file=a.txt"))
+		if rec.Code != tt.want {
+			t.Errorf("-max-staging-jobs=%s -max-staged-bytes=%s: status = %d, want %d", tt.maxJobs, tt.maxBytes, rec.Code, tt.want)
+		}
+	}
+
+	// Let staging finish before the directory is removed; the job limit
+	// frees up again once only the fake job is staging
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if checkJobLimits(0) == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
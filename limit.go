@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"sync"
+)
+
+var (
+	perFileLimit = flag.Int("per-file-limit", 0, "maximum concurrent downloads of a single file (0 = unlimited)")
+	perFileMode  = flag.String("per-file-mode", "queue", "what to do when a file is at its limit: queue or reject")
+	perFileQueue = flag.Int("per-file-queue", 10, "with -per-file-mode=queue, most requests waiting for one file; each holds a download worker while it waits, so further ones get 429")
+)
+
+var errFileBusy = errors.New("file is at its concurrency limit")
+
+type fileSlots struct {
+	active  int
+	waiters int
+	// wake is closed and replaced every time a slot is released.
+	wake chan struct{}
+}
+
+// fileLimiter tracks active downloads per file path.
+type fileLimiter struct {
+	mu    sync.Mutex
+	files map[string]*fileSlots
+}
+
+var downloadsPerFile = &fileLimiter{files: make(map[string]*fileSlots)}
+
+// acquire takes a download slot for path. When the file is already at limit
+// it waits for a slot (until ctx is done), unless maxWaiters requests are
+// waiting already, in which case it returns errFileBusy. The returned
+// release func must be called once the download finishes.
+func (l *fileLimiter) acquire(ctx context.Context, path string, limit, maxWaiters int) (func(), error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots := l.files[path]
+	if slots == nil {
+		slots = &fileSlots{wake: make(chan struct{})}
+		l.files[path] = slots
+	}
+
+	for slots.active >= limit {
+		if slots.waiters >= maxWaiters {
+			l.cleanup(path, slots)
+			return nil, errFileBusy
+		}
+
+		wake := slots.wake
+		slots.waiters++
+		l.mu.Unlock()
+
+		var err error
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		l.mu.Lock()
+		slots.waiters--
+		if err != nil {
+			l.cleanup(path, slots)
+			return nil, err
+		}
+	}
+
+	slots.active++
+	var once sync.Once
+	return func() { once.Do(func() { l.release(path, slots) }) }, nil
+}
+
+func (l *fileLimiter) release(path string, slots *fileSlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots.active--
+	close(slots.wake)
+	slots.wake = make(chan struct{})
+	l.cleanup(path, slots)
+}
+
+// cleanup drops the entry for path once nobody holds or waits for a slot.
+// Callers must hold l.mu.
+func (l *fileLimiter) cleanup(path string, slots *fileSlots) {
+	if slots.active == 0 && slots.waiters == 0 {
+		delete(l.files, path)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFileLimiterWaiters(t *testing.T) {
+	l := &fileLimiter{files: make(map[string]*fileSlots)}
+
+	release, err := l.acquire(context.Background(), "a.txt", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan error, 1)
+	go func() {
+		release, err := l.acquire(context.Background(), "a.txt", 1, 1)
+		if err == nil {
+			release()
+		}
+		got <- err
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		l.mu.Lock()
+		waiting := l.files["a.txt"].waiters
+		l.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second request never started waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := l.acquire(context.Background(), "a.txt", 1, 1); err != errFileBusy {
+		t.Errorf("beyond the waiter cap: err = %v, want errFileBusy", err)
+	}
+	if _, err := l.acquire(context.Background(), "a.txt", 1, 0); err != errFileBusy {
+		t.Errorf("reject mode: err = %v, want errFileBusy", err)
+	}
+
+	release()
+	if err := <-got; err != nil {
+		t.Errorf("waiter: err = %v, want a slot", err)
+	}
+	if len(l.files) != 0 {
+		t.Errorf("%d entries left after all slots were released", len(l.files))
+	}
+}
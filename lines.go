@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var linesMaxSize = flag.Int64("lines-max-size", 256<<20, "largest file that ?lines= may be used on, since it is scanned from the start")
+
+const (
+	// linesSniffSize is how much of a file is checked for NUL bytes to
+	// tell text from binary.
+	linesSniffSize = 8 << 10
+	// maxLineLength bounds a single line, which bufio.Scanner holds in
+	// memory whole.
+	maxLineLength = 1 << 20
+
+	lineRangeName = "X-Line-Range"
+)
+
+// parseLineRange parses "start-end" or "start-" with 1-based, inclusive line
+// numbers. An open end is returned as 0.
+func parseLineRange(value string) (start, end int64, err error) {
+	first, last, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, errors.New("must be start-end or start-")
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 1 {
+		return 0, 0, errors.New("start must be a line number from 1")
+	}
+	if last == "" {
+		return start, 0, nil
+	}
+	end, err = strconv.ParseInt(last, 10, 64)
+	if err != nil || end < 1 {
+		return 0, 0, errors.New("end must be a line number from 1")
+	}
+	if end < start {
+		return 0, 0, errors.New("end must not be before start")
+	}
+	return start, end, nil
+}
+
+// serveLines handles /download?file=<name>&lines=start-end for text files,
+// sending only those lines as text/plain. An end beyond the last line is
+// clamped. X-Line-Range tells which lines are sent: as a header when the
+// range has an end, which is counted before answering, and as a trailer
+// for an open range, whose end is only known once the file is read.
+func serveLines(w http.ResponseWriter, r *http.Request, name, filePath string) {
+	start, end, err := parseLineRange(r.URL.Query().Get("lines"))
+	if err != nil {
+		writeValidationError(w, invalidParam("lines", err.Error()))
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			downloadNotFound(w, r)
+		} else {
+			writeStorageError(w, name, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		writeStorageError(w, name, err)
+		return
+	}
+	if stat.IsDir() {
+		writeValidationError(w, errIsDirectory("file"))
+		return
+	}
+	if stat.Size() > *linesMaxSize {
+		writeValidationError(w, invalidParam("lines", fmt.Sprintf("is only supported for files up to %d bytes", *linesMaxSize)))
+		return
+	}
+
+	head := make([]byte, linesSniffSize)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		writeStorageError(w, name, err)
+		return
+	}
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		writeValidationError(w, invalidParam("lines", "is only supported for text files"))
+		return
+	}
+
+	openEnd := end == 0
+	if !openEnd {
+		count, err := countLines(file, end)
+		if err != nil {
+			writeLinesError(w, name, count+1, err)
+			return
+		}
+		end = min(end, count)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			writeStorageError(w, name, err)
+			return
+		}
+	}
+
+	scanner := newLineScanner(file)
+
+	// Skip to the first requested line before committing to a status
+	var line int64
+	for line < start && scanner.Scan() {
+		line++
+	}
+	if line < start {
+		if err := scanner.Err(); err != nil {
+			writeLinesError(w, name, line+1, err)
+			return
+		}
+		w.Header().Set("X-Line-Count", strconv.FormatInt(line, 10))
+		http.Error(w, fmt.Sprintf("File has only %d lines", line), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", cacheControlFor(name))
+	if openEnd {
+		declareTrailers(w, r, lineRangeName)
+	} else {
+		w.Header().Set(lineRangeName, fmt.Sprintf("%d-%d", start, end))
+	}
+	applyExtraHeaders(w)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	out := bufio.NewWriterSize(w, defaultBufferSize)
+	for {
+		out.Write(scanner.Bytes())
+		if err := out.WriteByte('\n'); err != nil {
+			slog.Info("Write error during line range", "file", name, "line", line, "error", err)
+			return
+		}
+		if line == end || !scanner.Scan() {
+			break
+		}
+		line++
+	}
+	if err := scanner.Err(); err != nil {
+		// Too late for a status; a short body without the trailer tells
+		// the client the range is incomplete
+		slog.Warn("Line range ended early", "file", name, "line", line+1, "error", err)
+		if !openEnd {
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	if err := out.Flush(); err != nil {
+		return
+	}
+	if openEnd {
+		w.Header().Set(lineRangeName, fmt.Sprintf("%d-%d", start, line))
+	} else if line < end {
+		// The file shrank since the lines were counted, and the header
+		// already promised more
+		slog.Warn("Line range ended early", "file", name, "line", line, "expected", end)
+		panic(http.ErrAbortHandler)
+	}
+	slog.Debug("Served line range", "file", name, "lines", fmt.Sprintf("%d-%d", start, line))
+}
+
+// newLineScanner reads r line by line, allowing lines up to maxLineLength.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineLength)
+	return scanner
+}
+
+// countLines counts the lines in r, stopping once there are limit.
+func countLines(r io.Reader, limit int64) (int64, error) {
+	scanner := newLineScanner(r)
+	var n int64
+	for n < limit && scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
+
+func writeLinesError(w http.ResponseWriter, name string, line int64, err error) {
+	if errors.Is(err, bufio.ErrTooLong) {
+		writeValidationError(w, invalidParam("lines", fmt.Sprintf("line %d is longer than %d bytes", line, maxLineLength)))
+		return
+	}
+	writeStorageError(w, name, err)
+}
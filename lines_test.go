@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeLines(t *testing.T) {
+	newTestDir(t)
+	text := writeTestFile(t, "a.log", "one\ntwo\nthree\nfour\n")
+	binary := writeTestFile(t, "a.bin", "one\x00two\n")
+
+	tests := []struct {
+		name      string
+		path      string
+		lines     string
+		status    int
+		body      string
+		lineRange string
+		// openEnd ranges report X-Line-Range in a trailer, others in
+		// a header
+		openEnd bool
+	}{
+		{"middle", text, "2-3", http.StatusOK, "two\nthree\n", "2-3", false},
+		{"open end", text, "3-", http.StatusOK, "three\nfour\n", "3-4", true},
+		{"end clamped", text, "4-10", http.StatusOK, "four\n", "4-4", false},
+		{"start beyond end", text, "9-", http.StatusRequestedRangeNotSatisfiable, "", "", true},
+		{"closed range beyond end", text, "9-10", http.StatusRequestedRangeNotSatisfiable, "", "", false},
+		{"reversed", text, "3-2", http.StatusBadRequest, "", "", false},
+		{"binary", binary, "1-1", http.StatusBadRequest, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/download?file=x&lines="+tt.lines, nil)
+			rec := serve(func(w http.ResponseWriter, r *http.Request) {
+				serveLines(w, r, "x", tt.path)
+			}, r)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := rec.Body.String(); got != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+			res := rec.Result()
+			header, trailer := res.Header.Get("X-Line-Range"), res.Trailer.Get("X-Line-Range")
+			if tt.openEnd {
+				header, trailer = trailer, header
+			}
+			if header != tt.lineRange {
+				t.Errorf("X-Line-Range = %q, want %q", header, tt.lineRange)
+			}
+			if trailer != "" {
+				t.Errorf("X-Line-Range also sent as the other kind: %q", trailer)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNotAFile = errors.New("not a regular file")
+
+// FileInfo describes a downloadable file. It is shared by the REST listing
+// and the gRPC service.
+type FileInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// statDownload returns metadata for a single file inside downloadDir.
+func statDownload(name string) (FileInfo, error) {
+	filePath, err := resolveDownloadPath(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if !stat.Mode().IsRegular() {
+		return FileInfo{}, errNotAFile
+	}
+
+	return FileInfo{Name: filepath.ToSlash(filepath.Clean(name)), Size: stat.Size(), Modified: stat.ModTime()}, nil
+}
+
+// listFiles returns the regular files directly inside dir, a path relative
+// to downloadDir. Hidden files, such as in-progress uploads, are skipped, and
+// so are files -allow-ext or -deny-ext would refuse to serve.
+func listFiles(dir string) ([]FileInfo, error) {
+	dirPath, err := resolveDownloadPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileInfo{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || !entry.Type().IsRegular() || !extensionAllowed(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed between ReadDir and Info
+			continue
+		}
+		files = append(files, FileInfo{
+			Name:     filepath.ToSlash(filepath.Join(dir, entry.Name())),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// filesHandler handles GET /files?dir=<subdir>. The listing is JSON unless
+// ?format= or the Accept header asks for CSV or plain text.
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = negotiateListingFormat(r.Header.Get("Accept"))
+	} else if _, ok := listingContentTypes[format]; !ok {
+		writeValidationError(w, invalidParam("format", "must be json, csv or text"))
+		return
+	}
+
+	files, err := listFiles(r.URL.Query().Get("dir"))
+	if err != nil {
+		switch {
+		case err == errInvalidPath:
+			http.Error(w, "Invalid directory", http.StatusBadRequest)
+		case errors.Is(err, fs.ErrNotExist):
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Both validators describe the listing itself. A file's content can
+	// change without touching the directory's modtime, so Last-Modified is
+	// the newest of the directory and the files it lists.
+	lastModified := time.Time{}
+	if dirPath, err := resolveDownloadPath(r.URL.Query().Get("dir")); err == nil {
+		if stat, err := os.Stat(dirPath); err == nil {
+			lastModified = stat.ModTime()
+		}
+	}
+	checksum := sha256.New()
+	for _, f := range files {
+		if f.Modified.After(lastModified) {
+			lastModified = f.Modified
+		}
+		fmt.Fprintf(checksum, "%s\x00%d\x00%d\n", f.Name, f.Size, f.Modified.UnixNano())
+	}
+	etag := `W/"` + hex.EncodeToString(checksum.Sum(nil)[:16]) + "-" + format + `"`
+
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", listingContentTypes[format])
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"name", "size", "modified"})
+		for _, f := range files {
+			cw.Write([]string{f.Name, strconv.FormatInt(f.Size, 10), f.Modified.UTC().Format(time.RFC3339)})
+		}
+		cw.Flush()
+	case "text":
+		// One file per line with the name first, for cut and awk
+		for _, f := range files {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", f.Name, f.Size, f.Modified.UTC().Format(time.RFC3339))
+		}
+	default:
+		json.NewEncoder(w).Encode(files)
+	}
+}
+
+// listingContentTypes maps the /files formats to their media types.
+var listingContentTypes = map[string]string{
+	"json": "application/json",
+	"csv":  "text/csv; charset=utf-8",
+	"text": "text/plain; charset=utf-8",
+}
+
+// negotiateListingFormat picks the listing format the Accept header prefers.
+// Ties go to the type listed first; anything unsupported means JSON.
+func negotiateListingFormat(accept string) string {
+	best, bestQ := "json", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		format := ""
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json":
+			format = "json"
+		case "text/csv":
+			format = "csv"
+		case "text/plain":
+			format = "text"
+		default:
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
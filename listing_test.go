@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Touching a listed file must move Last-Modified forward even though the
+// directory's own modtime stays put.
+func TestListingLastModified(t *testing.T) {
+	newTestDir(t)
+	base := time.Date(2024, 10, 15, 12, 0, 0, 0, time.UTC)
+	path := writeTestFile(t, "a.txt", "hello")
+	other := writeTestFile(t, "b.txt", "world")
+	dir := filepath.Dir(path)
+	for _, p := range []string{path, other, dir} {
+		if err := os.Chtimes(p, base, base); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := serve(filesHandler, newRequest("GET", "/files"))
+	if first.Code != http.StatusOK {
+		t.Fatalf("status = %d", first.Code)
+	}
+	lastModified := first.Header().Get("Last-Modified")
+	if want := base.Format(http.TimeFormat); lastModified != want {
+		t.Fatalf("Last-Modified = %q, want %q", lastModified, want)
+	}
+	etag := first.Header().Get("ETag")
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"same date", []string{"If-Modified-Since", lastModified}, http.StatusNotModified},
+		{"later date", []string{"If-Modified-Since", base.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified},
+		{"earlier date", []string{"If-Modified-Since", base.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK},
+		{"etag wins over date", []string{"If-None-Match", `"other"`, "If-Modified-Since", lastModified}, http.StatusOK},
+		{"etag match", []string{"If-None-Match", etag}, http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(filesHandler, newRequest("GET", "/files", tt.headers...))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("touched file", func(t *testing.T) {
+		touched := base.Add(time.Minute)
+		if err := os.Chtimes(path, touched, touched); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(dir, base, base); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := serve(filesHandler, newRequest("GET", "/files", "If-Modified-Since", lastModified, "If-None-Match", etag))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200 after touching a file", rec.Code)
+		}
+		if got, want := rec.Header().Get("Last-Modified"), touched.Format(http.TimeFormat); got != want {
+			t.Errorf("Last-Modified = %q, want %q", got, want)
+		}
+		if rec.Header().Get("ETag") == etag {
+			t.Error("ETag unchanged after touching a file")
+		}
+		again := serve(filesHandler, newRequest("GET", "/files", "If-Modified-Since", lastModified))
+		if again.Code != http.StatusOK {
+			t.Errorf("date-only revalidation status = %d, want 200", again.Code)
+		}
+	})
+}
+
+func TestListingFormats(t *testing.T) {
+	newTestDir(t)
+	modified := time.Date(2024, 10, 15, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"b.csv", "a.txt"} {
+		path := writeTestFile(t, name, "12345")
+		os.Chtimes(path, modified, modified)
+	}
+	writeTestFile(t, ".hidden", "skipped")
+
+	const (
+		jsonBody = `[{"name":"a.txt","size":5,"modified":"2024-10-15T12:00:00Z"},{"name":"b.csv","size":5,"modified":"2024-10-15T12:00:00Z"}]` + "\n"
+		csvBody  = "name,size,modified\na.txt,5,2024-10-15T12:00:00Z\nb.csv,5,2024-10-15T12:00:00Z\n"
+		textBody = "a.txt\t5\t2024-10-15T12:00:00Z\nb.csv\t5\t2024-10-15T12:00:00Z\n"
+	)
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"default", "", "", http.StatusOK, "application/json", jsonBody},
+		{"format json", "?format=json", "text/csv", http.StatusOK, "application/json", jsonBody},
+		{"format csv", "?format=csv", "", http.StatusOK, "text/csv; charset=utf-8", csvBody},
+		{"format text", "?format=text", "application/json", http.StatusOK, "text/plain; charset=utf-8", textBody},
+		{"format unknown", "?format=xml", "", http.StatusBadRequest, "application/json", ""},
+		{"accept csv", "", "text/csv", http.StatusOK, "text/csv; charset=utf-8", csvBody},
+		{"accept text", "", "text/plain", http.StatusOK, "text/plain; charset=utf-8", textBody},
+		{"accept by quality", "", "application/json;q=0.5, text/csv;q=0.9", http.StatusOK, "text/csv; charset=utf-8", csvBody},
+		{"accept tie goes first", "", "text/plain, text/csv", http.StatusOK, "text/plain; charset=utf-8", textBody},
+		{"accept unsupported", "", "application/xml", http.StatusOK, "application/json", jsonBody},
+		{"accept wildcard", "", "*/*", http.StatusOK, "application/json", jsonBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(filesHandler, newRequest("GET", "/files"+tt.query, "Accept", tt.accept))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("Vary = %q, want Accept", got)
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body =\n%s\nwant\n%s", rec.Body, tt.body)
+			}
+		})
+	}
+
+	t.Run("etag per format", func(t *testing.T) {
+		etags := make(map[string]bool)
+		for _, format := range []string{"json", "csv", "text"} {
+			etags[serve(filesHandler, newRequest("GET", "/files?format="+format)).Header().Get("ETag")] = true
+		}
+		if len(etags) != 3 {
+			t.Errorf("formats share ETags: %v", etags)
+		}
+	})
+}
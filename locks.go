@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	lockTTL    = flag.Duration("lock-ttl", 5*time.Minute, "default lifetime of a POST /locks lock; locks not renewed in time are released")
+	lockMaxTTL = flag.Duration("lock-max-ttl", time.Hour, "longest ?ttl= a lock may ask for")
+	lockQuota  = flag.Int("lock-quota", 100, "most live locks one client address may hold; further POST /locks get 429")
+)
+
+// maxLocks bounds the number of live locks. -lock-quota keeps a single
+// client from taking all of them.
+const maxLocks = 10000
+
+// lockTokenHeader carries the owner token on requests that a lock would
+// otherwise block, such as DELETE /files.
+const lockTokenHeader = "X-Lock-Token"
+
+// fileLock is an advisory lock on one file. It protects a client's
+// multi-step workflow (download, verify, delete) against deletion by
+// others; downloads are never blocked by it.
+type fileLock struct {
+	token   string
+	owner   string // client address that took the lock
+	expires time.Time
+}
+
+var (
+	locksMu sync.Mutex
+	locks   = make(map[string]fileLock) // by absolute path
+)
+
+type lockResponse struct {
+	File    string    `json:"file"`
+	Token   string    `json:"token,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// liveLockLocked returns the unexpired lock on path, dropping an expired
+// one.
+func liveLockLocked(path string) (fileLock, bool) {
+	lock, ok := locks[path]
+	if ok && time.Now().After(lock.expires) {
+		delete(locks, path)
+		return fileLock{}, false
+	}
+	return lock, ok
+}
+
+// locksHeldByLocked counts the unexpired locks taken from client.
+func locksHeldByLocked(client string) int {
+	n := 0
+	now := time.Now()
+	for _, lock := range locks {
+		if lock.owner == client && now.Before(lock.expires) {
+			n++
+		}
+	}
+	return n
+}
+
+// fileLockedError refuses a change to a file locked by someone else.
+type fileLockedError struct {
+	lock fileLock
+}
+
+func (e *fileLockedError) Error() string { return "file is locked" }
+
+// removeOverridingLock removes the file at path whatever lock is held on it,
+// releasing the lock along with the file.
+func removeOverridingLock(path string) error {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	delete(locks, path)
+	return nil
+}
+
+// changeUnlessLocked runs change, which removes or replaces the file at
+// path, unless path is locked by anyone but the holder of token. Anything
+// that removes or replaces files in the download directory must go through
+// it. The check and the change happen under locksMu so no lock can be taken
+// in between; a lock held by token is released once the file is gone.
+func changeUnlessLocked(path, token string, change func() error) error {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if lock, held := liveLockLocked(path); held && subtle.ConstantTimeCompare([]byte(lock.token), []byte(token)) != 1 {
+		return &fileLockedError{lock: lock}
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		delete(locks, path)
+	}
+	return nil
+}
+
+// locksHandler handles POST /locks?file=<name>[&ttl=30s][&token=<t>]. A
+// request without token acquires the lock; with the owner's token it
+// renews it.
+func locksHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("file")
+	filePath, ok := lockTarget(w, name)
+	if !ok {
+		return
+	}
+
+	ttl := *lockTTL
+	if v := query.Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > *lockMaxTTL {
+			writeValidationError(w, invalidParam("ttl", "must be a positive duration up to "+lockMaxTTL.String()))
+			return
+		}
+		ttl = d
+	}
+	token := query.Get("token")
+	client := clientIP(r)
+
+	locksMu.Lock()
+	lock, held := liveLockLocked(filePath)
+	status := http.StatusOK
+	switch {
+	case held && subtle.ConstantTimeCompare([]byte(lock.token), []byte(token)) != 1:
+		locksMu.Unlock()
+		writeLockConflict(w, http.StatusConflict, name, lock)
+		return
+	case !held && token != "":
+		locksMu.Unlock()
+		http.Error(w, "Lock not held or expired", http.StatusNotFound)
+		return
+	case !held && len(locks) >= maxLocks:
+		locksMu.Unlock()
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Too many locks", http.StatusServiceUnavailable)
+		return
+	case !held && locksHeldByLocked(client) >= *lockQuota:
+		locksMu.Unlock()
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Too many locks held by this client", http.StatusTooManyRequests)
+		return
+	case !held:
+		lock.token = newJobID()
+		lock.owner = client
+		status = http.StatusCreated
+	}
+	lock.expires = time.Now().Add(ttl)
+	locks[filePath] = lock
+	locksMu.Unlock()
+
+	if status == http.StatusCreated {
+		slog.Info("Locked file", "file", name, "client", client, "expires", lock.expires)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(lockResponse{File: name, Token: lock.token, Expires: lock.expires})
+}
+
+// unlockHandler handles DELETE /locks?file=<name>&token=<t>.
+func unlockHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("file")
+	filePath, verr := validateFileParam("file", name)
+	if verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	locksMu.Lock()
+	lock, held := liveLockLocked(filePath)
+	switch {
+	case !held:
+		locksMu.Unlock()
+		http.Error(w, "Lock not held or expired", http.StatusNotFound)
+		return
+	case subtle.ConstantTimeCompare([]byte(lock.token), []byte(r.URL.Query().Get("token"))) != 1:
+		locksMu.Unlock()
+		writeLockConflict(w, http.StatusConflict, name, lock)
+		return
+	}
+	delete(locks, filePath)
+	locksMu.Unlock()
+
+	slog.Info("Unlocked file", "file", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteFileHandler handles DELETE /files?file=<name>[&force=true]. A
+// locked file can only be deleted by the lock's owner, who passes its token
+// in X-Lock-Token; anyone else gets 423 unless force overrides the lock,
+// which the admin token guarding this route allows. The lock is released
+// with the file.
+func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("file")
+	filePath, ok := lockTarget(w, name)
+	if !ok {
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	var err error
+	if force {
+		err = removeOverridingLock(filePath)
+	} else {
+		err = changeUnlessLocked(filePath, r.Header.Get(lockTokenHeader), func() error {
+			return os.Remove(filePath)
+		})
+	}
+	var locked *fileLockedError
+	switch {
+	case errors.As(err, &locked):
+		writeLockConflict(w, http.StatusLocked, name, locked.lock)
+		return
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		writeStorageError(w, name, err)
+		return
+	}
+	invalidateCaches(filePath)
+	slog.Info("Deleted file", "file", name, "force", force)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockTarget validates name and makes sure it is an existing regular file.
+func lockTarget(w http.ResponseWriter, name string) (string, bool) {
+	filePath, verr := validateFileParam("file", name)
+	if verr != nil {
+		writeValidationError(w, verr)
+		return "", false
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil || !stat.Mode().IsRegular() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return "", false
+	}
+	return filePath, true
+}
+
+// writeLockConflict answers with status, 409 when a lock cannot be taken or
+// 423 when a locked file cannot be changed.
+func writeLockConflict(w http.ResponseWriter, status int, name string, lock fileLock) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": "File is locked", "file": name, "expires": lock.expires})
+}
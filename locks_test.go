@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// lockFile takes the lock on name and returns its token.
+func lockFile(t *testing.T, name string) string {
+	t.Helper()
+	rec := serve(locksHandler, httptest.NewRequest("POST", "/locks?file="+name, nil))
+	if rec.Code != 201 {
+		t.Fatalf("lock %s: status = %d, body %q", name, rec.Code, rec.Body)
+	}
+	var resp lockResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		locksMu.Lock()
+		clear(locks)
+		locksMu.Unlock()
+	})
+	return resp.Token
+}
+
+func TestLockConflict(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "data")
+	lockFile(t, "a.txt")
+
+	rec := serve(locksHandler, httptest.NewRequest("POST", "/locks?file=a.txt", nil))
+	if rec.Code != 409 {
+		t.Errorf("second lock: status = %d, want 409", rec.Code)
+	}
+}
+
+func TestLockQuota(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "lock-quota", "2")
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeTestFile(t, name, "data")
+	}
+	lockFile(t, "a.txt")
+	lockFile(t, "b.txt")
+
+	rec := serve(locksHandler, httptest.NewRequest("POST", "/locks?file=c.txt", nil))
+	if rec.Code != 429 {
+		t.Errorf("lock beyond the quota: status = %d, want 429", rec.Code)
+	}
+
+	other := httptest.NewRequest("POST", "/locks?file=c.txt", nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+	if rec := serve(locksHandler, other); rec.Code != 201 {
+		t.Errorf("lock from another client: status = %d, want 201", rec.Code)
+	}
+}
+
+func TestDeleteLockedFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      func(owner string) string
+		force      bool
+		wantStatus int
+		wantKept   bool
+	}{
+		{"no token", func(string) string { return "" }, false, 423, true},
+		{"wrong token", func(string) string { return "not-the-token" }, false, 423, true},
+		{"owner", func(owner string) string { return owner }, false, 204, false},
+		{"forced", func(string) string { return "" }, true, 204, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDir(t)
+			path := writeTestFile(t, "a.txt", "data")
+			owner := lockFile(t, "a.txt")
+
+			target := "/files?file=a.txt"
+			if tt.force {
+				target += "&force=true"
+			}
+			r := httptest.NewRequest("DELETE", target, nil)
+			if token := tt.token(owner); token != "" {
+				r.Header.Set(lockTokenHeader, token)
+			}
+			rec := serve(deleteFileHandler, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if _, err := os.Stat(path); (err == nil) != tt.wantKept {
+				t.Errorf("file kept = %v, want %v", err == nil, tt.wantKept)
+			}
+			locksMu.Lock()
+			_, held := locks[path]
+			locksMu.Unlock()
+			if held != tt.wantKept {
+				t.Errorf("lock held = %v, want %v", held, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestOverwriteLockedFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		owner       bool
+		wantStatus  int
+		wantContent string
+	}{
+		{"without token", false, 423, "original"},
+		{"owner", true, 201, "replacement"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDir(t)
+			setFlag(t, "upload-collision", "overwrite")
+			path := writeTestFile(t, "a.txt", "original")
+			token := lockFile(t, "a.txt")
+
+			r := httptest.NewRequest("PUT", "/upload?file=a.txt", strings.NewReader("replacement"))
+			if tt.owner {
+				r.Header.Set(lockTokenHeader, token)
+			}
+			rec := serve(rawUploadHandler, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %q", rec.Code, tt.wantStatus, rec.Body)
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.wantContent {
+				t.Errorf("content = %q, want %q", data, tt.wantContent)
+			}
+		})
+	}
+}
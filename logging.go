@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	logLevel slog.Level
+
+	logSample    = flag.Int("log-sample", 0, "emit at most this many copies of the same message per second below error level (0 = no sampling)")
+	redactParams = flag.String("redact-params", "sig,token,key", "comma separated query parameters whose values are replaced in log output (empty = log queries as sent)")
+)
+
+// redactedParams holds the -redact-params names, lower-cased.
+var redactedParams = make(map[string]bool)
+
+func init() {
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "minimum log level: debug, info, warn or error")
+}
+
+// setupLogging installs the slog default logger. It must run after
+// flag.Parse; messages logged through the standard log package are routed
+// through it as well, at info level. Everything logged is also kept in
+// recentLogs for GET /admin/logs.
+func setupLogging() {
+	var handler slog.Handler = slog.NewTextHandler(io.MultiWriter(os.Stderr, recentLogs), &slog.HandlerOptions{Level: logLevel})
+	if *logSample > 0 {
+		handler = &samplingHandler{
+			Handler: handler,
+			state:   &sampleState{perSecond: *logSample, counts: make(map[string]*sampleCount)},
+		}
+	}
+	slog.SetDefault(slog.New(handler))
+
+	for _, name := range strings.Split(*redactParams, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			redactedParams[name] = true
+		}
+	}
+}
+
+// logQuery returns the request's query for logging, with the values of
+// -redact-params replaced so signatures and tokens never reach the log.
+func logQuery(r *http.Request) string {
+	if len(redactedParams) == 0 || r.URL.RawQuery == "" {
+		return r.URL.RawQuery
+	}
+	// A malformed query is still logged as far as it parses
+	values, _ := url.ParseQuery(r.URL.RawQuery)
+	for name, vs := range values {
+		if redactedParams[strings.ToLower(name)] {
+			for i := range vs {
+				vs[i] = "REDACTED"
+			}
+		}
+	}
+	return values.Encode()
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// samplingHandler drops repeats of the same message beyond a per-second
+// budget so hot paths cannot flood the log. Errors are never sampled. The
+// first record let through after a drop carries the number suppressed.
+type samplingHandler struct {
+	slog.Handler
+	state *sampleState
+}
+
+type sampleState struct {
+	perSecond int
+
+	mu     sync.Mutex
+	counts map[string]*sampleCount
+}
+
+type sampleCount struct {
+	window  time.Time
+	seen    int
+	dropped int
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelError {
+		return h.Handler.Handle(ctx, record)
+	}
+
+	window := record.Time.Truncate(time.Second)
+
+	h.state.mu.Lock()
+	c := h.state.counts[record.Message]
+	if c == nil {
+		c = &sampleCount{}
+		h.state.counts[record.Message] = c
+	}
+	if !c.window.Equal(window) {
+		c.window = window
+		c.seen = 0
+	}
+	c.seen++
+	if c.seen > h.state.perSecond {
+		c.dropped++
+		h.state.mu.Unlock()
+		return nil
+	}
+	dropped := c.dropped
+	c.dropped = 0
+	h.state.mu.Unlock()
+
+	if dropped > 0 {
+		record = record.Clone()
+		record.AddAttrs(slog.Int("suppressed", dropped))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), state: h.state}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), state: h.state}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useRedactedParams sets the -redact-params names for the rest of the test.
+func useRedactedParams(t *testing.T, names ...string) {
+	t.Helper()
+	saved := redactedParams
+	redactedParams = make(map[string]bool)
+	for _, name := range names {
+		redactedParams[name] = true
+	}
+	t.Cleanup(func() { redactedParams = saved })
+}
+
+func TestLogQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		redacted []string
+		query    string
+		want     string
+	}{
+		{"nothing to redact", []string{"sig", "token", "key"}, "file=a.txt", "file=a.txt"},
+		{"signature", []string{"sig", "token", "key"}, "file=a.txt&sig=abc123&expires=99", "expires=99&file=a.txt&sig=REDACTED"},
+		{"every value", []string{"token"}, "token=a&token=b", "token=REDACTED&token=REDACTED"},
+		{"name case", []string{"key"}, "KEY=secret", "KEY=REDACTED"},
+		{"redaction off", nil, "file=a.txt&sig=abc123", "file=a.txt&sig=abc123"},
+		{"empty query", []string{"sig"}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRedactedParams(t, tt.redacted...)
+			r := httptest.NewRequest("GET", "/download?"+tt.query, nil)
+			if got := logQuery(r); got != tt.want {
+				t.Errorf("logQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+// A download logged with its query must not leak the signature.
+func TestQueryRedactedInLog(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "hello")
+	useRedactedParams(t, "sig", "token", "key")
+	var logged bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+
+	serve(downloadHandler, newRequest("GET", "/download?file=a.txt&sig=topsecret"))
+
+	out := logged.String()
+	if !strings.Contains(out, "Starting download request") {
+		t.Fatalf("request was not logged:\n%s", out)
+	}
+	if strings.Contains(out, "topsecret") {
+		t.Errorf("signature leaked into the log:\n%s", out)
+	}
+	if !strings.Contains(out, "sig=REDACTED") {
+		t.Errorf("log does not show the redacted parameter:\n%s", out)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	logBuffer    = flag.Int("log-buffer", 1000, "number of recent log lines kept in memory for GET /admin/logs")
+	logStreamers = flag.Int("log-streamers", 4, "maximum concurrent GET /admin/logs?follow=true streams")
+)
+
+const (
+	// logStreamBacklog is how many lines a streamer may fall behind before
+	// further lines are dropped for it.
+	logStreamBacklog = 256
+	logKeepAlive     = 15 * time.Second
+)
+
+// logRing keeps the latest log lines and fans new ones out to streamers.
+// The text handler writes one record per Write call, so every Write is one
+// line. Writes never wait on a streamer: a slow one loses lines instead.
+type logRing struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	filled  bool
+	streams map[chan string]*atomic.Int64 // line channel to dropped count
+}
+
+var recentLogs = &logRing{streams: make(map[chan string]*atomic.Int64)}
+
+func (ring *logRing) Write(p []byte) (int, error) {
+	line := string(bytes.TrimRight(p, "\n"))
+
+	ring.mu.Lock()
+	if ring.lines == nil {
+		ring.lines = make([]string, max(*logBuffer, 1))
+	}
+	ring.lines[ring.next] = line
+	ring.next++
+	if ring.next == len(ring.lines) {
+		ring.next = 0
+		ring.filled = true
+	}
+	for ch, dropped := range ring.streams {
+		select {
+		case ch <- line:
+		default:
+			dropped.Add(1)
+		}
+	}
+	ring.mu.Unlock()
+	return len(p), nil
+}
+
+// subscribe returns the buffered lines, oldest first, and a channel that
+// receives every line written afterwards.
+func (ring *logRing) subscribe() ([]string, chan string, *atomic.Int64) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ch := make(chan string, logStreamBacklog)
+	dropped := new(atomic.Int64)
+	ring.streams[ch] = dropped
+	return ring.snapshotLocked(), ch, dropped
+}
+
+func (ring *logRing) unsubscribe(ch chan string) {
+	ring.mu.Lock()
+	delete(ring.streams, ch)
+	ring.mu.Unlock()
+}
+
+func (ring *logRing) snapshot() []string {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	return ring.snapshotLocked()
+}
+
+func (ring *logRing) snapshotLocked() []string {
+	if !ring.filled {
+		return append([]string(nil), ring.lines[:ring.next]...)
+	}
+	return append(append([]string(nil), ring.lines[ring.next:]...), ring.lines[:ring.next]...)
+}
+
+var activeLogStreams atomic.Int64
+
+// adminLogsHandler handles GET /admin/logs. The recent lines are sent as
+// Server-Sent Events; with ?follow=true the stream stays open for new lines.
+func adminLogsHandler(w http.ResponseWriter, r *http.Request) {
+	follow := r.URL.Query().Get("follow") == "true"
+	if follow {
+		if activeLogStreams.Add(1) > int64(*logStreamers) {
+			activeLogStreams.Add(-1)
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "Too many log streams", http.StatusServiceUnavailable)
+			return
+		}
+		defer activeLogStreams.Add(-1)
+	}
+
+	var lines []string
+	var live chan string
+	var dropped *atomic.Int64
+	if follow {
+		lines, live, dropped = recentLogs.subscribe()
+		defer recentLogs.unsubscribe(live)
+	} else {
+		lines = recentLogs.snapshot()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(format string, args ...any) bool {
+		// Like followFile, bound each write rather than the whole stream
+		rc.SetWriteDeadline(time.Now().Add(followWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	for _, line := range lines {
+		if !send("data: %s\n\n", line) {
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+
+	keepAlive := time.NewTicker(logKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-live:
+			if n := dropped.Swap(0); n > 0 && !send("event: dropped\ndata: %d\n\n", n) {
+				return
+			}
+			if !send("data: %s\n\n", line) {
+				return
+			}
+		case <-keepAlive.C:
+			if !send(": keep-alive\n\n") {
+				return
+			}
+		}
+	}
+}
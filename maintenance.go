@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	maintenanceAtStart = flag.Bool("maintenance", false, "start in maintenance mode; POST/DELETE /admin/maintenance switch it at runtime")
+	maintenanceMessage = flag.String("maintenance-message", "Server is down for maintenance", "plain text sent with the 503 during maintenance when there is no -maintenance-page")
+	maintenancePage    = flag.String("maintenance-page", "", "file served with status 503 during maintenance, e.g. an HTML page (read on every use)")
+	maintenanceRetry   = flag.Duration("maintenance-retry-after", 5*time.Minute, "Retry-After sent with maintenance responses")
+)
+
+// maintenance holds the active maintenance state; nil means the service is
+// up. Unlike drain, which only refuses new downloads, maintenance answers
+// every route except the admin API and the health checks with 503.
+var maintenance atomic.Pointer[maintenanceState]
+
+type maintenanceState struct {
+	Message string    `json:"message"`
+	Since   time.Time `json:"since"`
+}
+
+// maintenanceExempt reports whether path keeps working during maintenance,
+// so operators can still manage the instance and monitors see its state.
+func maintenanceExempt(path string) bool {
+	switch path {
+	case "/health", "/readyz", "/metrics":
+		return true
+	}
+	return strings.HasPrefix(path, "/admin/")
+}
+
+// checkMaintenance makes sure -maintenance-page points at a readable file
+// and enters maintenance mode if -maintenance is set.
+func checkMaintenance() error {
+	if *maintenancePage != "" {
+		stat, err := os.Stat(*maintenancePage)
+		if err != nil {
+			return err
+		}
+		if !stat.Mode().IsRegular() {
+			return errors.New(*maintenancePage + " is not a regular file")
+		}
+	}
+	if *maintenanceAtStart {
+		maintenance.Store(&maintenanceState{Message: *maintenanceMessage, Since: time.Now()})
+		slog.Info("Starting in maintenance mode")
+	}
+	return nil
+}
+
+// rejectDuringMaintenance answers requests with 503 while maintenance mode
+// is on. It sits in front of the mux since the mode can change at runtime.
+func rejectDuringMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := maintenance.Load()
+		if state == nil || maintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeMaintenance(w, state)
+	})
+}
+
+// writeMaintenance sends the -maintenance-page, or the plain message
+// without one.
+func writeMaintenance(w http.ResponseWriter, state *maintenanceState) {
+	if *maintenanceRetry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((*maintenanceRetry).Seconds())))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if *maintenancePage != "" {
+		body, err := os.ReadFile(*maintenancePage)
+		if err == nil {
+			contentType := mime.TypeByExtension(filepath.Ext(*maintenancePage))
+			if contentType == "" {
+				contentType = http.DetectContentType(body)
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(body)
+			return
+		}
+		slog.Error("Failed to read -maintenance-page", "error", err)
+	}
+	http.Error(w, state.Message, http.StatusServiceUnavailable)
+}
+
+// adminMaintenanceOnHandler handles POST /admin/maintenance[?message=...].
+func adminMaintenanceOnHandler(w http.ResponseWriter, r *http.Request) {
+	message := r.URL.Query().Get("message")
+	if message == "" {
+		message = *maintenanceMessage
+	}
+	maintenance.Store(&maintenanceState{Message: message, Since: time.Now()})
+	slog.Info("Maintenance mode enabled", "message", message, "active_downloads", workers.activeCount())
+	writeMaintenanceState(w)
+}
+
+// adminMaintenanceOffHandler handles DELETE /admin/maintenance.
+func adminMaintenanceOffHandler(w http.ResponseWriter, r *http.Request) {
+	if maintenance.Swap(nil) != nil {
+		slog.Info("Maintenance mode disabled")
+	}
+	writeMaintenanceState(w)
+}
+
+func writeMaintenanceState(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	state := maintenance.Load()
+	json.NewEncoder(w).Encode(map[string]any{
+		"maintenance": state != nil,
+		"state":       state,
+	})
+}
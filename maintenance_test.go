@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "hello")
+	setFlag(t, "maintenance-retry-after", "10m")
+	t.Cleanup(func() { maintenance.Store(nil) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /download", downloadHandler)
+	mux.HandleFunc("GET /files", filesHandler)
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("POST /admin/maintenance", adminMaintenanceOnHandler)
+	mux.HandleFunc("DELETE /admin/maintenance", adminMaintenanceOffHandler)
+	handler := rejectDuringMaintenance(mux).ServeHTTP
+
+	page := filepath.Join(t.TempDir(), "maintenance.html")
+	if err := os.WriteFile(page, []byte("<h1>Back soon</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(handler, newRequest("POST", "/admin/maintenance?message=Upgrading+storage")); rec.Code != http.StatusOK {
+		t.Fatalf("enabling maintenance: status = %d", rec.Code)
+	}
+
+	tests := []struct {
+		name        string
+		page        string
+		method      string
+		url         string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"download blocked", "", "GET", "/download?file=a.txt", http.StatusServiceUnavailable, "text/plain; charset=utf-8", "Upgrading storage\n"},
+		{"listing blocked", "", "GET", "/files", http.StatusServiceUnavailable, "text/plain; charset=utf-8", "Upgrading storage\n"},
+		{"custom page", page, "GET", "/download?file=a.txt", http.StatusServiceUnavailable, "text/html; charset=utf-8", "<h1>Back soon</h1>"},
+		{"health works", "", "GET", "/health", http.StatusOK, "application/json", `"status":"maintenance"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "maintenance-page", tt.page)
+			rec := serve(handler, newRequest(tt.method, tt.url))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body, tt.body)
+			}
+			if tt.status == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") != "600" {
+				t.Errorf("Retry-After = %q, want 600", rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+
+	t.Run("admin turns it off", func(t *testing.T) {
+		rec := serve(handler, newRequest("DELETE", "/admin/maintenance"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		var state struct{ Maintenance bool }
+		json.NewDecoder(rec.Body).Decode(&state)
+		if state.Maintenance {
+			t.Error("admin API still reports maintenance")
+		}
+		if rec := serve(handler, newRequest("GET", "/download?file=a.txt")); rec.Code != http.StatusOK {
+			t.Errorf("download after maintenance: status = %d", rec.Code)
+		}
+	})
+}
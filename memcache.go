@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	memoryCacheSize     = flag.Int64("memory-cache", 0, "keep up to this many bytes of recently downloaded files in memory, least recently used first out (0 = off)")
+	memoryCacheFileSize = flag.Int64("memory-cache-file-size", 1<<20, "largest file kept in the -memory-cache")
+)
+
+// memCache holds the contents of hot small files. A cached file is served
+// through the same servedFile interface as one on disk, so ranges, digests
+// and verification read the cached bytes without touching the disk.
+var memCache = &memoryCache{entries: make(map[string]*list.Element), order: list.New()}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	used    int64
+	entries map[string]*list.Element // by absolute path
+	order   *list.List               // front = most recently used
+
+	hits, misses atomic.Int64
+}
+
+type memoryEntry struct {
+	path string
+	stat fs.FileInfo
+	data []byte
+}
+
+// open returns the cached contents of filePath, reading the file into the
+// cache on a miss. It reports false when the cache is off or the file
+// does not qualify, and the caller should open the file from disk.
+func (c *memoryCache) open(filePath string) (servedFile, bool) {
+	if *memoryCacheSize <= 0 {
+		return nil, false
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() > min(*memoryCacheFileSize, *memoryCacheSize) {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	if el, ok := c.entries[filePath]; ok {
+		entry := el.Value.(*memoryEntry)
+		if entry.stat.Size() == stat.Size() && entry.stat.ModTime().Equal(stat.ModTime()) {
+			c.order.MoveToFront(el)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return newMemoryFile(entry), true
+		}
+		c.removeLocked(el)
+	}
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	data, err := os.ReadFile(filePath)
+	if err != nil || int64(len(data)) != stat.Size() {
+		// Changed while reading; leave it to the disk path
+		return nil, false
+	}
+	entry := &memoryEntry{path: filePath, stat: stat, data: data}
+
+	c.mu.Lock()
+	if el, ok := c.entries[filePath]; ok {
+		c.removeLocked(el)
+	}
+	for c.used+stat.Size() > *memoryCacheSize && c.order.Len() > 0 {
+		c.removeLocked(c.order.Back())
+	}
+	c.entries[filePath] = c.order.PushFront(entry)
+	c.used += stat.Size()
+	c.mu.Unlock()
+
+	slog.Debug("Cached file in memory", "path", filePath, "bytes", stat.Size())
+	return newMemoryFile(entry), true
+}
+
+func (c *memoryCache) removeLocked(el *list.Element) {
+	entry := c.order.Remove(el).(*memoryEntry)
+	delete(c.entries, entry.path)
+	c.used -= entry.stat.Size()
+}
+
+// forget drops filePath from the cache.
+func (c *memoryCache) forget(filePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[filePath]; ok {
+		c.removeLocked(el)
+	}
+}
+
+// usage returns the number of cached bytes.
+func (c *memoryCache) usage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// memoryFile serves one cached entry. Entries are never modified, only
+// replaced, so any number of them can read the same bytes at once.
+type memoryFile struct {
+	*bytes.Reader
+	stat fs.FileInfo
+}
+
+func newMemoryFile(entry *memoryEntry) memoryFile {
+	return memoryFile{Reader: bytes.NewReader(entry.data), stat: entry.stat}
+}
+
+func (f memoryFile) Stat() (fs.FileInfo, error) { return f.stat, nil }
+
+func (f memoryFile) Close() error { return nil }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+// Ranges of a cached file come from the cached bytes: the file on disk is
+// overwritten behind the cache's back, keeping its size and modtime, and the
+// responses still carry the original content.
+func TestMemoryCacheRanges(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "memory-cache", "1048576")
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	path := writeTestFile(t, "hot.bin", content)
+	t.Cleanup(func() { memCache.forget(path) })
+
+	if rec := serve(downloadHandler, newRequest("GET", "/download?file=hot.bin")); rec.Body.String() != content {
+		t.Fatalf("first download = %q", rec.Body)
+	}
+	stat, _ := os.Stat(path)
+	if err := os.WriteFile(path, []byte("XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, stat.ModTime(), stat.ModTime())
+
+	tests := []struct {
+		name   string
+		rng    string
+		status int
+		cr     string
+		body   string
+	}{
+		{"middle", "bytes=10-15", http.StatusPartialContent, "bytes 10-15/36", content[10:16]},
+		{"suffix", "bytes=-4", http.StatusPartialContent, "bytes 32-35/36", content[32:]},
+		{"open ended", "bytes=30-", http.StatusPartialContent, "bytes 30-35/36", content[30:]},
+		{"end clamped", "bytes=0-100", http.StatusPartialContent, "bytes 0-35/36", content},
+		{"unsatisfiable", "bytes=36-", http.StatusRequestedRangeNotSatisfiable, "bytes */36", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, misses := memCache.hits.Load(), memCache.misses.Load()
+			rec := serve(downloadHandler, newRequest("GET", "/download?file=hot.bin", "Range", tt.rng))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.cr {
+				t.Errorf("Content-Range = %q, want %q", got, tt.cr)
+			}
+			if tt.status == http.StatusPartialContent && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want cached bytes %q", rec.Body, tt.body)
+			}
+			if memCache.hits.Load() != hits+1 || memCache.misses.Load() != misses {
+				t.Errorf("cache hits +%d, misses +%d; want one hit", memCache.hits.Load()-hits, memCache.misses.Load()-misses)
+			}
+		})
+	}
+}
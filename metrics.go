@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// downloadOutcomes lists every status so each series is exported from the
+// start, even before its first occurrence.
+var downloadOutcomes = []string{downloadCompleted, downloadCancelled, downloadAborted, downloadFailed}
+
+// metricsHandler handles GET /metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	totals, active := downloads.counters()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP atc4_downloads_total Finished downloads by outcome.")
+	fmt.Fprintln(w, "# TYPE atc4_downloads_total counter")
+	for _, outcome := range downloadOutcomes {
+		fmt.Fprintf(w, "atc4_downloads_total{outcome=%q} %d\n", outcome, totals[outcome].count)
+	}
+
+	fmt.Fprintln(w, "# HELP atc4_download_bytes_total Bytes sent by finished downloads, by outcome.")
+	fmt.Fprintln(w, "# TYPE atc4_download_bytes_total counter")
+	for _, outcome := range downloadOutcomes {
+		fmt.Fprintf(w, "atc4_download_bytes_total{outcome=%q} %d\n", outcome, totals[outcome].bytes)
+	}
+
+	fmt.Fprintln(w, "# HELP atc4_active_downloads Downloads currently streaming.")
+	fmt.Fprintln(w, "# TYPE atc4_active_downloads gauge")
+	fmt.Fprintf(w, "atc4_active_downloads %d\n", active)
+
+	fmt.Fprintln(w, "# HELP atc4_queue_length Requests waiting for a worker.")
+	fmt.Fprintln(w, "# TYPE atc4_queue_length gauge")
+	fmt.Fprintf(w, "atc4_queue_length %d\n", queueLength())
+
+	fmt.Fprintln(w, "# HELP atc4_reaped_connections_total Idle connections closed by the reaper.")
+	fmt.Fprintln(w, "# TYPE atc4_reaped_connections_total counter")
+	fmt.Fprintf(w, "atc4_reaped_connections_total %d\n", reapedConns.Load())
+
+	fmt.Fprintln(w, "# HELP atc4_file_replacements_total Files found replaced at the same path, between requests or during a download.")
+	fmt.Fprintln(w, "# TYPE atc4_file_replacements_total counter")
+	fmt.Fprintf(w, "atc4_file_replacements_total{when=\"between_requests\"} %d\n", replacedBetween.Load())
+	fmt.Fprintf(w, "atc4_file_replacements_total{when=\"during_download\"} %d\n", replacedDuring.Load())
+
+	fmt.Fprintln(w, "# HELP atc4_memory_cache_requests_total Lookups in the -memory-cache by result.")
+	fmt.Fprintln(w, "# TYPE atc4_memory_cache_requests_total counter")
+	fmt.Fprintf(w, "atc4_memory_cache_requests_total{result=\"hit\"} %d\n", memCache.hits.Load())
+	fmt.Fprintf(w, "atc4_memory_cache_requests_total{result=\"miss\"} %d\n", memCache.misses.Load())
+
+	fmt.Fprintln(w, "# HELP atc4_memory_cache_bytes Bytes held in the -memory-cache.")
+	fmt.Fprintln(w, "# TYPE atc4_memory_cache_bytes gauge")
+	fmt.Fprintf(w, "atc4_memory_cache_bytes %d\n", memCache.usage())
+
+	fmt.Fprintln(w, "# HELP atc4_tier_requests_total Queued requests by client tier.")
+	fmt.Fprintln(w, "# TYPE atc4_tier_requests_total counter")
+	tierCounts := tierRequestCounts()
+	for _, tier := range slices.Sorted(maps.Keys(tierCounts)) {
+		fmt.Fprintf(w, "atc4_tier_requests_total{tier=%q} %d\n", tier, tierCounts[tier])
+	}
+
+	durations := downloadDurations.summary(*statsWindow)
+	writeSummary(w, "atc4_download_duration_seconds", "Duration of completed downloads, with quantiles over -stats-window.", durations)
+
+	fmt.Fprintln(w, "# HELP atc4_download_throughput_bytes_per_second Average throughput of completed downloads within -stats-window.")
+	fmt.Fprintln(w, "# TYPE atc4_download_throughput_bytes_per_second gauge")
+	throughput := 0.0
+	if durations.sum > 0 {
+		throughput = float64(durations.bytes) / durations.sum
+	}
+	fmt.Fprintf(w, "atc4_download_throughput_bytes_per_second %g\n", throughput)
+
+	writeSummary(w, "atc4_queue_wait_seconds", "Time requests waited for a worker, with quantiles over -stats-window.", queueWaits.summary(*statsWindow))
+}
+
+func writeSummary(w io.Writer, name, help string, s windowSummary) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s summary\n", name)
+	for i, q := range statQuantiles {
+		fmt.Fprintf(w, "%s{quantile=\"%g\"} %g\n", name, q, s.quantiles[i])
+	}
+	fmt.Fprintf(w, "%s_sum %g\n", name, s.totalSum)
+	fmt.Fprintf(w, "%s_count %d\n", name, s.totalCount)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// middleware wraps a route's handler. The name is what GET /admin/routes
+// reports for the route.
+type middleware struct {
+	name string
+	wrap func(http.HandlerFunc) http.HandlerFunc
+}
+
+var (
+	recoverPanics   = middleware{"recover", withRecovery}
+	accessLog       = middleware{"log", withAccessLog}
+	adminAuth       = middleware{"admin-auth", requireAdmin}
+	dashboardAccess = middleware{"dashboard-auth", requireDashboardAuth}
+	workerQueue     = middleware{"queue", queued}
+	metadataLimit   = middleware{"timeout", withTimeout(metadataTimeout, "-metadata-timeout")}
+	requestLimit    = middleware{"request-timeout", withTimeout(requestTimeout, "-request-timeout")}
+	idempotent      = middleware{"idempotency", withIdempotency}
+)
+
+// baseMiddleware runs in front of every route, before the route's own.
+var baseMiddleware = []middleware{recoverPanics, accessLog}
+
+type routeInfo struct {
+	Pattern    string   `json:"pattern"`
+	Middleware []string `json:"middleware"`
+}
+
+// routes records what handle registered, in registration order.
+var routes []routeInfo
+
+// handle registers handler for pattern behind baseMiddleware and mws. The
+// first middleware listed is the outermost one and sees the request first,
+// so checks that reject cheaply, such as authentication, go before the
+// worker queue.
+func handle(pattern string, handler http.HandlerFunc, mws ...middleware) {
+	chain := append(append([]middleware(nil), baseMiddleware...), mws...)
+
+	names := make([]string, len(chain))
+	for i, mw := range chain {
+		names[i] = mw.name
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i].wrap(handler)
+	}
+
+	http.HandleFunc(pattern, handler)
+	routes = append(routes, routeInfo{Pattern: pattern, Middleware: names})
+}
+
+// serverMiddleware names the active wrappers that apply to every request
+// before routing.
+func serverMiddleware() []string {
+	names := []string{}
+	if allowedHostList != nil {
+		names = append(names, "allowed-hosts")
+	}
+	if allowedCNs != nil {
+		names = append(names, "client-cn")
+	}
+	names = append(names, "maintenance")
+	if *readOnly {
+		names = append(names, "read-only")
+	}
+	return names
+}
+
+// withRecovery turns a panicking handler into a 500 response, if nothing
+// was sent yet, and logs the panic instead of leaving it to net/http.
+func withRecovery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			slog.Error("Handler panicked", "method", r.Method, "path", r.URL.Path, "panic", p)
+			if rec.status == 0 {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next(rec, r)
+	}
+}
+
+// withAccessLog logs every request at debug level once it is answered.
+func withAccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		slog.Debug("Request", "method", r.Method, "path", r.URL.Path, "status", rec.statusCode(), "bytes", rec.bytes, "duration", time.Since(start))
+	}
+}
+
+var (
+	metadataTimeout = flag.Duration("metadata-timeout", 10*time.Second, "answer metadata requests (/files, /tree, /du, /batch-info, /health, /readyz) with 503 when they take longer (0 = no limit)")
+	requestTimeout  = flag.Duration("request-timeout", 30*time.Second, "answer other short requests, such as the admin API, locks and jobs, with 503 when they take longer (0 = no limit)")
+)
+
+// withTimeout bounds how long a request may take, so a slow directory walk
+// or a handler bug cannot hold the connection; the handler's context ends
+// at the same deadline. Downloads keep their own, much longer,
+// -download-timeout, and streaming routes are not wrapped at all since the
+// response is buffered until the handler returns, which is only fine for
+// small bodies.
+func withTimeout(limit *time.Duration, flagName string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if *limit <= 0 {
+			return next
+		}
+		limited := http.TimeoutHandler(next, *limit, "Request timed out after "+limit.String())
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
+			limited.ServeHTTP(rec, r)
+			if rec.status == http.StatusServiceUnavailable && time.Since(start) >= *limit {
+				slog.Warn("Request timed out", "method", r.Method, "path", r.URL.Path, "limit", flagName, "timeout", *limit)
+			}
+		}
+	}
+}
+
+// adminRoutesHandler handles GET /admin/routes.
+func adminRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"server": serverMiddleware(),
+		"routes": routes,
+	})
+}
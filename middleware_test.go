@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// tracing returns a middleware that appends its name to trace when a
+// request passes through it.
+func tracing(name string, trace *[]string) middleware {
+	return middleware{name, func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			*trace = append(*trace, name)
+			next(w, r)
+		}
+	}}
+}
+
+// routeNamed returns what handle recorded for pattern.
+func routeNamed(t *testing.T, pattern string) routeInfo {
+	t.Helper()
+	for _, route := range routes {
+		if route.Pattern == pattern {
+			return route
+		}
+	}
+	t.Fatalf("route %q not registered", pattern)
+	return routeInfo{}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	newTestDir(t)
+	useAdminToken(t, "secret")
+
+	var trace []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "handler")
+	}
+	handle("GET /test/order", handler, tracing("first", &trace), tracing("second", &trace))
+	handle("GET /test/admin-before-queue", handler, adminAuth, tracing("queue", &trace))
+	handle("GET /test/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+	tests := []struct {
+		name       string
+		pattern    string
+		url        string
+		headers    []string
+		status     int
+		trace      []string
+		middleware []string
+	}{
+		{"listed order", "GET /test/order", "/test/order", nil, http.StatusOK,
+			[]string{"first", "second", "handler"}, []string{"recover", "log", "first", "second"}},
+		{"auth rejects before queue", "GET /test/admin-before-queue", "/test/admin-before-queue", nil, http.StatusUnauthorized,
+			nil, []string{"recover", "log", "admin-auth", "queue"}},
+		{"auth passes to queue", "GET /test/admin-before-queue", "/test/admin-before-queue", []string{"Authorization", "Bearer secret"}, http.StatusOK,
+			[]string{"queue", "handler"}, []string{"recover", "log", "admin-auth", "queue"}},
+		{"panic recovered", "GET /test/panic", "/test/panic", nil, http.StatusInternalServerError,
+			nil, []string{"recover", "log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace = nil
+			rec := httptest.NewRecorder()
+			http.DefaultServeMux.ServeHTTP(rec, newRequest("GET", tt.url, tt.headers...))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if !slices.Equal(trace, tt.trace) {
+				t.Errorf("request passed %v, want %v", trace, tt.trace)
+			}
+			if got := routeNamed(t, tt.pattern).Middleware; !slices.Equal(got, tt.middleware) {
+				t.Errorf("reported middleware %v, want %v", got, tt.middleware)
+			}
+		})
+	}
+}
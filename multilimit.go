@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var (
+	maxRequestFiles = flag.Int("max-request-files", 1000, "most ?file= parameters accepted by one /zip or /concat request")
+	maxRequestBytes = flag.Int64("max-request-bytes", 0, "largest combined size of the files in one /zip or /concat request (0 = no limit)")
+)
+
+// checkFileCount rejects multi-file requests naming more than
+// -max-request-files files. Duplicates count, since they are checked
+// before anything is resolved.
+func checkFileCount(n int) *validationError {
+	if *maxRequestFiles > 0 && n > *maxRequestFiles {
+		return invalidParam("file", fmt.Sprintf("at most %d files are allowed per request, got %d", *maxRequestFiles, n))
+	}
+	return nil
+}
+
+// checkTotalSize rejects multi-file requests whose files add up to more
+// than -max-request-bytes.
+func checkTotalSize(total int64) *validationError {
+	if *maxRequestBytes > 0 && total > *maxRequestBytes {
+		return invalidParam("file", fmt.Sprintf("files total %d bytes, more than the limit of %d bytes per request", total, *maxRequestBytes))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMultiFileLimits(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a", "12345")
+	writeTestFile(t, "b", "67890")
+	setFlag(t, "max-request-files", "2")
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		path     string
+		files    []string
+		maxBytes string
+		status   int
+		reason   string
+	}{
+		{"concat at file limit", concatHandler, "/concat", []string{"a", "b"}, "0", http.StatusOK, ""},
+		{"concat above file limit", concatHandler, "/concat", []string{"a", "b", "a"}, "0", http.StatusBadRequest, "at most 2 files"},
+		{"zip at file limit", zipHandler, "/zip", []string{"a", "b"}, "0", http.StatusOK, ""},
+		{"zip above file limit", zipHandler, "/zip", []string{"a", "b", "a"}, "0", http.StatusBadRequest, "at most 2 files"},
+		{"concat at size limit", concatHandler, "/concat", []string{"a", "b"}, "10", http.StatusOK, ""},
+		{"concat above size limit", concatHandler, "/concat", []string{"a", "b"}, "9", http.StatusBadRequest, "limit of 9 bytes"},
+		{"zip above size limit", zipHandler, "/zip", []string{"a", "b"}, "9", http.StatusBadRequest, "limit of 9 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "max-request-bytes", tt.maxBytes)
+			url := tt.path + "?file=" + strings.Join(tt.files, "&file=")
+			rec := serve(tt.handler, newRequest("GET", url))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.reason != "" && !strings.Contains(rec.Body.String(), tt.reason) {
+				t.Errorf("body %q does not name the limit %q", rec.Body, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var notFoundFile = flag.String("not-found-file", "", "file served with status 404 when a requested download does not exist (default: plain text 404)")
+
+// checkNotFoundFile makes sure -not-found-file points at a readable file.
+func checkNotFoundFile() error {
+	if *notFoundFile == "" {
+		return nil
+	}
+	stat, err := os.Stat(*notFoundFile)
+	if err != nil {
+		return err
+	}
+	if !stat.Mode().IsRegular() {
+		return errors.New(*notFoundFile + " is not a regular file")
+	}
+	return nil
+}
+
+// downloadNotFound answers a download of a file that does not exist. The
+// -not-found-file page is read on every use so it can be edited without a
+// restart; without one the built-in 404.html is sent.
+func downloadNotFound(w http.ResponseWriter, r *http.Request) {
+	pageName := *notFoundFile
+	var body []byte
+	if pageName == "" {
+		// Without -not-found-file the built-in page is used, if enabled
+		pageName = "404.html"
+		var ok bool
+		if body, ok = readBuiltin(pageName); !ok {
+			http.NotFound(w, r)
+			return
+		}
+	} else {
+		var err error
+		if body, err = os.ReadFile(pageName); err != nil {
+			slog.Error("Failed to read -not-found-file", "error", err)
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(pageName))
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	// Drop download headers set before the file turned out to be missing
+	w.Header().Del("Content-Disposition")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(body)
+}
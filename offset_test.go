@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// An offset resumes with 200 and the remaining length; only one beyond the
+// end of the file is unsatisfiable.
+func TestOffsetParam(t *testing.T) {
+	newTestDir(t)
+	content := "0123456789"
+	writeTestFile(t, "a.txt", content)
+
+	tests := []struct {
+		name   string
+		offset string
+		status int
+		body   string
+		length string
+	}{
+		{"zero", "0", http.StatusOK, content, "10"},
+		{"middle", "4", http.StatusOK, "456789", "6"},
+		{"last byte", "9", http.StatusOK, "9", "1"},
+		{"at end", "10", http.StatusOK, "", "0"},
+		{"beyond end", "11", http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"negative", "-1", http.StatusBadRequest, "", ""},
+		{"not a number", "ten", http.StatusBadRequest, "", ""},
+	}
+	t.Run("with range", func(t *testing.T) {
+		rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt&offset=2", "Range", "bytes=0-1"))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt&offset="+tt.offset))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.length == "" {
+				return
+			}
+			if got := rec.Header().Get("Content-Length"); got != tt.length {
+				t.Errorf("Content-Length = %q, want %q", got, tt.length)
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+)
+
+var maxParallel = flag.Int("max-parallel", 4, "most parallel range requests per file suggested to download managers in X-Max-Parallel (0 = send no hints)")
+
+const (
+	// minParallelChunk is the smallest chunk worth its own request; files
+	// below twice this size get no hints.
+	minParallelChunk = 8 << 20
+	// parallelChunkAlign rounds suggested chunks to whole MiB.
+	parallelChunkAlign = 1 << 20
+)
+
+// parallelHints suggests how many ranges of a size-byte file a client
+// should fetch at once, and how large each should be. A per-file
+// concurrency limit caps the parallelism, since requests beyond it would
+// only queue or be rejected. ok is false when the file is too small to be
+// worth splitting.
+func parallelHints(size int64, perFileLimit int) (chunk int64, parallel int, ok bool) {
+	parallel = *maxParallel
+	if perFileLimit > 0 && perFileLimit < parallel {
+		parallel = perFileLimit
+	}
+	if n := size / minParallelChunk; n < int64(parallel) {
+		parallel = int(n)
+	}
+	if parallel < 2 {
+		return 0, 0, false
+	}
+
+	chunk = (size + int64(parallel) - 1) / int64(parallel)
+	chunk = (chunk + parallelChunkAlign - 1) / parallelChunkAlign * parallelChunkAlign
+	return chunk, parallel, true
+}
+
+// setParallelHints adds X-Parallel-Chunk-Size and X-Max-Parallel to a
+// response that supports ranges.
+func setParallelHints(h http.Header, size int64, perFileLimit int) {
+	chunk, parallel, ok := parallelHints(size, perFileLimit)
+	if !ok {
+		return
+	}
+	h.Set("X-Parallel-Chunk-Size", strconv.FormatInt(chunk, 10))
+	h.Set("X-Max-Parallel", strconv.Itoa(parallel))
+}
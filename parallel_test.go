@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestParallelHints(t *testing.T) {
+	const mib = 1 << 20
+	tests := []struct {
+		name         string
+		size         int64
+		maxParallel  string
+		perFileLimit int
+		chunk        int64
+		parallel     int
+		ok           bool
+	}{
+		{"small file", 10 * mib, "4", 0, 0, 0, false},
+		{"just splittable", 16 * mib, "4", 0, 8 * mib, 2, true},
+		{"size bounds parallelism", 24 * mib, "4", 0, 8 * mib, 3, true},
+		{"full parallelism", 100 * mib, "4", 0, 25 * mib, 4, true},
+		{"chunk rounded up to MiB", 100*mib + 1, "4", 0, 26 * mib, 4, true},
+		{"per-file limit caps", 100 * mib, "4", 2, 50 * mib, 2, true},
+		{"per-file limit of one", 100 * mib, "4", 1, 0, 0, false},
+		{"limit above flag", 100 * mib, "4", 10, 25 * mib, 4, true},
+		{"hints off", 100 * mib, "0", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "max-parallel", tt.maxParallel)
+			chunk, parallel, ok := parallelHints(tt.size, tt.perFileLimit)
+			if chunk != tt.chunk || parallel != tt.parallel || ok != tt.ok {
+				t.Errorf("parallelHints(%d, %d) = %d, %d, %v; want %d, %d, %v",
+					tt.size, tt.perFileLimit, chunk, parallel, ok, tt.chunk, tt.parallel, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParallelHintHeaders(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "max-parallel", "4")
+	writeTestFile(t, "small.bin", "tiny")
+	for _, name := range []string{"big.bin", "limited.bin"} {
+		if err := os.Truncate(writeTestFile(t, name, ""), 64<<20); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, "limited.bin.meta", `{"max_concurrent": 3}`)
+
+	tests := []struct {
+		name     string
+		file     string
+		chunk    string
+		parallel string
+	}{
+		{"small file", "small.bin", "", ""},
+		{"large file", "big.bin", strconv.Itoa(16 << 20), "4"},
+		{"sidecar limit", "limited.bin", strconv.Itoa(22 << 20), "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest("HEAD", "/download?file="+tt.file))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := rec.Header().Get("X-Parallel-Chunk-Size"); got != tt.chunk {
+				t.Errorf("X-Parallel-Chunk-Size = %q, want %q", got, tt.chunk)
+			}
+			if got := rec.Header().Get("X-Max-Parallel"); got != tt.parallel {
+				t.Errorf("X-Max-Parallel = %q, want %q", got, tt.parallel)
+			}
+		})
+	}
+}
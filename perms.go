@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"strconv"
+)
+
+var (
+	// dirPerm is the mode of every directory the server creates.
+	dirPerm fs.FileMode = 0755
+	// filePerm is the mode of uploaded files.
+	filePerm fs.FileMode = 0644
+)
+
+func init() {
+	flag.Func("dir-perm", "octal mode for directories the server creates (default 0755)", permFlag(&dirPerm))
+	flag.Func("file-perm", "octal mode for uploaded files (default 0644)", permFlag(&filePerm))
+}
+
+func permFlag(mode *fs.FileMode) func(string) error {
+	return func(s string) error {
+		n, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || n > 0777 {
+			return fmt.Errorf("want an octal mode such as 0750, got %q", s)
+		}
+		*mode = fs.FileMode(n)
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+var prewarmFiles = flag.String("prewarm", "", "comma separated files to read once at startup so they are in the page cache")
+
+// prewarm reads the configured files sequentially in the background. It
+// only warms the OS page cache; missing or unreadable files are skipped.
+func prewarm() {
+	if *prewarmFiles == "" {
+		return
+	}
+
+	var names []string
+	for _, name := range strings.Split(*prewarmFiles, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	go func() {
+		start := time.Now()
+		var total int64
+		for i, name := range names {
+			n, err := prewarmFile(name)
+			if err != nil {
+				slog.Warn("Prewarm skipped file", "file", name, "index", i+1, "count", len(names), "error", err)
+				continue
+			}
+			total += n
+			slog.Debug("Prewarmed file", "file", name, "index", i+1, "count", len(names), "bytes", n)
+		}
+		slog.Info("Prewarm finished", "bytes", total, "duration", time.Since(start))
+	}()
+}
+
+func prewarmFile(name string) (int64, error) {
+	filePath, err := resolveDownloadPath(name)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return io.Copy(io.Discard, file)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var progressTTL = flag.Duration("progress-ttl", 10*time.Minute, "how long GET /progress keeps reporting a download after its last transfer ended")
+
+// downloadIDHeader lets a client group several requests, e.g. the segments
+// of a download manager or a resumed transfer, under one progress entry.
+// Responses carry the ID in use, generated when the client sent none.
+const downloadIDHeader = "X-Download-ID"
+
+const maxDownloadIDLength = 128
+
+// progressSegment is one transfer contributing to a logical download. It
+// covers the bytes from start to start+bytes of the file.
+type progressSegment struct {
+	start int64
+	bytes atomic.Int64
+}
+
+// progressEntry aggregates the segments fetched under one download ID.
+type progressEntry struct {
+	file     string
+	size     int64
+	segments []*progressSegment
+	active   int
+	updated  time.Time
+}
+
+type progressResponse struct {
+	ID             string    `json:"id"`
+	File           string    `json:"file"`
+	Size           int64     `json:"size"`
+	Bytes          int64     `json:"bytes"`
+	Progress       float64   `json:"progress"`
+	ActiveSegments int       `json:"active_segments"`
+	Updated        time.Time `json:"updated"`
+}
+
+var (
+	progressMu      sync.Mutex
+	progressEntries = make(map[string]*progressEntry)
+)
+
+// downloadID returns the client's X-Download-ID when it is usable and a new
+// random one otherwise.
+func downloadID(r *http.Request) string {
+	id := r.Header.Get(downloadIDHeader)
+	if id == "" || len(id) > maxDownloadIDLength {
+		return newJobID()
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return newJobID()
+		}
+	}
+	return id
+}
+
+// joinProgress registers a transfer of file starting at offset start. A
+// different file under a known ID starts the entry over.
+func joinProgress(id, file string, size, start int64) *progressSegment {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	expireProgressLocked()
+
+	entry := progressEntries[id]
+	if entry == nil || entry.file != file || entry.size != size {
+		entry = &progressEntry{file: file, size: size}
+		progressEntries[id] = entry
+	}
+	seg := &progressSegment{start: start}
+	entry.segments = append(entry.segments, seg)
+	entry.active++
+	entry.updated = time.Now()
+	return seg
+}
+
+// leaveProgress marks a segment as no longer transferring. Its bytes keep
+// counting towards the entry.
+func leaveProgress(id string, seg *progressSegment) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	if entry := progressEntries[id]; entry != nil && slices.Contains(entry.segments, seg) {
+		entry.active--
+		entry.updated = time.Now()
+	}
+}
+
+func expireProgressLocked() {
+	cutoff := time.Now().Add(-*progressTTL)
+	for id, entry := range progressEntries {
+		if entry.active == 0 && entry.updated.Before(cutoff) {
+			delete(progressEntries, id)
+		}
+	}
+}
+
+// covered returns how many distinct bytes of the file the segments have
+// transferred. Overlapping segments, such as a retried range, count once.
+func (entry *progressEntry) covered() int64 {
+	type span struct{ from, to int64 }
+	spans := make([]span, 0, len(entry.segments))
+	for _, seg := range entry.segments {
+		if n := seg.bytes.Load(); n > 0 {
+			spans = append(spans, span{seg.start, seg.start + n})
+		}
+	}
+	slices.SortFunc(spans, func(a, b span) int { return cmp.Compare(a.from, b.from) })
+
+	var total, end int64
+	for _, s := range spans {
+		if s.to <= end {
+			continue
+		}
+		total += s.to - max(s.from, end)
+		end = s.to
+	}
+	return total
+}
+
+// progressHandler handles GET /progress?id=<download id>.
+func progressHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeValidationError(w, invalidParam("id", "is required"))
+		return
+	}
+
+	progressMu.Lock()
+	expireProgressLocked()
+	entry := progressEntries[id]
+	var resp progressResponse
+	if entry != nil {
+		resp = progressResponse{
+			ID:             id,
+			File:           entry.file,
+			Size:           entry.size,
+			Bytes:          entry.covered(),
+			ActiveSegments: entry.active,
+			Updated:        entry.updated,
+		}
+	}
+	progressMu.Unlock()
+
+	if entry == nil {
+		http.Error(w, "Unknown download ID", http.StatusNotFound)
+		return
+	}
+	if resp.Size > 0 {
+		resp.Progress = float64(resp.Bytes) / float64(resp.Size)
+	} else {
+		resp.Progress = 1
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+)
+
+// -proxy-mode values:
+//
+//	direct    clients connect to the server itself (or through a proxy that
+//	          streams anyway); every chunk is flushed and no hint is sent
+//	stream    nginx and other proxies honoring X-Accel-Buffering; sends
+//	          "X-Accel-Buffering: no" and flushes every chunk so the proxy
+//	          passes bytes through as they arrive
+//	buffered  proxies that cope better with large writes (CDNs, HAProxy,
+//	          nginx with proxy_buffering on); sends "X-Accel-Buffering: yes"
+//	          and leaves flushing to the HTTP server's own buffering
+var proxyMode = flag.String("proxy-mode", "direct", "buffering behavior for the proxy in front of the server: direct, stream or buffered")
+
+func validProxyMode(mode string) bool {
+	return mode == "direct" || mode == "stream" || mode == "buffered"
+}
+
+// applyProxyHints sets the buffering hint for the configured proxy mode.
+func applyProxyHints(w http.ResponseWriter) {
+	switch *proxyMode {
+	case "stream":
+		w.Header().Set("X-Accel-Buffering", "no")
+	case "buffered":
+		w.Header().Set("X-Accel-Buffering", "yes")
+	}
+}
+
+// flushEachChunk reports whether the download loop should flush after every
+// chunk it writes.
+func flushEachChunk() bool {
+	return *proxyMode != "buffered"
+}
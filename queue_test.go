@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// saturateWorkers limits the pool to one worker through -warmup and keeps
+// it busy until the returned function is called.
+func saturateWorkers(t *testing.T) (release func()) {
+	t.Helper()
+	setFlag(t, "warmup", "1h")
+	started := serverStart
+	serverStart = time.Now()
+	t.Cleanup(func() { serverStart = started })
+
+	running := make(chan struct{})
+	unblock := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		enqueue(httptest.NewRecorder(), httptest.NewRequest("GET", "/download?file=busy", nil), func(w http.ResponseWriter, r *http.Request) {
+			close(running)
+			<-unblock
+		})
+	}()
+	select {
+	case <-running:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocking request never started")
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			close(unblock)
+			wg.Wait()
+		})
+	}
+	t.Cleanup(release)
+	return release
+}
+
+func TestMaxQueueWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxWait  string
+		release  time.Duration
+		want     int
+		maxTaken time.Duration
+	}{
+		{"gives up while workers are busy", "50ms", time.Second, http.StatusServiceUnavailable, 900 * time.Millisecond},
+		{"starts once a worker frees up", "1s", 20 * time.Millisecond, http.StatusOK, 900 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := saturateWorkers(t)
+			setFlag(t, "max-queue-wait", tt.maxWait)
+			time.AfterFunc(tt.release, release)
+
+			start := time.Now()
+			rec := httptest.NewRecorder()
+			enqueue(rec, httptest.NewRequest("GET", "/download?file=a.txt", nil), func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Error("503 without Retry-After")
+			}
+			if taken := time.Since(start); taken > tt.maxTaken {
+				t.Errorf("answered after %v", taken)
+			}
+			release()
+		})
+	}
+}
+
+func TestQueueRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   string
+		freeAfter time.Duration // 0 keeps the queue full
+		cancel    bool
+		want      bool
+		header    string
+	}{
+		{"no retries", "0", 0, false, false, ""},
+		{"retries exhausted", "3", 0, false, false, "3"},
+		{"slot frees during retries", "3", 30 * time.Millisecond, false, true, "2"},
+		{"cancelled while waiting", "3", 0, true, false, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "queue-retries", tt.retries)
+			setFlag(t, "queue-retry-interval", "20ms")
+			queue := make(chan Request, 1)
+			queue <- Request{}
+			if tt.freeAfter > 0 {
+				time.AfterFunc(tt.freeAfter, func() { <-queue })
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(5*time.Millisecond, cancel)
+			}
+
+			rec := httptest.NewRecorder()
+			if got := offerRequest(ctx, rec, queue, Request{}); got != tt.want {
+				t.Errorf("offerRequest = %v, want %v", got, tt.want)
+			}
+			if got := rec.Header().Get("X-Queue-Retry"); got != tt.header {
+				t.Errorf("X-Queue-Retry = %q, want %q", got, tt.header)
+			}
+		})
+	}
+}
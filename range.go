@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// parseRange parses a single "bytes=" range against a resource of the given
+// size. ok is false when the header is absent, malformed or asks for several
+// ranges; in that case the Range header is ignored and the full body is sent,
+// which RFC 7233 allows.
+func parseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	if header == "" || !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, false, nil
+	}
+
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	if strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	first = strings.TrimSpace(first)
+	last = strings.TrimSpace(last)
+
+	if first == "" {
+		// Suffix range: the final N bytes.
+		n, valid := parseBytePos(last)
+		if !valid {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, valid := parseBytePos(first)
+	if !valid {
+		return 0, 0, false, nil
+	}
+	if start >= size {
+		return 0, 0, true, errUnsatisfiableRange
+	}
+
+	end := size - 1
+	if last != "" {
+		end, valid = parseBytePos(last)
+		if !valid || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, true, nil
+}
+
+// parseBytePos parses a byte position or suffix length, which RFC 7233
+// restricts to plain digits. Values too large for an int64 are still beyond
+// any file, so they saturate instead of invalidating the range: an end
+// position then clamps to the last byte and a start is unsatisfiable.
+func parseBytePos(s string) (int64, bool) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return math.MaxInt64, true
+	}
+	return n, true
+}
+
+// parseOffset parses the ?offset= query parameter. ok is false when the
+// parameter is absent.
+func parseOffset(value string) (offset int64, ok bool, err error) {
+	if value == "" {
+		return 0, false, nil
+	}
+	offset, err = strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, false, errors.New("invalid offset")
+	}
+	return offset, true, nil
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding header allows
+// the given coding with a non-zero quality value.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		params = strings.ReplaceAll(params, " ", "")
+		if q, found := strings.CutPrefix(params, "q="); found {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// openPrecompressed returns the ".gz" sidecar for filePath when it exists and
+// is a regular file. The caller owns the returned file.
+func openPrecompressed(filePath string) (*os.File, os.FileInfo, bool) {
+	gz, err := os.Open(filePath + ".gz")
+	if err != nil {
+		return nil, nil, false
+	}
+	stat, err := gz.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		gz.Close()
+		return nil, nil, false
+	}
+	return gz, stat, true
+}
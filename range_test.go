@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		start, length int64
+		ok            bool
+		err           error
+	}{
+		{"", 0, 0, false, nil},
+		{"bytes=0-9", 0, 10, true, nil},
+		{"bytes=5-", 5, 95, true, nil},
+		{"bytes=-10", 90, 10, true, nil},
+		{"bytes=-500", 0, 100, true, nil},
+		{"bytes=90-200", 90, 10, true, nil},
+		{"bytes=100-", 0, 0, true, errUnsatisfiableRange},
+		{"bytes=-0", 0, 0, true, errUnsatisfiableRange},
+		{"bytes=0-1,5-6", 0, 0, false, nil},
+		{"bytes=9-3", 0, 0, false, nil},
+		{"items=0-9", 0, 0, false, nil},
+		{"bytes=99999999999999999999-", 0, 0, true, errUnsatisfiableRange},
+	}
+	for _, tt := range tests {
+		start, length, ok, err := parseRange(tt.header, 100)
+		if start != tt.start || length != tt.length || ok != tt.ok || err != tt.err {
+			t.Errorf("parseRange(%q) = %d, %d, %v, %v; want %d, %d, %v, %v",
+				tt.header, start, length, ok, err, tt.start, tt.length, tt.ok, tt.err)
+		}
+	}
+}
+
+// A ranged request always gets identity bytes, even when a .gz sidecar
+// would be served for the whole file.
+func TestRangeWithPrecompressed(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "precompressed", "true")
+	content := "0123456789abcdefghij"
+	writeTestFile(t, "a.txt", content)
+	gz := gzipped(t, content)
+	writeTestFile(t, "a.txt.gz", gz)
+
+	tests := []struct {
+		name     string
+		headers  []string
+		status   int
+		encoding string
+		body     string
+		rng      string
+	}{
+		{"whole, gzip accepted", []string{"Accept-Encoding", "gzip"}, http.StatusOK, "gzip", gz, ""},
+		{"whole, identity", nil, http.StatusOK, "", content, ""},
+		{"range, gzip accepted", []string{"Accept-Encoding", "gzip", "Range", "bytes=10-14"}, http.StatusPartialContent, "", "abcde", "bytes 10-14/20"},
+		{"suffix range", []string{"Range", "bytes=-3"}, http.StatusPartialContent, "", "hij", "bytes 17-19/20"},
+		{"unsatisfiable", []string{"Accept-Encoding", "gzip", "Range", "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, "", "", "bytes */20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt", tt.headers...))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.rng {
+				t.Errorf("Content-Range = %q, want %q", got, tt.rng)
+			}
+			if tt.status != http.StatusRequestedRangeNotSatisfiable && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+// A .gz sidecar is sent with its own length and the original file name.
+func TestPrecompressedHeaders(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "precompressed", "true")
+	content := strings.Repeat("report line\n", 200)
+	writeTestFile(t, "report.csv", content)
+	gz := gzipped(t, content)
+	writeTestFile(t, "report.csv.gz", gz)
+
+	tests := []struct {
+		method string
+	}{
+		{"GET"},
+		{"HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest(tt.method, "/download?file=report.csv&inline=true", "Accept-Encoding", "gzip, deflate"))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(len(gz)); got != want {
+				t.Errorf("Content-Length = %q, want the .gz size %s", got, want)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+				t.Errorf("Content-Encoding = %q, want gzip", got)
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != `inline; filename="report.csv"` {
+				t.Errorf("Content-Disposition = %q, want the original name", got)
+			}
+			if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+				t.Errorf("Content-Type = %q, want the original file's type", got)
+			}
+		})
+	}
+}
+
+// An end past the last byte is clamped; only a start past it is
+// unsatisfiable.
+func TestRangeAtEOF(t *testing.T) {
+	newTestDir(t)
+	content := strings.Repeat("0123456789", 50)
+	writeTestFile(t, "a.bin", content)
+
+	tests := []struct {
+		name   string
+		rng    string
+		status int
+		cr     string
+		body   string
+	}{
+		{"end overflow", "bytes=0-999999", http.StatusPartialContent, "bytes 0-499/500", content},
+		{"end overflow from middle", "bytes=450-999999", http.StatusPartialContent, "bytes 450-499/500", content[450:]},
+		{"end at last byte", "bytes=490-499", http.StatusPartialContent, "bytes 490-499/500", content[490:]},
+		{"end one past", "bytes=490-500", http.StatusPartialContent, "bytes 490-499/500", content[490:]},
+		{"start at last byte", "bytes=499-", http.StatusPartialContent, "bytes 499-499/500", content[499:]},
+		{"suffix overflow", "bytes=-999999", http.StatusPartialContent, "bytes 0-499/500", content},
+		{"start overflow", "bytes=500-999999", http.StatusRequestedRangeNotSatisfiable, "bytes */500", ""},
+		{"start far overflow", "bytes=999999-", http.StatusRequestedRangeNotSatisfiable, "bytes */500", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest("GET", "/download?file=a.bin", "Range", tt.rng))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.cr {
+				t.Errorf("Content-Range = %q, want %q", got, tt.cr)
+			}
+			if tt.status != http.StatusPartialContent {
+				return
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body of %d bytes, want %d", rec.Body.Len(), len(tt.body))
+			}
+			if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(len(tt.body)); got != want {
+				t.Errorf("Content-Length = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+)
+
+var readOnly = flag.Bool("read-only", false, "reject every request that could change files or server state with 403, e.g. for a replica; downloads and listings keep working")
+
+// readOnlyAllowed lists the non-GET routes that only read files. Locks,
+// draining and maintenance mode are state kept in memory. Staging a job is
+// left out since it writes snapshots to disk.
+var readOnlyAllowed = map[string]bool{
+	"POST /batch-info": true,
+	"POST /locks":      true,
+	"DELETE /locks":    true,
+
+	"POST /admin/drain":         true,
+	"POST /admin/undrain":       true,
+	"POST /admin/maintenance":   true,
+	"DELETE /admin/maintenance": true,
+}
+
+// rejectWrites enforces -read-only in front of the mux. It decides by method
+// rather than by route, so a mutating endpoint added later is blocked
+// unless it is listed in readOnlyAllowed.
+func rejectWrites(next http.Handler) http.Handler {
+	if !*readOnly {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !readOnlyAllowed[r.Method+" "+r.URL.Path] {
+				slog.Info("Rejected request in read-only mode", "method", r.Method, "path", r.URL.Path)
+				http.Error(w, "Server is read-only", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRejectWrites(t *testing.T) {
+	setFlag(t, "read-only", "true")
+	handler := rejectWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/download", http.StatusNoContent},
+		{"HEAD", "/files", http.StatusNoContent},
+		{"POST", "/admin/drain", http.StatusNoContent},
+		{"POST", "/admin/undrain", http.StatusNoContent},
+		{"POST", "/admin/maintenance", http.StatusNoContent},
+		{"DELETE", "/admin/maintenance", http.StatusNoContent},
+		{"POST", "/upload", http.StatusForbidden},
+		{"PUT", "/upload", http.StatusForbidden},
+		{"DELETE", "/files", http.StatusForbidden},
+		{"POST", "/admin/reload", http.StatusForbidden},
+		{"POST", "/jobs", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"time"
+)
+
+var (
+	readRetries      = flag.Int("read-retries", 2, "times a failed file read is retried before the download is aborted")
+	readRetryBackoff = flag.Duration("read-retry-backoff", 100*time.Millisecond, "wait before the first read retry; doubles on each further attempt")
+)
+
+// retryReader reads a file sequentially from an offset, retrying failed
+// reads with backoff. Reads are positional, so a retry picks up exactly
+// where the last good read stopped regardless of the file's seek offset.
+type retryReader struct {
+	ctx  context.Context
+	file io.ReaderAt
+	pos  int64
+	name string
+}
+
+func (rr *retryReader) Read(p []byte) (int, error) {
+	backoff := *readRetryBackoff
+	for attempt := 0; ; attempt++ {
+		n, err := rr.file.ReadAt(p, rr.pos)
+		rr.pos += int64(n)
+		if err == nil || errors.Is(err, io.EOF) || n > 0 || attempt >= *readRetries {
+			// A short read with an error still returns its bytes; the
+			// error resurfaces on the next call if it persists
+			if n > 0 && !errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return n, err
+		}
+
+		slog.Warn("Retrying failed read", "file", rr.name, "offset", rr.pos, "attempt", attempt+1, "error", err)
+		select {
+		case <-rr.ctx.Done():
+			return 0, rr.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
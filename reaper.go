@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	idleTimeout  = flag.Duration("idle-timeout", 120*time.Second, "how long a keep-alive connection may sit idle between requests")
+	reapInterval = flag.Duration("reap-interval", 30*time.Second, "how often connections idle for longer than -idle-timeout are closed by force (0 = rely on net/http alone)")
+)
+
+var (
+	connTracker = &idleTracker{conns: make(map[net.Conn]trackedConn)}
+	// reapedConns counts connections the reaper closed, for /metrics
+	reapedConns atomic.Int64
+)
+
+// idleTracker records when each connection last changed state. Only
+// connections waiting for a request are candidates for reaping; one in
+// StateActive is serving a request, such as a download, and is never
+// touched.
+type idleTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]trackedConn
+}
+
+type trackedConn struct {
+	state http.ConnState
+	since time.Time
+}
+
+// connState is installed as http.Server.ConnState.
+func (t *idleTracker) connState(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case http.StateNew, http.StateIdle, http.StateActive:
+		t.conns[c] = trackedConn{state: state, since: time.Now()}
+	default:
+		delete(t.conns, c)
+	}
+}
+
+// reap closes connections that have waited for a request longer than
+// -idle-timeout and returns how many it closed.
+func (t *idleTracker) reap(now time.Time) int {
+	var stale []net.Conn
+	t.mu.Lock()
+	for c, tc := range t.conns {
+		if tc.state != http.StateActive && now.Sub(tc.since) > *idleTimeout {
+			stale = append(stale, c)
+			delete(t.conns, c)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, c := range stale {
+		c.Close()
+	}
+	return len(stale)
+}
+
+// startReaper closes lingering idle connections every -reap-interval, for
+// the cases where the server's own idle timeout does not fire.
+func startReaper(server *http.Server) {
+	if *reapInterval <= 0 {
+		return
+	}
+	server.ConnState = connTracker.connState
+
+	go func() {
+		ticker := time.NewTicker(*reapInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if n := connTracker.reap(now); n > 0 {
+				reapedConns.Add(int64(n))
+				slog.Info("Reaped idle connections", "count", n, "idle_timeout", *idleTimeout)
+			}
+		}
+	}()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReapIdleConnections(t *testing.T) {
+	setFlag(t, "idle-timeout", "1m")
+	tracker := &idleTracker{conns: make(map[net.Conn]trackedConn)}
+
+	tests := []struct {
+		name   string
+		state  http.ConnState
+		idle   time.Duration
+		reaped bool
+	}{
+		{"idle past timeout", http.StateIdle, 2 * time.Minute, true},
+		{"new past timeout", http.StateNew, 2 * time.Minute, true},
+		{"idle within timeout", http.StateIdle, 30 * time.Second, false},
+		{"active download", http.StateActive, time.Hour, false},
+	}
+	conns := make([]net.Conn, len(tests))
+	now := time.Now()
+	for i, tt := range tests {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close(); server.Close() })
+		conns[i] = client
+		tracker.conns[server] = trackedConn{state: tt.state, since: now.Add(-tt.idle)}
+	}
+	// Closed connections are forgotten, not reaped
+	closed, other := net.Pipe()
+	t.Cleanup(func() { closed.Close(); other.Close() })
+	tracker.connState(closed, http.StateNew)
+	tracker.connState(closed, http.StateClosed)
+
+	want := 0
+	for _, tt := range tests {
+		if tt.reaped {
+			want++
+		}
+	}
+	if got := tracker.reap(now); got != want {
+		t.Errorf("reaped %d connections, want %d", got, want)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conns[i].SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			_, err := conns[i].Read(make([]byte, 1))
+			if reaped := err != nil && !isTimeout(err); reaped != tt.reaped {
+				t.Errorf("reaped = %v, want %v (read error %v)", reaped, tt.reaped, err)
+			}
+		})
+	}
+	if len(tracker.conns) != len(tests)-want {
+		t.Errorf("still tracking %d connections, want %d", len(tracker.conns), len(tests)-want)
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
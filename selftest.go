@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+var selftestMinFree = flag.Uint64("selftest-min-free", 1<<30, "free bytes below which GET /admin/selftest fails the disk space check")
+
+const (
+	// selftestCheckTimeout bounds each check so a hung file system cannot
+	// stall the report.
+	selftestCheckTimeout = 2 * time.Second
+	// selftestQueueRecheck is how long a full queue is given to move
+	// before the check fails.
+	selftestQueueRecheck = 250 * time.Millisecond
+)
+
+// errSkipped marks a check that does not apply to this configuration.
+var errSkipped = errors.New("skipped")
+
+type selftestCheck struct {
+	name string
+	run  func() (string, error)
+}
+
+type selftestResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"` // pass, fail or skip
+	Detail     string  `json:"detail,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+type selftestReport struct {
+	Status     string           `json:"status"`
+	Checks     []selftestResult `json:"checks"`
+	DurationMS float64          `json:"duration_ms"`
+}
+
+var selftestChecks = []selftestCheck{
+	{"download_dir_readable", checkDownloadDirReadable},
+	{"temp_file", checkTempFile},
+	{"queue", checkQueueMoving},
+	{"free_space", checkFreeSpace},
+}
+
+func checkDownloadDirReadable() (string, error) {
+	dir, err := os.Open(downloadDir)
+	if err != nil {
+		return "", err
+	}
+	defer dir.Close()
+	// One entry proves the directory can be listed without walking it
+	if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+		return "", err
+	}
+	return "", nil
+}
+
+// checkTempFile creates and removes a hidden file, which listings skip.
+func checkTempFile() (string, error) {
+	if *readOnly {
+		return "", errSkipped
+	}
+	tmp, err := os.CreateTemp(downloadDir, ".selftest-*")
+	if err != nil {
+		return "", err
+	}
+	name := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return "", os.Remove(name)
+}
+
+func checkQueueMoving() (string, error) {
+	if queueLength() < queueSize {
+		return fmt.Sprintf("%d of %d queued", queueLength(), queueSize), nil
+	}
+	time.Sleep(selftestQueueRecheck)
+	if n := queueLength(); n >= queueSize {
+		return "", fmt.Errorf("queue stayed full (%d) for %s", n, selftestQueueRecheck)
+	}
+	return "queue was full but is draining", nil
+}
+
+func checkFreeSpace() (string, error) {
+	free, ok, err := freeSpace(downloadDir)
+	if !ok {
+		return "", errSkipped
+	}
+	if err != nil {
+		return "", err
+	}
+	detail := fmt.Sprintf("%d bytes free, minimum %d", free, *selftestMinFree)
+	if free < *selftestMinFree {
+		return "", errors.New(detail)
+	}
+	return detail, nil
+}
+
+// runSelftestCheck runs one check, giving up on it after
+// selftestCheckTimeout.
+func runSelftestCheck(ctx context.Context, check selftestCheck) selftestResult {
+	start := time.Now()
+	type outcome struct {
+		detail string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		detail, err := check.run()
+		done <- outcome{detail, err}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, selftestCheckTimeout)
+	defer cancel()
+
+	result := selftestResult{Name: check.name, Status: "pass"}
+	select {
+	case o := <-done:
+		result.Detail = o.detail
+		switch {
+		case errors.Is(o.err, errSkipped):
+			result.Status = "skip"
+		case o.err != nil:
+			result.Status, result.Detail = "fail", o.err.Error()
+		}
+	case <-ctx.Done():
+		result.Status, result.Detail = "fail", "timed out"
+	}
+	result.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	return result
+}
+
+// adminSelftestHandler handles GET /admin/selftest. It answers 503 when any
+// check fails so deploy scripts can use the status code alone.
+func adminSelftestHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	report := selftestReport{Status: "pass"}
+	for _, check := range selftestChecks {
+		result := runSelftestCheck(r.Context(), check)
+		if result.Status == "fail" {
+			report.Status = "fail"
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	report.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "pass" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -2,12 +2,23 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,88 +29,255 @@ const (
 )
 
 type Request struct {
-	w    http.ResponseWriter
-	r    *http.Request
-	done chan bool
+	w       http.ResponseWriter
+	r       *http.Request
+	handler http.HandlerFunc
+	// done receives false when the handler aborted the response with
+	// http.ErrAbortHandler.
+	done       chan bool
+	enqueuedAt time.Time
+	// state moves from requestQueued to either requestRunning (a worker
+	// picked it up) or requestAbandoned (the waiter gave up first).
+	state *atomic.Int32
 }
 
+const (
+	requestQueued int32 = iota
+	requestRunning
+	requestAbandoned
+)
+
 var (
 	requestQueue chan Request
+
+	// priorityQueue holds requests of high-priority tiers. Workers drain it
+	// before requestQueue.
+	priorityQueue chan Request
+
+	// serverStart is captured in main and reported by /health.
+	serverStart time.Time
+
+	pressureDelay     = flag.Duration("pressure-delay", 10*time.Millisecond, "delay before a worker starts a request while the queue is under pressure")
+	pressureThreshold = flag.Int("pressure-threshold", 100, "queued requests at which -pressure-delay kicks in (0 = never delay)")
+
+	maxQueueWait    = flag.Duration("max-queue-wait", 0, "respond 503 when no worker starts a queued request within this time (0 = wait for the download timeout)")
+	downloadTimeout = flag.Duration("download-timeout", 20*time.Minute, "overall deadline for queued requests such as downloads, from queueing to the last byte, and the basis of the server's write timeout; answered with 503 if no worker started it by then (0 = no limit)")
+
+	queueRetries       = flag.Int("queue-retries", 3, "times a request retries to enter a full queue before getting 503 (0 = reject at once)")
+	queueRetryInterval = flag.Duration("queue-retry-interval", 50*time.Millisecond, "wait before the first retry to enter a full queue; doubles on each further retry")
+
+	// Since Go 1.25 the runtime already derives GOMAXPROCS from the
+	// container's CPU quota, so this is only needed to override it.
+	maxProcs = flag.Int("maxprocs", 0, "set GOMAXPROCS (0 = runtime default, which respects container CPU limits)")
+
+	precompressed = flag.Bool("precompressed", false, "serve <file>.gz sidecars to clients that accept gzip (ranged requests always get the uncompressed file)")
 )
 
 func init() {
 	requestQueue = make(chan Request, queueSize)
+	priorityQueue = make(chan Request, queueSize)
 
 	// Start request processor
 	go processRequests()
 }
 
+// queueLength is the number of requests waiting for a worker.
+func queueLength() int {
+	return len(requestQueue) + len(priorityQueue)
+}
+
+// nextRequest waits for a queued request, preferring high-priority tiers.
+func nextRequest() Request {
+	select {
+	case req := <-priorityQueue:
+		return req
+	default:
+	}
+	select {
+	case req := <-priorityQueue:
+		return req
+	case req := <-requestQueue:
+		return req
+	}
+}
+
 func processRequests() {
-	for req := range requestQueue {
+	for {
+		req := nextRequest()
+		workers.acquire()
+
 		// Process request in a separate goroutine
 		go func(r Request) {
 			defer func() {
-				if rec := recover(); rec != nil {
-					log.Printf("Panic recovered in download handler: %v", rec)
+				completed := true
+				if rec := recover(); rec == http.ErrAbortHandler {
+					// Passed on to the request's own goroutine, where
+					// net/http breaks the connection
+					completed = false
+				} else if rec != nil {
+					slog.Error("Panic recovered in download handler", "panic", rec)
 				}
-				r.done <- true
+				workers.release()
+				r.done <- completed
 			}()
 
-			// Add a small delay to prevent overwhelming
-			time.Sleep(10 * time.Millisecond)
+			// The client already got an answer or went away while queued
+			if !r.state.CompareAndSwap(requestQueued, requestRunning) {
+				return
+			}
+			queueWaits.record(time.Since(r.enqueuedAt).Seconds(), 0)
 
-			downloadHandler(r.w, r.r)
-			r.done <- true
+			// Back off a little while the queue is under pressure
+			if d := backpressureDelay(); d > 0 {
+				time.Sleep(d)
+			}
+
+			r.handler(r.w, r.r)
 		}(req)
 	}
 }
 
+// backpressureDelay is how long a worker waits before starting a request.
+// Below -pressure-threshold queued requests there is no delay at all.
+func backpressureDelay() time.Duration {
+	if *pressureThreshold <= 0 || queueLength() < *pressureThreshold {
+		return 0
+	}
+	return *pressureDelay
+}
+
+var errInvalidPath = errors.New("invalid file path")
+
+// resolveDownloadPath maps a client supplied file name to an absolute path
+// inside downloadDir, rejecting anything that escapes it.
+func resolveDownloadPath(fileName string) (string, error) {
+	absDownloadDir, err := filepath.Abs(downloadDir)
+	if err != nil {
+		return "", err
+	}
+
+	absFilePath, err := filepath.Abs(filepath.Join(downloadDir, filepath.Clean(fileName)))
+	if err != nil {
+		return "", err
+	}
+
+	// Compare whole path elements: a plain prefix check would let
+	// "../files2/x" into a sibling such as files2
+	rel, err := filepath.Rel(absDownloadDir, absFilePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errInvalidPath
+	}
+	return absFilePath, nil
+}
+
 func downloadHandler(w http.ResponseWriter, r *http.Request) {
-	// Add nil checks
-	if w == nil || r == nil {
-		log.Printf("Nil request or response writer")
+	fileName, absFilePath, ok := checkDownload(w, r)
+	if !ok {
 		return
 	}
 
-	// Set headers first before any potential writes
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Cache-Control", "no-cache")
+	if followRequested(r) {
+		followFile(w, r, fileName, absFilePath)
+		return
+	}
+	if r.URL.Query().Has("lines") {
+		serveLines(w, r, fileName, absFilePath)
+		return
+	}
 
-	startTime := time.Now()
-	log.Printf("Starting download request for %s", r.URL.RawQuery)
+	serveFile(w, r, fileName, absFilePath)
+}
 
-	fileName := r.URL.Query().Get("file")
-	if fileName == "" {
-		http.Error(w, "File name is required", http.StatusBadRequest)
+// compressedDownloadHandler handles /download-compressed, which always sends
+// the whole file gzip encoded. Range headers are ignored there; clients that
+// resume or seek use /download, which is byte-exact.
+func compressedDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	fileName, absFilePath, ok := checkDownload(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Has("offset") {
+		writeValidationError(w, invalidParam("offset", "is not supported for compressed downloads; use /download"))
 		return
 	}
 
-	filePath := filepath.Join(downloadDir, filepath.Clean(fileName))
+	streamFile(w, r, fileName, absFilePath, true)
+}
 
-	// Security check to prevent directory traversal
-	absDownloadDir, err := filepath.Abs(downloadDir)
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+// checkDownload applies the checks shared by the download endpoints and
+// resolves ?file=. It answers the request itself when it reports false.
+func checkDownload(w http.ResponseWriter, r *http.Request) (name, absPath string, ok bool) {
+	// Add nil checks
+	if w == nil || r == nil {
+		slog.Error("Nil request or response writer")
+		return "", "", false
+	}
+
+	slog.Debug("Starting download request", "path", r.URL.Path, "file", r.URL.Query().Get("file"), "query", logQuery(r), "client", clientCN(r), "tier", requestTier(r).name)
+
+	if !refererAllowed(r) {
+		slog.Info("Rejected hotlinked download", "file", r.URL.Query().Get("file"), "referer", r.Header.Get("Referer"))
+		http.Error(w, "Hotlinking is not allowed", http.StatusForbidden)
+		return "", "", false
+	}
+
+	// Name rules, directory traversal and extension policy
+	name = r.URL.Query().Get("file")
+	absPath, verr := validateFileParam("file", name)
+	if verr != nil {
+		writeValidationError(w, verr)
+		return "", "", false
+	}
+
+	if !chaosBeforeServe(w, r) {
+		return "", "", false
 	}
+	return name, absPath, true
+}
 
-	absFilePath, err := filepath.Abs(filePath)
+// indexHandler handles GET / with index.html from the download directory,
+// or the built-in one, shown inline.
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	filePath, err := resolveDownloadPath("index.html")
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	query := r.URL.Query()
+	query.Set("inline", "true")
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = query.Encode()
+	serveFile(w, r, "index.html", filePath)
+}
+
+// serveFile streams the file at filePath, which must already be resolved and
+// checked by the caller. name is used for the Content-Disposition header and
+// for logging.
+func serveFile(w http.ResponseWriter, r *http.Request, name, filePath string) {
+	streamFile(w, r, name, filePath, false)
+}
 
-	if len(absFilePath) < len(absDownloadDir) || absFilePath[:len(absDownloadDir)] != absDownloadDir {
-		http.Error(w, "Invalid file path", http.StatusBadRequest)
+// streamFile implements serveFile. With alwaysGzip the body is gzip encoded
+// whatever the file type and Accept-Encoding, and ranges are not honoured.
+func streamFile(w http.ResponseWriter, r *http.Request, name, filePath string, alwaysGzip bool) {
+	// Set headers first before any potential writes
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Cache-Control", cacheControlFor(name))
+	applyProxyHints(w)
+
+	startTime := time.Now()
+	fileName := name
+
+	if !storageAvailable(w) {
 		return
 	}
 
-	file, err := os.Open(filePath)
+	file, err := openServed(name, filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			http.NotFound(w, r)
+			downloadNotFound(w, r)
 		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeStorageError(w, name, err)
 		}
 		return
 	}
@@ -111,134 +289,831 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 
 	stat, err := file.Stat()
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeStorageError(w, name, err)
 		return
 	}
+	if stat.IsDir() {
+		writeValidationError(w, errIsDirectory("file"))
+		return
+	}
+	setFileHeaders(w.Header(), r, filePath, stat)
+	noteFileIdentity(w.Header(), fileName, filePath, stat)
+	// A .gz sidecar may replace stat below; replacement is checked for
+	// the file itself
+	opened := stat
+
+	span := spanFromContext(r.Context())
+	span.set("file.name", name)
+	span.set("file.size", stat.Size())
+
+	meta, release, ok := admitDownload(w, r, fileName, filePath, file, stat)
+	if !ok {
+		return
+	}
+	defer release()
 
 	// Set headers for large file download (must be set before any Write)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(fileName)))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
-	w.Header().Set("Accept-Ranges", "bytes")
+	disposition := dispositionFor(r, fileName)
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, fileName))
+	w.Header().Set("Content-Type", contentTypeFor(disposition, fileName, file))
+
+	// Ranges always refer to the uncompressed bytes. A Range request is
+	// served from the original file even when a .gz sidecar exists, and a
+	// gzip response advertises "Accept-Ranges: none" so download managers
+	// never try to resume into the encoded body with identity offsets.
+	rangeHeader := r.Header.Get("Range")
+	if alwaysGzip {
+		rangeHeader = ""
+	}
+
+	// ?offset=N is a Range substitute for clients that cannot send headers
+	offset, hasOffset, err := parseOffset(r.URL.Query().Get("offset"))
+	if err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	compressing := false
+	if hasOffset && rangeHeader != "" {
+		http.Error(w, "Use either offset or a Range header, not both", http.StatusBadRequest)
+		return
+	}
+
+	if alwaysGzip {
+		// A .gz sidecar saves the work and has a known length
+		if gz, gzStat, ok := openPrecompressed(filePath); ok {
+			file.Close()
+			file, stat = gz, gzStat
+		} else {
+			compressing = true
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+	} else if *precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if rangeHeader == "" && !hasOffset && acceptsEncoding(r, "gzip") {
+			if gz, gzStat, ok := openPrecompressed(filePath); ok {
+				file.Close()
+				file, stat = gz, gzStat
+				w.Header().Set("Content-Encoding", "gzip")
+			}
+		}
+	}
+
+	// On-the-fly compression comes last: a Range or offset refers to the
+	// identity bytes and a chunked gzip body cannot carry Content-Range, so
+	// ranged requests fall back to the uncompressed file.
+	if !alwaysGzip && *compressOnTheFly && w.Header().Get("Content-Encoding") == "" && compressible(fileName) {
+		if !*precompressed {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		advertiseDictionary(w)
+		// A client holding the shared dictionary gets dcz, others gzip
+		encoding := ""
+		if dictionaryAvailable(r) {
+			encoding = "dcz"
+		} else if acceptsEncoding(r, "gzip") {
+			encoding = "gzip"
+		}
+		if encoding != "" {
+			switch {
+			case rangeHeader != "" || hasOffset:
+				slog.Info("Skipping compression for ranged request", "file", fileName)
+			case fastClient(clientIP(r)):
+				slog.Debug("Skipping compression for fast client", "file", fileName)
+			default:
+				compressing = true
+				w.Header().Set("Content-Encoding", encoding)
+			}
+		}
+	}
+
+	etag := fileETag(stat, w.Header().Get("Content-Encoding") != "")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
+	if notModified(r, etag, stat.ModTime()) {
+		applyExtraHeaders(w)
+		meta.applyHeaders(w.Header())
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// A Range guarded by If-Range only applies while the client still has
+	// the current representation; otherwise the full file is sent.
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, etag, stat.ModTime()) {
+		rangeHeader = ""
+	}
+
+	start, length := int64(0), stat.Size()
+	status := http.StatusOK
+	if w.Header().Get("Content-Encoding") == "" {
+		w.Header().Set("Accept-Ranges", "bytes")
+		setParallelHints(w.Header(), stat.Size(), perFileLimitFor(meta))
+
+		rangeStart, rangeLength, ok, err := parseRange(rangeHeader, stat.Size())
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", stat.Size()))
+			http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if ok {
+			start, length = rangeStart, rangeLength
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, stat.Size()))
+		}
+
+		if hasOffset {
+			if offset > stat.Size() {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", stat.Size()))
+				http.Error(w, "Offset beyond end of file", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			start, length = offset, stat.Size()-offset
+		}
+	} else {
+		w.Header().Set("Accept-Ranges", "none")
+	}
+	// The digest describes the whole uncompressed file, also for ranges
+	if *sendDigest && w.Header().Get("Content-Encoding") == "" {
+		if digest, ok := fileDigest(filePath, file, stat); ok {
+			w.Header().Set("Digest", digest)
+		}
+	}
+	// Everything but on-the-fly compression knows its exact length up
+	// front. For a .gz sidecar this is the compressed size, since stat was
+	// swapped above, so clients can show progress on the encoded body.
+	if !compressing {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+	}
+	applyExtraHeaders(w)
+	meta.applyHeaders(w.Header())
+
+	body := io.LimitReader(&retryReader{ctx: r.Context(), file: file, pos: start, name: fileName}, length)
+
+	var trailers []string
+	var checksum hash.Hash
+	if *checksumTrailer {
+		checksum = sha256.New()
+		trailers = append(trailers, checksumTrailerName)
+	}
+	if *bytesServedTrailer {
+		trailers = append(trailers, bytesServedTrailerName)
+	}
+	progressID := downloadID(r)
+	w.Header().Set(downloadIDHeader, progressID)
+	declareTrailers(w, r, trailers...)
+	w.WriteHeader(status)
+
+	dl := downloads.start(r, fileName)
+	outcome := downloadAborted
+	defer func() { downloads.finish(dl, outcome) }()
+	if len(trailers) > 0 {
+		// Without Content-Length a chunked body that just ends looks
+		// complete, so a transfer cut short has to break the connection
+		// for the client to notice
+		defer func() {
+			if outcome != downloadCompleted {
+				panic(http.ErrAbortHandler)
+			}
+		}()
+	}
+	defer checkReplacedDuring(fileName, filePath, opened)
+
+	// Progress is tracked against the whole file, so a resumed or segmented
+	// download reports how much of the file the client has overall
+	segment := joinProgress(progressID, fileName, stat.Size(), start)
+	defer leaveProgress(progressID, segment)
 
 	// Check if client disconnected using context
 	ctx := r.Context()
 
-	// Use smaller buffer for better memory management
-	buffer := make([]byte, 32*1024) // 32KB buffer
+	// Buffer size and flush cadence depend on the body size; medium and
+	// large bodies size the buffer from -buffer-budget and the current load
+	strategy := strategyFor(length)
+	buffer := make([]byte, strategy.bufferSize)
+	var unflushed int64
 
-	// Stream the file in chunks
-	for {
+	// Drop clients that trickle data to hold a worker slot
+	throughput := newThroughputMonitor()
+
+	// Pace writes through the global egress limit when one is configured
+	out := io.Writer(w)
+	if *chunkWriteTimeout > 0 {
+		out = newDeadlineWriter(w, *chunkWriteTimeout)
+	}
+	if egress.limited() {
+		egress.join()
+		defer egress.leave()
+		out = &throttledWriter{ctx: ctx, w: out, bucket: egress}
+	}
+
+	// The client's tier may cap this download on top of the global limit
+	tier := requestTier(r)
+	if tier.rate > 0 {
+		bucket := &tokenBucket{last: time.Now()}
+		bucket.setRate(tier.rate)
+		bucket.join()
+		out = &throttledWriter{ctx: ctx, w: out, bucket: bucket}
+	}
+
+	// When compressing, the trailers and rate checks describe the file's
+	// identity bytes rather than the encoded body; only the client's speed
+	// estimate is taken from what goes on the wire
+	out = &sentWriter{w: out, sent: &dl.sent}
+	var encoder io.WriteCloser
+	if compressing {
+		var release func()
+		encoder, release = newEncoder(w.Header().Get("Content-Encoding"), out)
+		defer release()
+		out = encoder
+	}
+
+	flushChunks := flushEachChunk()
+	abortAt := chaosAbortPoint(length)
+
+	// Stream the file in chunks. The loop stops once length bytes are out,
+	// so an empty file or range never reads at all.
+	var served int64
+stream:
+	for served < length {
 		select {
 		case <-ctx.Done():
-			// Client disconnected, stop processing
-			log.Printf("Client disconnected during download of %s", fileName)
+			// Client disconnected, stop processing. A deadline is ours, not
+			// the client's, and stays counted as an abort.
+			if ctx.Err() == context.Canceled {
+				outcome = downloadCancelled
+			}
+			slog.Info("Client disconnected during download", "file", fileName, "bytes_sent", served, "bytes_expected", length)
 			return
 		default:
 			// Check if file is still valid
 			if file == nil {
-				log.Printf("File handle is nil during download of %s", fileName)
+				slog.Error("File handle is nil during download", "file", fileName)
 				return
 			}
 
-			n, err := file.Read(buffer)
+			n, err := body.Read(buffer)
 			if n > 0 {
 				// Check if the connection is still alive before writing
 				if w == nil {
-					log.Printf("Response writer is nil during download of %s", fileName)
+					slog.Error("Response writer is nil during download", "file", fileName)
 					return
 				}
 
-				if _, writeErr := w.Write(buffer[:n]); writeErr != nil {
-					log.Printf("Write error during download of %s: %v", fileName, writeErr)
+				written, writeErr := out.Write(buffer[:n])
+				served += int64(written)
+				dl.bytes.Add(int64(written))
+				segment.bytes.Add(int64(written))
+				if writeErr != nil {
+					// A broken connection is the client going away; a write
+					// deadline means the server cut the transfer off
+					if !errors.Is(writeErr, os.ErrDeadlineExceeded) && !errors.Is(writeErr, context.DeadlineExceeded) {
+						outcome = downloadCancelled
+					}
+					if *chunkWriteTimeout > 0 && errors.Is(writeErr, os.ErrDeadlineExceeded) {
+						slog.Warn("Write stalled, aborting download", "file", fileName, "bytes_sent", served, "bytes_expected", length, "timeout", *chunkWriteTimeout)
+						return
+					}
+					slog.Info("Write error during download", "file", fileName, "bytes_sent", served, "bytes_expected", length, "error", writeErr)
+					return
+				}
+				if checksum != nil {
+					checksum.Write(buffer[:n])
+				}
+				if abortAt >= 0 && served >= abortAt {
+					slog.Info("Chaos: aborting download", "file", fileName, "bytes_sent", served, "bytes_expected", length)
+					return
+				}
+				if tooSlow, rate := throughput.add(written); tooSlow {
+					slog.Warn("Aborting download, client too slow", "file", fileName, "rate", int64(rate), "min_rate", *minClientRate)
 					return
 				}
 
 				// Flush the response writer to ensure data is sent immediately
-				if flusher, ok := w.(http.Flusher); ok {
+				unflushed += int64(written)
+				if flusher, ok := w.(http.Flusher); ok && flushChunks && unflushed >= strategy.flushEvery {
 					flusher.Flush()
+					unflushed = 0
 				}
 			}
 
 			if err == io.EOF {
-				break
+				break stream
 			}
 
+			if err != nil && ctx.Err() != nil {
+				// Gave up retrying because the client left; handled above
+				continue
+			}
 			if err != nil {
-				log.Printf("Read error during download of %s: %v", fileName, err)
+				storageBreaker.failure(err)
+				outcome = downloadFailed
+				slog.Error("Read error during download", "file", fileName, "error", err)
 				return
 			}
 		}
 	}
 
-	log.Printf("Completed download request for %s in %v", fileName, time.Since(startTime))
+	// The body was sized from the stat taken before streaming. A file that
+	// grew is cut at that size by the LimitReader; one that shrank cannot
+	// fill the promised length, so the response is left short, which makes
+	// the server close the connection instead of completing the body.
+	if current, err := file.Stat(); err == nil && current.Size() != stat.Size() {
+		slog.Warn("File changed size during download", "file", fileName, "stat_size", stat.Size(), "current_size", current.Size(), "bytes_sent", served, "bytes_expected", length)
+	}
+	if served < length {
+		return
+	}
+
+	if encoder != nil {
+		if err := encoder.Close(); err != nil {
+			slog.Warn("Write error during download", "file", fileName, "error", err)
+			return
+		}
+	}
+
+	if checksum != nil {
+		w.Header().Set(checksumTrailerName, hex.EncodeToString(checksum.Sum(nil)))
+	}
+	if *bytesServedTrailer {
+		w.Header().Set(bytesServedTrailerName, strconv.FormatInt(served, 10))
+	}
+
+	storageBreaker.success()
+	outcome = downloadCompleted
+	slog.Debug("Completed download request", "file", fileName, "strategy", strategy.name, "tier", tier.name, "duration", time.Since(startTime))
 }
 
-func queuedDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	done := make(chan bool)
+// storageAvailable answers 503 and reports false while the storage breaker
+// is open.
+func storageAvailable(w http.ResponseWriter) bool {
+	if storageBreaker.allow() {
+		return true
+	}
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "Storage unavailable, please try again later", http.StatusServiceUnavailable)
+	return false
+}
+
+// admitDownload runs the checks an opened file goes through before any of
+// it is sent: -verify-on-serve and the per-file concurrency cap, which a
+// .meta sidecar may override. It returns the sidecar and a release func the
+// caller must call once done, or answers the request itself and reports
+// false.
+func admitDownload(w http.ResponseWriter, r *http.Request, name, filePath string, file io.ReaderAt, stat os.FileInfo) (fileMeta, func(), bool) {
+	if *verifyOnServe {
+		if err := verifyChecksum(filePath, file, stat); err != nil {
+			slog.Error("Refusing to serve file", "file", name, "error", err)
+			if err != errChecksumMismatch && err != errInvalidSidecar {
+				storageBreaker.failure(err)
+			}
+			http.Error(w, "File failed integrity check", http.StatusInternalServerError)
+			return fileMeta{}, nil, false
+		}
+	}
+
+	meta := loadFileMeta(filePath)
+	limit := perFileLimitFor(meta)
+	// Waiting holds on to the worker slot, so only a few requests may wait
+	// for a hot file; the rest are turned away like in reject mode
+	maxWaiters := 0
+	if *perFileMode == "queue" {
+		maxWaiters = *perFileQueue
+	}
+	release, err := downloadsPerFile.acquire(r.Context(), filePath, limit, maxWaiters)
+	if err != nil {
+		if err == errFileBusy {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Too many concurrent downloads of this file", http.StatusTooManyRequests)
+		}
+		return fileMeta{}, nil, false
+	}
+	return meta, release, true
+}
+
+// perFileLimitFor is the concurrent download cap of a file with sidecar
+// meta, 0 for none.
+func perFileLimitFor(meta fileMeta) int {
+	if meta.MaxConcurrent > 0 {
+		return meta.MaxConcurrent
+	}
+	return currentConfig().perFileLimit
+}
+
+// queued runs handler on the download worker pool, rejecting the request
+// when the queue is full.
+func queued(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if span := startSpan(r, "download"); span != nil {
+			rec := &responseRecorder{ResponseWriter: w}
+			defer span.finish(rec)
+			w, r = rec, r.WithContext(contextWithSpan(r.Context(), span))
+		}
+		enqueue(w, r, handler)
+	}
+}
+
+func enqueue(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	if draining.Load() {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Server is draining, please try another instance", http.StatusServiceUnavailable)
+		return
+	}
+
+	tier := tierFor(r)
+	countTierRequest(tier)
+
+	// Downloads get at most -download-timeout, a followed file -follow-max;
+	// the handler sees the same deadline
+	ctx, cancel := contextWithTier(r.Context(), tier), context.CancelFunc(func() {})
+	if timeout := queuedTimeout(r); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	// Buffered so the worker never blocks if we have already given up waiting
+	done := make(chan bool, 1)
 	req := Request{
-		w:    w,
-		r:    r,
-		done: done,
+		w:          w,
+		r:          r.WithContext(ctx),
+		handler:    handler,
+		done:       done,
+		enqueuedAt: time.Now(),
+		state:      new(atomic.Int32),
 	}
 
-	// Try to queue the request
-	select {
-	case requestQueue <- req:
-		// Wait for completion or timeout (increased to 20 minutes for large files)
-		ctx, cancel := context.WithTimeout(r.Context(), 20*time.Minute)
-		defer cancel()
+	// Try to queue the request, holding on briefly while the queue is full
+	if !offerRequest(ctx, w, queueFor(tier), req) {
+		if ctx.Err() != nil {
+			slog.Info("Request cancelled while waiting for queue space", "query", logQuery(r), "tier", tier.name)
+			return
+		}
+		// Queue is full; tolerant clients can be served asynchronously
+		if spillRequest(w, r) {
+			return
+		}
+		slog.Info("Queue full, rejecting request", "query", logQuery(r), "tier", tier.name)
+		http.Error(w, "Server busy, please try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Give up on requests no worker starts within -max-queue-wait
+	var queueDeadline <-chan time.Time
+	if *maxQueueWait > 0 {
+		timer := time.NewTimer(*maxQueueWait)
+		defer timer.Stop()
+		queueDeadline = timer.C
+	}
 
+	for {
 		select {
-		case <-done:
-			// Request completed successfully
+		case completed := <-done:
+			if !completed {
+				panic(http.ErrAbortHandler)
+			}
+			return
+		case <-queueDeadline:
+			queueDeadline = nil
+			if req.state.CompareAndSwap(requestQueued, requestAbandoned) {
+				slog.Warn("Request not started in time", "query", logQuery(r), "tier", tier.name, "queued_for", time.Since(req.enqueuedAt))
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, "Server busy, please try again later", http.StatusServiceUnavailable)
+				return
+			}
 		case <-ctx.Done():
+			if !req.state.CompareAndSwap(requestQueued, requestAbandoned) {
+				// The handler is running and stops on the same context.
+				// Returning earlier would let the server reuse w while
+				// the handler is still writing to it.
+				if !<-done {
+					panic(http.ErrAbortHandler)
+				}
+				return
+			}
 			if ctx.Err() == context.DeadlineExceeded {
-				log.Printf("Request timeout for %s", r.URL.RawQuery)
-				http.Error(w, "Request timeout", http.StatusRequestTimeout)
+				slog.Warn("Request timeout", "query", logQuery(r), "timeout", *downloadTimeout)
+				http.Error(w, "Request timed out after "+downloadTimeout.String(), http.StatusServiceUnavailable)
 			} else {
-				log.Printf("Request cancelled for %s", r.URL.RawQuery)
+				slog.Info("Request cancelled", "query", logQuery(r))
 			}
+			return
 		}
-	default:
-		// Queue is full
-		http.Error(w, "Server busy, please try again later", http.StatusServiceUnavailable)
 	}
 }
 
+// offerRequest puts req on queue. A full queue is retried up to
+// -queue-retries times with doubling backoff to absorb short bursts; the
+// X-Queue-Retry response header tells the client how many retries it took.
+func offerRequest(ctx context.Context, w http.ResponseWriter, queue chan Request, req Request) bool {
+	backoff := *queueRetryInterval
+	for attempt := 0; ; attempt++ {
+		select {
+		case queue <- req:
+			return true
+		default:
+		}
+		if attempt >= *queueRetries {
+			return false
+		}
+
+		w.Header().Set("X-Queue-Retry", strconv.Itoa(attempt+1))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+type healthResponse struct {
+	Status         string `json:"status"`
+	Workers        int    `json:"workers"`
+	ActiveWorkers  int    `json:"active_workers"`
+	AllowedWorkers int    `json:"allowed_workers"`
+	QueueSize      int    `json:"queue_size"`
+	StartedAt      string `json:"started_at"`
+	UptimeSeconds  int64  `json:"uptime_seconds"`
+	Draining       bool   `json:"draining"`
+	Maintenance    bool   `json:"maintenance"`
+	Goroutines     int    `json:"goroutines"`
+	GoMaxProcs     int    `json:"gomaxprocs"`
+	Connections    int64  `json:"connections"`
+	MaxConnections int    `json:"max_connections"`
+	ReadOnly       bool   `json:"read_only"`
+}
+
 func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	if maintenance.Load() != nil {
+		status = "maintenance"
+	}
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"status": "ok", "workers": %d, "queue_size": %d}`, maxWorkers, len(requestQueue))
+	json.NewEncoder(w).Encode(healthResponse{
+		Status:         status,
+		Workers:        maxWorkers,
+		ActiveWorkers:  workers.activeCount(),
+		AllowedWorkers: allowedWorkers(),
+		QueueSize:      queueLength(),
+		StartedAt:      serverStart.Format(time.RFC3339),
+		UptimeSeconds:  int64(time.Since(serverStart).Seconds()),
+		Draining:       draining.Load(),
+		Maintenance:    maintenance.Load() != nil,
+		ReadOnly:       *readOnly,
+		Goroutines:     runtime.NumGoroutine(),
+		GoMaxProcs:     runtime.GOMAXPROCS(0),
+		Connections:    openConns.Load(),
+		MaxConnections: *maxConns,
+	})
 }
 
-func main() {
-	// Create the download directory if it doesn't exist
-	if _, err := os.Stat(downloadDir); os.IsNotExist(err) {
-		if err := os.Mkdir(downloadDir, 0755); err != nil {
-			log.Fatalf("Failed to create download directory: %v", err)
+// readyzHandler reports whether this instance should receive traffic.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reason := ""
+	switch {
+	case maintenance.Load() != nil:
+		reason = "maintenance"
+	case draining.Load():
+		reason = "draining"
+	case !storageBreaker.allow():
+		reason = "storage circuit breaker open"
+	}
+	if reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]any{"ready": false, "reason": reason})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"ready": true})
+}
+
+// queuedTimeout is the overall deadline of a queued request. A ?follow=true
+// download is bounded by -follow-max rather than -download-timeout, with
+// some slack so followFile's own timer ends it first.
+func queuedTimeout(r *http.Request) time.Duration {
+	if followRequested(r) {
+		return *followMax + writeTimeoutSlack
+	}
+	return *downloadTimeout
+}
+
+// writeTimeoutSlack lets a download's own -download-timeout end it, with a
+// log line, before the server's write deadline drops the connection.
+const writeTimeoutSlack = 10 * time.Second
+
+// serverWriteTimeout derives http.Server.WriteTimeout from
+// -download-timeout, so the flag really is the limit for the last byte.
+// Responses that may legitimately stream longer, such as ?follow=true,
+// move the deadline forward on every write.
+func serverWriteTimeout() time.Duration {
+	if *downloadTimeout <= 0 {
+		return 0
+	}
+	return *downloadTimeout + writeTimeoutSlack
+}
+
+// checkDownloadDir creates the download directory if it doesn't exist and
+// otherwise makes sure it is a directory the server can list, so a
+// misconfigured path fails at startup rather than on every download.
+func checkDownloadDir() error {
+	stat, err := os.Stat(downloadDir)
+	if os.IsNotExist(err) {
+		if err := os.Mkdir(downloadDir, dirPerm); err != nil {
+			return err
 		}
 		fmt.Printf("Created directory '%s'\n", downloadDir)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return errors.New(downloadDir + " is not a directory")
+	}
+
+	dir, err := os.Open(downloadDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func main() {
+	serverStart = time.Now()
+	flag.Parse()
+	setupLogging()
+
+	if *maxProcs > 0 {
+		runtime.GOMAXPROCS(*maxProcs)
+	}
+	slog.Info("Using GOMAXPROCS", "gomaxprocs", runtime.GOMAXPROCS(0))
+
+	if !validDisposition(*defaultDisposition) {
+		fatal("Invalid -disposition: want attachment or inline", "value", *defaultDisposition)
+	}
+
+	switch *uploadCollision {
+	case "reject", "overwrite", "rename":
+	default:
+		fatal("Invalid -upload-collision: want reject, overwrite or rename", "value", *uploadCollision)
+	}
+
+	policy, err := cachePolicy(*cacheControl)
+	if err != nil {
+		fatal("Invalid -cache-control", "error", err)
+	}
+	defaultCacheControl = policy
+
+	if *perFileMode != "queue" && *perFileMode != "reject" {
+		fatal("Invalid -per-file-mode: want queue or reject", "value", *perFileMode)
 	}
+	if *perFileQueue < 0 {
+		fatal("Invalid -per-file-queue: must not be negative", "value", *perFileQueue)
+	}
+
+	if err := checkSpillover(); err != nil {
+		fatal("Invalid spillover configuration", "error", err)
+	}
+
+	if err := checkNotFoundFile(); err != nil {
+		fatal("Invalid -not-found-file", "error", err)
+	}
+
+	if err := checkStrategy(); err != nil {
+		fatal("Invalid size thresholds", "error", err)
+	}
+
+	if err := checkBasePath(); err != nil {
+		fatal("Invalid -base-path", "error", err)
+	}
+
+	if err := checkMaintenance(); err != nil {
+		fatal("Invalid -maintenance-page", "error", err)
+	}
+
+	if err := loadCompressionDict(); err != nil {
+		fatal("Invalid -compression-dict", "error", err)
+	}
+
+	if err := checkChaos(); err != nil {
+		fatal("Invalid chaos configuration", "error", err)
+	}
+
+	if !validProxyMode(*proxyMode) {
+		fatal("Invalid -proxy-mode: want direct, stream or buffered", "value", *proxyMode)
+	}
+
+	startWarmup()
+
+	cfg, err := loadRuntimeConfig()
+	if err != nil {
+		fatal("Failed to load configuration", "error", err)
+	}
+	applyRuntimeConfig(cfg)
+	if cfg.totalRate > 0 {
+		slog.Info("Limiting total download rate", "bytes_per_second", cfg.totalRate)
+	}
+	watchSIGHUP()
+
+	if err := checkDownloadDir(); err != nil {
+		fatal("Unusable download directory", "path", downloadDir, "error", err)
+	}
+
+	prewarm()
+	startWatcher()
+	startSpillover()
+	startTracing()
+	startStatsd()
 
 	// Configure server with extended timeouts for large file downloads
 	server := &http.Server{
 		Addr:         ":8080",
 		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 600 * time.Second, // Increased to 10 minutes for large files
-		IdleTimeout:  120 * time.Second,
+		WriteTimeout: serverWriteTimeout(),
+		IdleTimeout:  *idleTimeout,
 		// Add connection keep-alive settings
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
 
-	// Register handlers
-	http.HandleFunc("/download", queuedDownloadHandler)
-	http.HandleFunc("/health", healthHandler)
+	startReaper(server)
+
+	useTLS, err := configureTLS(server)
+	if err != nil {
+		fatal("Invalid TLS configuration", "error", err)
+	}
+
+	// Register handlers. Each route lists its middleware outermost first;
+	// GET /admin/routes shows the resulting chains.
+	handle("/download", downloadHandler, workerQueue)
+	handle("/download-compressed", compressedDownloadHandler, workerQueue)
+	handle("GET /progress", progressHandler, requestLimit)
+	if dictContent != nil {
+		handle("GET /compression-dictionary", dictionaryHandler)
+	}
+	switch {
+	case *dashboardEnabled && *dashboardAuth:
+		handle("GET /{$}", dashboardHandler, dashboardAccess, requestLimit)
+	case *dashboardEnabled:
+		handle("GET /{$}", dashboardHandler, requestLimit)
+	default:
+		handle("GET /{$}", indexHandler)
+	}
+	handle("/health", healthHandler, metadataLimit)
+	handle("GET /capabilities", capabilitiesHandler, requestLimit)
+	handle("/readyz", readyzHandler, metadataLimit)
+	handle("GET /metrics", metricsHandler, requestLimit)
+	handle("GET /files", filesHandler, metadataLimit)
+	handle("DELETE /files", deleteFileHandler, adminAuth, requestLimit)
+	handle("POST /locks", locksHandler, requestLimit)
+	handle("DELETE /locks", unlockHandler, requestLimit)
+	handle("GET /tree", treeHandler, metadataLimit)
+	handle("GET /du", duHandler, metadataLimit)
+	handle("POST /batch-info", batchInfoHandler, metadataLimit)
+	handle("POST /jobs", createJobHandler, adminAuth, requestLimit)
+	handle("GET /jobs", jobStatusHandler, requestLimit)
+	handle("GET /jobs/download", jobDownloadHandler, workerQueue)
+	handle("GET /zip", zipHandler, workerQueue)
+	handle("GET /tar", tarHandler, workerQueue)
+	handle("GET /concat", concatHandler, workerQueue)
+	handle("POST /admin/reload", adminReloadHandler, adminAuth, requestLimit)
+	handle("POST /admin/drain", adminDrainHandler, adminAuth, requestLimit)
+	handle("POST /admin/undrain", adminUndrainHandler, adminAuth, requestLimit)
+	handle("POST /admin/maintenance", adminMaintenanceOnHandler, adminAuth, requestLimit)
+	handle("DELETE /admin/maintenance", adminMaintenanceOffHandler, adminAuth, requestLimit)
+	handle("GET /admin/downloads", adminDownloadsHandler, adminAuth, requestLimit)
+	handle("GET /admin/logs", adminLogsHandler, adminAuth)
+	handle("GET /admin/routes", adminRoutesHandler, adminAuth, requestLimit)
+	handle("GET /admin/selftest", adminSelftestHandler, adminAuth, requestLimit)
+	if *uploadEnabled {
+		handle("POST /upload", uploadHandler, idempotent)
+		handle("PUT /upload", rawUploadHandler, idempotent)
+	}
 
 	fmt.Printf("Starting server on port 8080...\n")
-	fmt.Printf("Use http://localhost:8080/download?file=<filename> to download a file.\n")
-	fmt.Printf("Use http://localhost:8080/health to check server status.\n")
+	fmt.Printf("Use http://localhost:8080%s/download?file=<filename> to download a file.\n", *basePath)
+	fmt.Printf("Use http://localhost:8080%s/download-compressed?file=<filename> for a gzip encoded download without range support.\n", *basePath)
+	fmt.Printf("Use http://localhost:8080%s/health to check server status.\n", *basePath)
+	fmt.Printf("Use http://localhost:8080%s/capabilities to see which optional features are enabled.\n", *basePath)
+	fmt.Printf("Use POST http://localhost:8080%s/jobs?file=<filename> (admin token) to stage a snapshot for download.\n", *basePath)
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Error starting server: %s\n", err)
+	server.Handler = requireAllowedHost(withBasePath(requireClientCN(rejectDuringMaintenance(rejectWrites(http.DefaultServeMux)))))
+	serveGRPC(server)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		fatal("Error starting server", "error", err)
+	}
+	limited := newLimitListener(listener, *maxConns)
+	shutdownDone := shutdownOnSignal(server)
+	if useTLS {
+		err = server.ServeTLS(limited, *tlsCert, *tlsKey)
+	} else {
+		err = server.Serve(limited)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		fatal("Error starting server", "error", err)
 	}
+	<-shutdownDone
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "on SIGINT or SIGTERM, how long in-flight requests may finish before their connections are closed by force")
+
+// shutdownOnSignal shuts server down on SIGINT or SIGTERM: new downloads are
+// refused at once, in-flight requests get -shutdown-timeout to finish, and
+// whatever is left then is cut off so a stuck download cannot hold up a
+// deploy. The returned channel is closed once shutdown is complete.
+func shutdownOnSignal(server *http.Server) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		defer close(done)
+		sig := <-signals
+		signal.Stop(signals)
+
+		draining.Store(true)
+		slog.Info("Shutting down", "signal", sig.String(), "in_flight", downloads.activeCount(), "timeout", *shutdownTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err == nil {
+			slog.Info("Shutdown complete")
+			return
+		}
+
+		active := downloads.activeCount()
+		server.Close()
+		slog.Warn("Shutdown timed out, closed remaining connections", "downloads_terminated", active, "error", err)
+	}()
+	return done
+}
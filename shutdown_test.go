@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A download that outlives -shutdown-timeout is cut off, so shutdown ends
+// shortly after the timeout; one that finishes in time is not.
+func TestShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		complete bool
+	}{
+		{"finishes in time", 20 * time.Millisecond, true},
+		{"stuck download", time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "shutdown-timeout", "200ms")
+			t.Cleanup(func() { draining.Store(false) })
+
+			started := make(chan struct{})
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("first chunk\n"))
+				w.(http.Flusher).Flush()
+				close(started)
+				select {
+				case <-time.After(tt.duration):
+					w.Write([]byte("last chunk\n"))
+				case <-r.Context().Done():
+				}
+			}))
+			ts.Start()
+			t.Cleanup(ts.Close)
+			done := shutdownOnSignal(ts.Config)
+
+			resp, err := ts.Client().Get(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			<-started
+
+			self, _ := os.FindProcess(os.Getpid())
+			if err := self.Signal(syscall.SIGTERM); err != nil {
+				t.Skipf("cannot signal the test process: %v", err)
+			}
+			start := time.Now()
+			body, err := io.ReadAll(resp.Body)
+			if complete := err == nil; complete != tt.complete {
+				t.Errorf("download complete = %v (%q, %v), want %v", complete, body, err, tt.complete)
+			}
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("shutdown did not finish")
+			}
+			if taken := time.Since(start); taken > 2*time.Second {
+				t.Errorf("shutdown took %v with a 200ms timeout", taken)
+			}
+			if !draining.Load() {
+				t.Error("server not draining during shutdown")
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// fileMeta is the optional per-file JSON sidecar stored next to a file as
+// "<name>.meta".
+type fileMeta struct {
+	// MaxConcurrent caps simultaneous downloads of this file. Zero falls
+	// back to the -per-file-limit flag.
+	MaxConcurrent int `json:"max_concurrent"`
+
+	// CacheControl takes the same presets as -cache-control.
+	CacheControl string `json:"cache_control"`
+	ContentType  string `json:"content_type"`
+	// Headers are extra response headers, set after the -header ones.
+	Headers map[string]string `json:"headers"`
+
+	headers []staticHeader
+}
+
+// validate checks the header fields and expands them for applyHeaders.
+func (m *fileMeta) validate() error {
+	if m.MaxConcurrent < 0 {
+		return fmt.Errorf("max_concurrent must not be negative")
+	}
+	if m.CacheControl != "" {
+		value, err := cachePolicy(m.CacheControl)
+		if err != nil {
+			return err
+		}
+		m.CacheControl = value
+	}
+	if m.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(m.ContentType); err != nil {
+			return fmt.Errorf("content_type %q: %w", m.ContentType, err)
+		}
+	}
+
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		sh, err := parseStaticHeader(name + ": " + m.Headers[name])
+		if err != nil {
+			return err
+		}
+		m.headers = append(m.headers, sh)
+	}
+	return nil
+}
+
+// applyHeaders sets the sidecar's response headers, replacing the defaults
+// and the server-wide -header values.
+func (m fileMeta) applyHeaders(h http.Header) {
+	if m.CacheControl != "" {
+		h.Set("Cache-Control", m.CacheControl)
+	}
+	if m.ContentType != "" {
+		h.Set("Content-Type", m.ContentType)
+	}
+	for _, sh := range m.headers {
+		h.Set(sh.name, sh.value)
+	}
+}
+
+// cachedMeta is a parsed sidecar together with the version it came from.
+type cachedMeta struct {
+	size    int64
+	modTime time.Time
+	meta    fileMeta
+}
+
+var (
+	fileMetaMu    sync.Mutex
+	fileMetaCache = make(map[string]cachedMeta)
+)
+
+// loadFileMeta reads the sidecar for filePath. A missing sidecar yields the
+// zero value; an unreadable or invalid one is logged and ignored. Parsed
+// sidecars are reused until their size or modification time changes.
+func loadFileMeta(filePath string) fileMeta {
+	metaPath := filePath + ".meta"
+	stat, err := os.Stat(metaPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read sidecar", "file", filePath, "error", err)
+		}
+		fileMetaMu.Lock()
+		delete(fileMetaCache, metaPath)
+		fileMetaMu.Unlock()
+		return fileMeta{}
+	}
+
+	fileMetaMu.Lock()
+	cached, ok := fileMetaCache[metaPath]
+	fileMetaMu.Unlock()
+	if ok && cached.size == stat.Size() && cached.modTime.Equal(stat.ModTime()) {
+		return cached.meta
+	}
+
+	var meta fileMeta
+	data, err := os.ReadFile(metaPath)
+	if err == nil {
+		if err = json.Unmarshal(data, &meta); err == nil {
+			err = meta.validate()
+		}
+	}
+	if err != nil {
+		// Cached as well, so a broken sidecar is only logged once
+		slog.Warn("Ignoring invalid sidecar", "file", filePath, "error", err)
+		meta = fileMeta{}
+	}
+
+	fileMetaMu.Lock()
+	fileMetaCache[metaPath] = cachedMeta{size: stat.Size(), modTime: stat.ModTime(), meta: meta}
+	fileMetaMu.Unlock()
+	return meta
+}
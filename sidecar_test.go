@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSidecarHeaders(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "plain.bin", "data")
+	defaults := serve(downloadHandler, newRequest("GET", "/download?file=plain.bin")).Header()
+
+	tests := []struct {
+		name    string
+		sidecar string // empty for none
+		want    map[string]string
+	}{
+		{"no sidecar", "", map[string]string{
+			"Cache-Control": defaults.Get("Cache-Control"),
+			"Content-Type":  "application/octet-stream",
+		}},
+		{"cache preset", `{"cache_control": "max-age=600"}`, map[string]string{
+			"Cache-Control": "public, max-age=600",
+		}},
+		{"immutable", `{"cache_control": "immutable"}`, map[string]string{
+			"Cache-Control": immutableCacheControl,
+		}},
+		{"content type and headers", `{"content_type": "text/csv", "headers": {"X-Report": "q3", "Cache-Control": "private"}}`, map[string]string{
+			"Content-Type":  "text/csv",
+			"X-Report":      "q3",
+			"Cache-Control": "private",
+		}},
+		{"invalid json", `{"cache_control": `, map[string]string{
+			"Cache-Control": defaults.Get("Cache-Control"),
+		}},
+		{"invalid cache policy", `{"cache_control": "max-age=-1"}`, map[string]string{
+			"Cache-Control": defaults.Get("Cache-Control"),
+		}},
+		{"invalid content type", `{"content_type": "text/"}`, map[string]string{
+			"Content-Type": "application/octet-stream",
+		}},
+		{"invalid header", `{"headers": {"Bad Name": "x"}}`, map[string]string{
+			"Bad Name": "",
+		}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := fmt.Sprintf("file%d.bin", i)
+			writeTestFile(t, name, "data")
+			if tt.sidecar != "" {
+				writeTestFile(t, name+".meta", tt.sidecar)
+			}
+			rec := serve(downloadHandler, newRequest("GET", "/download?file="+name))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			for header, want := range tt.want {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("reloaded when changed", func(t *testing.T) {
+		path := writeTestFile(t, "edited.bin.meta", `{"cache_control": "no-store"}`)
+		writeTestFile(t, "edited.bin", "data")
+		get := func() string {
+			return serve(downloadHandler, newRequest("GET", "/download?file=edited.bin")).Header().Get("Cache-Control")
+		}
+		if got := get(); got != "no-store" {
+			t.Fatalf("Cache-Control = %q, want no-store", got)
+		}
+		writeTestFile(t, "edited.bin.meta", `{"cache_control": "max-age=60"}`)
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(path, later, later)
+		if got := get(); got != "public, max-age=60" {
+			t.Errorf("Cache-Control after edit = %q, want the new policy", got)
+		}
+		os.Remove(path)
+		if got := get(); got != defaults.Get("Cache-Control") {
+			t.Errorf("Cache-Control after removal = %q, want the default", got)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// resizingWriter changes the served file's size on the first write, after
+// the handler has sized the response from its stat.
+type resizingWriter struct {
+	*httptest.ResponseRecorder
+	resize func()
+}
+
+func (r *resizingWriter) Write(p []byte) (int, error) {
+	if r.resize != nil {
+		r.resize()
+		r.resize = nil
+	}
+	return r.ResponseRecorder.Write(p)
+}
+
+// A file that changes size mid-download keeps the advertised length
+// honest: growth is cut off and shrinkage leaves the body short.
+func TestSizeChangeDuringDownload(t *testing.T) {
+	const size = 4 << 20
+	tests := []struct {
+		name    string
+		newSize int64
+		maxBody int
+	}{
+		{"truncated", size / 2, size / 2},
+		{"grown", size * 2, size},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDir(t)
+			path := writeTestFile(t, "a.bin", strings.Repeat("x", size))
+			w := &resizingWriter{ResponseRecorder: httptest.NewRecorder(), resize: func() {
+				if err := os.Truncate(path, tt.newSize); err != nil {
+					t.Error(err)
+				}
+			}}
+			downloadHandler(w, newRequest("GET", "/download?file=a.bin"))
+
+			if got := w.Header().Get("Content-Length"); got != strconv.Itoa(size) {
+				t.Errorf("Content-Length = %s, want the stat size %d", got, size)
+			}
+			if got := w.Body.Len(); got > tt.maxBody {
+				t.Errorf("body is %d bytes, want at most %d", got, tt.maxBody)
+			}
+			if tt.newSize > size && w.Body.Len() != size {
+				t.Errorf("grown file sent %d bytes, want %d", w.Body.Len(), size)
+			}
+		})
+	}
+}
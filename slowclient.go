@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var (
+	minClientRate   = flag.Int64("min-rate", 0, "abort downloads slower than this many bytes/s over -min-rate-window (0 = disabled)")
+	minClientWindow = flag.Duration("min-rate-window", 30*time.Second, "window over which -min-rate is measured")
+)
+
+// throughputMonitor measures a transfer's rate over consecutive windows. The
+// rate is taken at the server, so it includes any -total-rate pacing; keep
+// -min-rate below the share each stream gets under that limit.
+type throughputMonitor struct {
+	minRate     float64
+	window      time.Duration
+	windowStart time.Time
+	windowBytes int64
+}
+
+// newThroughputMonitor returns nil when the slow-client policy is off.
+func newThroughputMonitor() *throughputMonitor {
+	if *minClientRate <= 0 || *minClientWindow <= 0 {
+		return nil
+	}
+	return &throughputMonitor{
+		minRate:     float64(*minClientRate),
+		window:      *minClientWindow,
+		windowStart: time.Now(),
+	}
+}
+
+// add records n written bytes. At the end of each window it reports whether
+// the client fell below the minimum rate, along with the measured rate.
+func (m *throughputMonitor) add(n int) (tooSlow bool, rate float64) {
+	if m == nil {
+		return false, 0
+	}
+
+	m.windowBytes += int64(n)
+	elapsed := time.Since(m.windowStart)
+	if elapsed < m.window {
+		return false, 0
+	}
+
+	rate = float64(m.windowBytes) / elapsed.Seconds()
+	m.windowStart = time.Now()
+	m.windowBytes = 0
+	return rate < m.minRate, rate
+}
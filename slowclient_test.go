@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThroughputMonitor(t *testing.T) {
+	tests := []struct {
+		name    string
+		minRate string
+		bytes   int
+		elapsed time.Duration
+		tooSlow bool
+	}{
+		{"disabled", "0", 1, time.Minute, false},
+		{"window not over", "1000", 1, time.Millisecond, false},
+		{"fast enough", "1000", 100000, time.Second, false},
+		{"too slow", "1000", 100, time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "min-rate", tt.minRate)
+			setFlag(t, "min-rate-window", "500ms")
+			m := newThroughputMonitor()
+			if m != nil {
+				m.windowStart = time.Now().Add(-tt.elapsed)
+			}
+			if tooSlow, _ := m.add(tt.bytes); tooSlow != tt.tooSlow {
+				t.Errorf("tooSlow = %v, want %v", tooSlow, tt.tooSlow)
+			}
+		})
+	}
+}
+
+// slowWriter stands in for a client reading at a trickle.
+type slowWriter struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (s *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.ResponseRecorder.Write(p)
+}
+
+func TestSlowClientAborted(t *testing.T) {
+	newTestDir(t)
+	content := strings.Repeat("x", 1<<20)
+	writeTestFile(t, "a.bin", content)
+	setFlag(t, "min-rate-window", "20ms")
+
+	tests := []struct {
+		name     string
+		minRate  string
+		complete bool
+	}{
+		{"policy off", "0", true},
+		{"below minimum", "100000000", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "min-rate", tt.minRate)
+			w := &slowWriter{ResponseRecorder: httptest.NewRecorder(), delay: 2 * time.Millisecond}
+			downloadHandler(w, newRequest("GET", "/download?file=a.bin"))
+			if complete := w.Body.Len() == len(content); complete != tt.complete {
+				t.Errorf("sent %d of %d bytes, complete = %v, want %v", w.Body.Len(), len(content), complete, tt.complete)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	spilloverDir           = flag.String("spillover-dir", "", "when the queue is full, store /download requests that carry ?callback= here and stage them later, notifying the callback (empty = reject with 503)")
+	spilloverCallbackHosts = flag.String("spillover-callback-hosts", "", "comma separated hosts spillover callbacks may point to; *.example.com matches subdomains (required with -spillover-dir)")
+)
+
+const (
+	spilloverPoll     = time.Second
+	spilloverBatch    = 10 // parked requests resumed per poll
+	webhookAttempts   = 3
+	webhookRetryDelay = 5 * time.Second
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// spilledRequest is a download request parked on disk while the queue is
+// full.
+type spilledRequest struct {
+	ID       string    `json:"id"`
+	File     string    `json:"file"`
+	Callback string    `json:"callback"`
+	Created  time.Time `json:"created"`
+}
+
+// webhookPayload is POSTed to the callback once the spilled request has been
+// staged, or could not be.
+type webhookPayload struct {
+	ID          string     `json:"id"`
+	File        string     `json:"file"`
+	State       string     `json:"state"`
+	Error       string     `json:"error,omitempty"`
+	Job         *jobStatus `json:"job,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+func checkSpillover() error {
+	if *spilloverDir == "" {
+		return nil
+	}
+	if len(parseHostList(*spilloverCallbackHosts)) == 0 {
+		return errors.New("-spillover-dir requires -spillover-callback-hosts")
+	}
+	return os.MkdirAll(*spilloverDir, dirPerm)
+}
+
+// spillRequest parks r on disk instead of rejecting it. It reports whether
+// it answered the request; clients without ?callback= are not tolerant of
+// async delivery and get the usual 503.
+func spillRequest(w http.ResponseWriter, r *http.Request) bool {
+	query := r.URL.Query()
+	if *spilloverDir == "" || query.Get("callback") == "" || r.URL.Path != "/download" {
+		return false
+	}
+
+	fileName := query.Get("file")
+	if _, verr := validateFileParam("file", fileName); verr != nil {
+		writeValidationError(w, verr)
+		return true
+	}
+	callback, err := url.Parse(query.Get("callback"))
+	if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+		writeValidationError(w, invalidParam("callback", "must be an absolute http or https URL"))
+		return true
+	}
+	if !hostAllowed(callback.Hostname(), parseHostList(*spilloverCallbackHosts)) {
+		writeValidationError(w, invalidParam("callback", "host is not allowed"))
+		return true
+	}
+
+	req := spilledRequest{ID: newJobID(), File: fileName, Callback: callback.String(), Created: time.Now()}
+	if err := writeSpilled(req); err != nil {
+		slog.Error("Failed to spill request", "file", fileName, "error", err)
+		return false
+	}
+	slog.Info("Queue full, spilled request", "id", req.ID, "file", fileName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{"id": req.ID, "state": "spilled"})
+	return true
+}
+
+// writeSpilled stores req atomically. Names start with the creation time so
+// a directory listing is oldest first.
+func writeSpilled(req spilledRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(*spilloverDir, ".spill-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d-%s.json", req.Created.UnixNano(), req.ID)
+	return os.Rename(tmp.Name(), filepath.Join(*spilloverDir, name))
+}
+
+// startSpillover resumes parked requests whenever the queue has room again.
+// Requests left over from a previous run are picked up as well.
+func startSpillover() {
+	if *spilloverDir == "" {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(spilloverPoll)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for i := 0; i < spilloverBatch && hasSpareCapacity(); i++ {
+				if !resumeOldestSpilled() {
+					break
+				}
+			}
+		}
+	}()
+}
+
+func hasSpareCapacity() bool {
+	return queueLength() < queueSize/2 && workers.activeCount() < allowedWorkers()
+}
+
+// resumeOldestSpilled stages the oldest parked request. It reports whether
+// there was one.
+func resumeOldestSpilled() bool {
+	entries, err := os.ReadDir(*spilloverDir)
+	if err != nil {
+		slog.Error("Failed to read spillover directory", "error", err)
+		return false
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(*spilloverDir, entry.Name())
+		data, err := os.ReadFile(path)
+		// Claim the record before acting on it so it is processed once
+		if removeErr := os.Remove(path); removeErr != nil {
+			continue
+		}
+		var req spilledRequest
+		if err == nil {
+			err = json.Unmarshal(data, &req)
+		}
+		if err != nil {
+			slog.Error("Dropping unreadable spilled request", "record", entry.Name(), "error", err)
+			continue
+		}
+
+		resumeSpilled(req)
+		return true
+	}
+	return false
+}
+
+func resumeSpilled(req spilledRequest) {
+	fail := func(err error) {
+		slog.Warn("Spilled request failed", "id", req.ID, "file", req.File, "error", err)
+		go notifyCallback(req, webhookPayload{ID: req.ID, File: req.File, State: jobFailed, Error: err.Error()})
+	}
+
+	filePath, verr := validateFileParam("file", req.File)
+	if verr != nil {
+		fail(verr)
+		return
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = errors.New("file not found")
+		}
+		fail(err)
+		return
+	}
+	if !stat.Mode().IsRegular() {
+		fail(errNotAFile)
+		return
+	}
+
+	slog.Info("Resuming spilled request", "id", req.ID, "file", req.File)
+	startJob(req.File, filePath, stat.Size(), func(j *job) {
+		status := j.status()
+		payload := webhookPayload{ID: req.ID, File: req.File, State: status.State, Error: status.Error, Job: &status}
+		if status.State == jobReady {
+			payload.DownloadURL = urlPath("/jobs/download?id=" + j.ID)
+		}
+		notifyCallback(req, payload)
+	})
+}
+
+// notifyCallback POSTs payload to the request's callback, retrying a few
+// times on errors and non-2xx answers.
+func notifyCallback(req spilledRequest, payload webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := webhookClient.Post(req.Callback, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				slog.Info("Notified spillover callback", "id", req.ID, "state", payload.State)
+				return
+			}
+			err = fmt.Errorf("callback answered %s", resp.Status)
+		}
+		if attempt == webhookAttempts {
+			slog.Error("Giving up on spillover callback", "id", req.ID, "error", err)
+			return
+		}
+		slog.Warn("Spillover callback failed, retrying", "id", req.ID, "attempt", attempt, "error", err)
+		time.Sleep(webhookRetryDelay)
+	}
+}
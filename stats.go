@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"sync"
+	"time"
+)
+
+var statsWindow = flag.Duration("stats-window", 5*time.Minute, "time window for the latency percentiles reported by /metrics")
+
+// statsCapacity bounds each ring buffer. Busy servers see the most recent
+// samples of the window rather than all of them.
+const statsCapacity = 4096
+
+var statQuantiles = []float64{0.5, 0.95, 0.99}
+
+// sample is one observation in a rolling window.
+type sample struct {
+	at    time.Time
+	value float64
+	bytes int64
+}
+
+// rollingWindow keeps the latest samples in a fixed ring. Recording is a
+// short critical section; the sorting for percentiles happens on a copy.
+type rollingWindow struct {
+	mu      sync.Mutex
+	samples [statsCapacity]sample
+	next    int
+	filled  bool
+
+	// totalCount and totalSum cover every sample since start, as the
+	// _count and _sum of a Prometheus summary must only ever grow
+	totalCount int64
+	totalSum   float64
+}
+
+var (
+	downloadDurations rollingWindow // seconds per completed download
+	queueWaits        rollingWindow // seconds spent waiting for a worker
+)
+
+func (rw *rollingWindow) record(value float64, bytes int64) {
+	rw.mu.Lock()
+	rw.samples[rw.next] = sample{at: time.Now(), value: value, bytes: bytes}
+	rw.next++
+	if rw.next == statsCapacity {
+		rw.next = 0
+		rw.filled = true
+	}
+	rw.totalCount++
+	rw.totalSum += value
+	rw.mu.Unlock()
+}
+
+// windowSummary describes the samples inside the window, along with the
+// running totals since start.
+type windowSummary struct {
+	count     int
+	sum       float64
+	bytes     int64
+	quantiles []float64 // matches statQuantiles
+
+	totalCount int64
+	totalSum   float64
+}
+
+func (rw *rollingWindow) summary(window time.Duration) windowSummary {
+	cutoff := time.Now().Add(-window)
+
+	rw.mu.Lock()
+	n := rw.next
+	if rw.filled {
+		n = statsCapacity
+	}
+	values := make([]float64, 0, n)
+	s := windowSummary{totalCount: rw.totalCount, totalSum: rw.totalSum}
+	for _, smp := range rw.samples[:n] {
+		if smp.at.After(cutoff) {
+			values = append(values, smp.value)
+			s.sum += smp.value
+			s.bytes += smp.bytes
+		}
+	}
+	rw.mu.Unlock()
+
+	s.count = len(values)
+	s.quantiles = make([]float64, len(statQuantiles))
+	if s.count == 0 {
+		return s
+	}
+	slices.Sort(values)
+	for i, q := range statQuantiles {
+		s.quantiles[i] = values[int(q*float64(s.count-1))]
+	}
+	return s
+}
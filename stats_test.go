@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Quantiles only see the window, while _sum and _count keep growing.
+func TestSummaryTotals(t *testing.T) {
+	var rw rollingWindow
+	rw.record(10, 0)
+	rw.record(20, 0)
+	time.Sleep(30 * time.Millisecond)
+	rw.record(1, 0)
+
+	s := rw.summary(20 * time.Millisecond)
+	var out bytes.Buffer
+	writeSummary(&out, "test_seconds", "Test.", s)
+
+	lines := strings.Split(out.String(), "\n")
+	for _, want := range []string{
+		`test_seconds{quantile="0.5"} 1`,
+		`test_seconds{quantile="0.99"} 1`,
+		"test_seconds_sum 31",
+		"test_seconds_count 3",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+	if s.count != 1 {
+		t.Errorf("window count = %d, want 1", s.count)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	statsdAddr     = flag.String("statsd-addr", "", "StatsD agent to send download metrics to over UDP, e.g. 127.0.0.1:8125 (empty = disabled)")
+	statsdPrefix   = flag.String("statsd-prefix", "atc4.", "prefix for every StatsD metric name")
+	statsdInterval = flag.Duration("statsd-interval", 10*time.Second, "how often collected StatsD metrics are flushed")
+)
+
+const (
+	// statsdPacketSize keeps packets below a typical path MTU.
+	statsdPacketSize = 1432
+	// statsdMaxTimings bounds the durations kept per flush; beyond it they
+	// are sampled away so a burst cannot grow memory.
+	statsdMaxTimings = 1000
+)
+
+// statsdBatch collects metrics between flushes. Recording only touches
+// memory, so a slow or missing agent never reaches the download path.
+type statsdBatch struct {
+	mu      sync.Mutex
+	counts  map[string]int64
+	timings []int64 // milliseconds
+	seen    int     // timings offered, including dropped ones
+}
+
+var statsd *statsdBatch
+
+func startStatsd() {
+	if *statsdAddr == "" {
+		return
+	}
+	statsd = &statsdBatch{counts: make(map[string]int64)}
+	go statsd.run()
+	slog.Info("Sending StatsD metrics", "addr", *statsdAddr, "interval", *statsdInterval)
+}
+
+// recordDownload adds a finished download.
+func (b *statsdBatch) recordDownload(status string, bytes int64, duration time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts["downloads."+status]++
+	b.counts["download_bytes."+status] += bytes
+	b.seen++
+	if len(b.timings) < statsdMaxTimings {
+		b.timings = append(b.timings, duration.Milliseconds())
+	}
+}
+
+// take returns the collected metrics and starts a new batch.
+func (b *statsdBatch) take() (counts map[string]int64, timings []int64, sampleRate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts, timings = b.counts, b.timings
+	sampleRate = 1
+	if b.seen > len(timings) {
+		sampleRate = float64(len(timings)) / float64(b.seen)
+	}
+	b.counts = make(map[string]int64)
+	b.timings = nil
+	b.seen = 0
+	return counts, timings, sampleRate
+}
+
+func (b *statsdBatch) run() {
+	ticker := time.NewTicker(*statsdInterval)
+	defer ticker.Stop()
+
+	var conn net.Conn
+	for range ticker.C {
+		counts, timings, rate := b.take()
+
+		// Dial lazily so an agent that is not up yet, or a name that does
+		// not resolve, is retried on the next flush
+		if conn == nil {
+			c, err := net.Dial("udp", *statsdAddr)
+			if err != nil {
+				slog.Debug("StatsD agent unreachable", "addr", *statsdAddr, "error", err)
+				continue
+			}
+			conn = c
+		}
+
+		var lines []string
+		for name, n := range counts {
+			lines = append(lines, fmt.Sprintf("%s%s:%d|c", *statsdPrefix, name, n))
+		}
+		for _, ms := range timings {
+			if rate < 1 {
+				lines = append(lines, fmt.Sprintf("%sdownload_duration:%d|ms|@%.3f", *statsdPrefix, ms, rate))
+			} else {
+				lines = append(lines, fmt.Sprintf("%sdownload_duration:%d|ms", *statsdPrefix, ms))
+			}
+		}
+		lines = append(lines,
+			fmt.Sprintf("%sactive_downloads:%d|g", *statsdPrefix, downloads.activeCount()),
+			fmt.Sprintf("%squeue_length:%d|g", *statsdPrefix, queueLength()),
+		)
+
+		if err := sendStatsd(conn, lines); err != nil {
+			slog.Debug("Failed to send StatsD metrics", "addr", *statsdAddr, "error", err)
+		}
+	}
+}
+
+// sendStatsd packs lines into as few packets as fit statsdPacketSize.
+func sendStatsd(conn net.Conn, lines []string) error {
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, err := conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"syscall"
+)
+
+// writeStorageError answers a request whose file could not be opened or
+// read, with a status that tells clients and monitoring what kind of
+// failure it was:
+//
+//	not a directory            404, a path component is a regular file
+//	name too long              400, the client sent an oversized component
+//	permission denied          403
+//	too many open files        503 with Retry-After, the condition is transient
+//	I/O error                  502, the storage behind the server failed
+//	anything else              500
+//
+// Only I/O and unknown errors count against the storage breaker; the others
+// say nothing about the health of the storage itself, and the first two are
+// caused by the request alone.
+func writeStorageError(w http.ResponseWriter, name string, err error) {
+	switch {
+	case errors.Is(err, syscall.ENOTDIR):
+		slog.Debug("Path component is not a directory", "file", name, "error", err)
+		http.Error(w, "File not found", http.StatusNotFound)
+	case errors.Is(err, syscall.ENAMETOOLONG):
+		slog.Debug("File name too long", "file", name, "error", err)
+		http.Error(w, "File name too long", http.StatusBadRequest)
+	case errors.Is(err, fs.ErrPermission):
+		slog.Warn("Permission denied reading file", "file", name, "error", err)
+		http.Error(w, "Permission denied", http.StatusForbidden)
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		slog.Error("Out of file descriptors", "file", name, "error", err)
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Server busy, please try again later", http.StatusServiceUnavailable)
+	case errors.Is(err, syscall.EIO):
+		storageBreaker.failure(err)
+		slog.Error("Storage I/O error", "file", name, "error", err)
+		http.Error(w, "Storage I/O error", http.StatusBadGateway)
+	default:
+		storageBreaker.failure(err)
+		slog.Error("Storage error", "file", name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWriteStorageError(t *testing.T) {
+	pathErr := func(errno syscall.Errno) error {
+		return &fs.PathError{Op: "open", Path: "downloads/a.txt", Err: errno}
+	}
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter bool
+		breaker    bool
+	}{
+		{"not a directory", pathErr(syscall.ENOTDIR), http.StatusNotFound, false, false},
+		{"name too long", pathErr(syscall.ENAMETOOLONG), http.StatusBadRequest, false, false},
+		{"permission", pathErr(syscall.EACCES), http.StatusForbidden, false, false},
+		{"process fd limit", pathErr(syscall.EMFILE), http.StatusServiceUnavailable, true, false},
+		{"system fd limit", pathErr(syscall.ENFILE), http.StatusServiceUnavailable, true, false},
+		{"io error", pathErr(syscall.EIO), http.StatusBadGateway, false, true},
+		{"unknown", errors.New("disk on fire"), http.StatusInternalServerError, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storageBreaker.success()
+			t.Cleanup(storageBreaker.success)
+
+			rec := httptest.NewRecorder()
+			writeStorageError(rec, "a.txt", tt.err)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Retry-After") != ""; got != tt.retryAfter {
+				t.Errorf("Retry-After set = %v, want %v", got, tt.retryAfter)
+			}
+			storageBreaker.mu.Lock()
+			counted := storageBreaker.failures > 0
+			storageBreaker.mu.Unlock()
+			if counted != tt.breaker {
+				t.Errorf("counted against the breaker = %v, want %v", counted, tt.breaker)
+			}
+		})
+	}
+}
+
+func TestUnreadableFile(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any file")
+	}
+	newTestDir(t)
+	path := writeTestFile(t, "secret.txt", "hidden")
+	if err := os.Chmod(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(downloadHandler, newRequest("GET", "/download?file=secret.txt"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestClientPathErrorsKeepBreakerClosed(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "hello")
+	storageBreaker.success()
+	t.Cleanup(storageBreaker.success)
+
+	long := strings.Repeat("x", 300)
+	for range *breakerThreshold + 1 {
+		if rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt/x")); rec.Code != http.StatusNotFound {
+			t.Fatalf("file below a file: status = %d, want 404", rec.Code)
+		}
+		if rec := serve(downloadHandler, newRequest("GET", "/download?file="+long)); rec.Code != http.StatusBadRequest {
+			t.Fatalf("long name: status = %d, want 400", rec.Code)
+		}
+	}
+	if !storageBreaker.allow() {
+		t.Error("breaker opened on client path errors")
+	}
+}
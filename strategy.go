@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"math"
+)
+
+var (
+	smallFileSize = flag.Int64("small-file-size", 64<<10, "bodies up to this many bytes are read and written in one piece")
+	largeFileSize = flag.Int64("large-file-size", 64<<20, "bodies of at least this many bytes are flushed every 1 MiB instead of after every chunk")
+)
+
+// largeFlushInterval is how many bytes a large download writes between
+// flushes. Flushing every chunk costs a syscall per 32 KiB, which only
+// matters once there are thousands of chunks.
+const largeFlushInterval = 1 << 20
+
+// maxSmallFileSize bounds -small-file-size, since small bodies are read
+// into a buffer of their full size.
+const maxSmallFileSize = 16 << 20
+
+func checkStrategy() error {
+	switch {
+	case *smallFileSize < 0 || *smallFileSize > maxSmallFileSize:
+		return errors.New("-small-file-size must be between 0 and 16 MiB")
+	case *largeFileSize <= *smallFileSize:
+		return errors.New("-large-file-size must be larger than -small-file-size")
+	}
+	return nil
+}
+
+// serveStrategy is how the download loop moves a body of a given size.
+type serveStrategy struct {
+	name       string
+	bufferSize int
+	// flushEvery is the number of bytes written between flushes; 0
+	// flushes after every chunk.
+	flushEvery int64
+}
+
+// strategyFor picks the strategy for a body of length bytes:
+//
+//	small   length <= -small-file-size: one read into an exactly sized
+//	        buffer and one write; the server flushes when the handler returns
+//	medium  buffered copy with streamBufferSize chunks, flushing each one
+//	        so clients and streaming proxies see steady progress
+//	large   length >= -large-file-size: the same chunks, flushed every
+//	        largeFlushInterval bytes
+//
+// Flushing is skipped altogether in -proxy-mode buffered.
+func strategyFor(length int64) serveStrategy {
+	switch {
+	case length <= *smallFileSize:
+		return serveStrategy{name: "small", bufferSize: int(max(length, 1)), flushEvery: math.MaxInt64}
+	case length >= *largeFileSize:
+		return serveStrategy{name: "large", bufferSize: streamBufferSize(), flushEvery: largeFlushInterval}
+	default:
+		return serveStrategy{name: "medium", bufferSize: streamBufferSize()}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStrategyFor(t *testing.T) {
+	setFlag(t, "small-file-size", "65536")
+	setFlag(t, "large-file-size", "67108864")
+	setFlag(t, "buffer-budget", "0")
+
+	tests := []struct {
+		length     int64
+		name       string
+		bufferSize int
+		flushEvery int64
+	}{
+		{0, "small", 1, math.MaxInt64},
+		{100, "small", 100, math.MaxInt64},
+		{64 << 10, "small", 64 << 10, math.MaxInt64},
+		{64<<10 + 1, "medium", defaultBufferSize, 0},
+		{64<<20 - 1, "medium", defaultBufferSize, 0},
+		{64 << 20, "large", defaultBufferSize, largeFlushInterval},
+	}
+	for _, tt := range tests {
+		got := strategyFor(tt.length)
+		if got.name != tt.name || got.bufferSize != tt.bufferSize || got.flushEvery != tt.flushEvery {
+			t.Errorf("strategyFor(%d) = %+v, want %s with %d byte buffer flushing every %d", tt.length, got, tt.name, tt.bufferSize, tt.flushEvery)
+		}
+	}
+}
+
+func TestCheckStrategy(t *testing.T) {
+	tests := []struct {
+		small, large string
+		ok           bool
+	}{
+		{"65536", "67108864", true},
+		{"0", "1", true},
+		{"-1", "67108864", false},
+		{"33554432", "67108864", false},
+		{"65536", "65536", false},
+	}
+	for _, tt := range tests {
+		setFlag(t, "small-file-size", tt.small)
+		setFlag(t, "large-file-size", tt.large)
+		if err := checkStrategy(); (err == nil) != tt.ok {
+			t.Errorf("small %s, large %s: err = %v, want ok = %v", tt.small, tt.large, err, tt.ok)
+		}
+	}
+}
+
+// BenchmarkStrategies downloads one file from each size class with the
+// default thresholds.
+func BenchmarkStrategies(b *testing.B) {
+	for _, size := range []int{16 << 10, 4 << 20, 64 << 20} {
+		b.Run(fmt.Sprintf("%s/%dKiB", strategyFor(int64(size)).name, size>>10), func(b *testing.B) {
+			newTestDir(b)
+			writeTestFile(b, "a.bin", strings.Repeat("x", size))
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for range b.N {
+				rec := httptest.NewRecorder()
+				downloadHandler(rec, newRequest("GET", "/download?file=a.bin"))
+				if rec.Body.Len() != size {
+					b.Fatalf("sent %d bytes", rec.Body.Len())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestTar(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "photos/a.jpg", "jpeg data")
+	writeTestFile(t, "photos/2024/b.jpg", "more jpeg")
+	writeTestFile(t, "photos/.hidden", "skipped")
+	writeTestFile(t, "photos/.cache/c.jpg", "skipped")
+	want := map[string]string{
+		"photos/2024/b.jpg": "more jpeg",
+		"photos/a.jpg":      "jpeg data",
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		status      int
+		contentType string
+		disposition string
+		gzipped     bool
+	}{
+		{"default gzip", "dir=photos", http.StatusOK, "application/gzip", `attachment; filename="photos.tar.gz"`, true},
+		{"explicit gzip", "dir=photos&compress=gzip", http.StatusOK, "application/gzip", `attachment; filename="photos.tar.gz"`, true},
+		{"plain tar", "dir=photos&compress=none", http.StatusOK, "application/x-tar", `attachment; filename="photos.tar"`, false},
+		{"unknown compression", "dir=photos&compress=zstd", http.StatusBadRequest, "", "", false},
+		{"missing directory", "dir=videos", http.StatusNotFound, "", "", false},
+		{"outside download dir", "dir=../x", http.StatusBadRequest, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tarHandler, newRequest("GET", "/tar?"+tt.query))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.disposition)
+			}
+
+			var archive io.Reader = rec.Body
+			if tt.gzipped {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				archive = zr
+			}
+			got := make(map[string]string)
+			var names []string
+			tr := tar.NewReader(archive)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("reading archive: %v", err)
+				}
+				body, _ := io.ReadAll(tr)
+				got[hdr.Name] = string(body)
+				names = append(names, hdr.Name)
+				if hdr.Uid != 0 || hdr.Uname != "" {
+					t.Errorf("%s carries owner %d/%q", hdr.Name, hdr.Uid, hdr.Uname)
+				}
+			}
+			if len(got) != len(want) {
+				t.Errorf("entries = %v, want %d", names, len(want))
+			}
+			for name, content := range want {
+				if got[name] != content {
+					t.Errorf("%s = %q, want %q", name, got[name], content)
+				}
+			}
+			if !slices.IsSorted(names) {
+				t.Errorf("entries out of walk order: %v", names)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// apiKeyHeader identifies a client for tiering.
+const apiKeyHeader = "X-API-Key"
+
+// defaultTierName is used when the configuration defines no tiers.
+const defaultTierName = "default"
+
+// tierConfig is one entry of the "tiers" object in the -config file.
+type tierConfig struct {
+	// Priority "high" lets the tier's requests overtake every queued
+	// request of other tiers; anything else queues normally.
+	Priority string `json:"priority"`
+	// Rate caps each download of the tier in bytes per second (0 = no cap).
+	Rate      int64    `json:"rate"`
+	APIKeys   []string `json:"api_keys"`
+	ClientCNs []string `json:"client_cns"`
+}
+
+// clientTier is the service level a request gets.
+type clientTier struct {
+	name     string
+	priority bool
+	rate     int64
+}
+
+// tierTable maps clients to tiers. API keys are kept as SHA-256 digests so
+// lookups do not compare secrets byte by byte.
+type tierTable struct {
+	byKey    map[[sha256.Size]byte]*clientTier
+	byCN     map[string]*clientTier
+	fallback *clientTier
+	names    []string
+}
+
+func newTierTable(tiers map[string]tierConfig, defaultTier string) (*tierTable, error) {
+	table := &tierTable{
+		byKey:    make(map[[sha256.Size]byte]*clientTier),
+		byCN:     make(map[string]*clientTier),
+		fallback: &clientTier{name: defaultTierName},
+	}
+	byName := make(map[string]*clientTier)
+	for name, tc := range tiers {
+		if tc.Priority != "" && tc.Priority != "high" && tc.Priority != "normal" {
+			return nil, fmt.Errorf("tier %q: priority must be high or normal", name)
+		}
+		if tc.Rate < 0 {
+			return nil, fmt.Errorf("tier %q: rate must not be negative", name)
+		}
+		tier := &clientTier{name: name, priority: tc.Priority == "high", rate: tc.Rate}
+		byName[name] = tier
+		for _, key := range tc.APIKeys {
+			table.byKey[sha256.Sum256([]byte(key))] = tier
+		}
+		for _, cn := range tc.ClientCNs {
+			table.byCN[cn] = tier
+		}
+		table.names = append(table.names, name)
+	}
+	slices.Sort(table.names)
+
+	if defaultTier != "" {
+		tier, ok := byName[defaultTier]
+		if !ok {
+			return nil, fmt.Errorf("default_tier %q is not defined in tiers", defaultTier)
+		}
+		table.fallback = tier
+	}
+	return table, nil
+}
+
+// describe summarizes the table for configuration diffs.
+func (t *tierTable) describe() string {
+	return fmt.Sprintf("[%s] default %s, %d keys, %d client CNs", strings.Join(t.names, ","), t.fallback.name, len(t.byKey), len(t.byCN))
+}
+
+// tierFor picks the tier of a request: an API key wins over the client
+// certificate; clients matching neither get the default tier.
+func tierFor(r *http.Request) *clientTier {
+	table := currentConfig().tiers
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		if tier, ok := table.byKey[sha256.Sum256([]byte(key))]; ok {
+			return tier
+		}
+	}
+	if tier, ok := table.byCN[clientCN(r)]; ok {
+		return tier
+	}
+	return table.fallback
+}
+
+var (
+	tierRequestsMu sync.Mutex
+	tierRequests   = make(map[string]int64)
+)
+
+func countTierRequest(tier *clientTier) {
+	tierRequestsMu.Lock()
+	tierRequests[tier.name]++
+	tierRequestsMu.Unlock()
+}
+
+func tierRequestCounts() map[string]int64 {
+	tierRequestsMu.Lock()
+	defer tierRequestsMu.Unlock()
+	counts := make(map[string]int64, len(tierRequests))
+	for name, n := range tierRequests {
+		counts[name] = n
+	}
+	return counts
+}
+
+type tierKey struct{}
+
+func contextWithTier(ctx context.Context, tier *clientTier) context.Context {
+	return context.WithValue(ctx, tierKey{}, tier)
+}
+
+// requestTier returns the tier enqueue assigned to r, so a configuration
+// reload cannot move a request between tiers halfway through.
+func requestTier(r *http.Request) *clientTier {
+	if tier, ok := r.Context().Value(tierKey{}).(*clientTier); ok {
+		return tier
+	}
+	return tierFor(r)
+}
+
+// queueFor is the queue a tier's requests wait in.
+func queueFor(tier *clientTier) chan Request {
+	if tier.priority {
+		return priorityQueue
+	}
+	return requestQueue
+}
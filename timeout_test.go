@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerWriteTimeout(t *testing.T) {
+	tests := []struct {
+		downloadTimeout string
+		want            time.Duration
+	}{
+		{"0", 0},
+		{"20m", 20*time.Minute + writeTimeoutSlack},
+		{"2h", 2*time.Hour + writeTimeoutSlack},
+	}
+	for _, tt := range tests {
+		setFlag(t, "download-timeout", tt.downloadTimeout)
+		if got := serverWriteTimeout(); got != tt.want {
+			t.Errorf("-download-timeout=%s: write timeout = %v, want %v", tt.downloadTimeout, got, tt.want)
+		}
+	}
+}
+
+// blockUntilDone stands in for a handler that never finishes on its own.
+func blockUntilDone(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+}
+
+func TestWithTimeout(t *testing.T) {
+	limit := 20 * time.Millisecond
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"fast", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, 200},
+		{"stuck", blockUntilDone, 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := withTimeout(&limit, "-test-timeout")(tt.handler)
+			rec := serve(handler, httptest.NewRequest("GET", "/files", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	setFlag(t, "download-timeout", "50ms")
+
+	var deadline time.Time
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		blockUntilDone(w, r)
+	}
+	start := time.Now()
+	enqueue(httptest.NewRecorder(), httptest.NewRequest("GET", "/download?file=a.txt", nil), handler)
+
+	if deadline.IsZero() {
+		t.Fatal("handler ran without a deadline")
+	}
+	if d := deadline.Sub(start); d < 50*time.Millisecond || d > time.Second {
+		t.Errorf("deadline %v after enqueueing, want about 50ms", d)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("enqueue returned after %v", elapsed)
+	}
+}
+
+// A listing whose directory walk takes longer than -metadata-timeout is
+// answered with 503; a fast one, or any with the limit off, is not.
+func TestSlowListing(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "hello")
+
+	tests := []struct {
+		name    string
+		timeout string
+		delay   time.Duration
+		want    int
+	}{
+		{"fast", "200ms", 0, http.StatusOK},
+		{"slow", "20ms", 200 * time.Millisecond, http.StatusServiceUnavailable},
+		{"slow without limit", "0", 50 * time.Millisecond, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "metadata-timeout", tt.timeout)
+			slowListing := func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+					return
+				}
+				filesHandler(w, r)
+			}
+
+			start := time.Now()
+			rec := serve(metadataLimit.wrap(slowListing), newRequest("GET", "/files"))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusServiceUnavailable && time.Since(start) >= tt.delay {
+				t.Errorf("timed out after %v, not before the %v listing finished", time.Since(start), tt.delay)
+			}
+			if tt.want == http.StatusOK && rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
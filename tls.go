@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	tlsCert          = flag.String("tls-cert", "", "serve HTTPS with this certificate file (requires -tls-key)")
+	tlsKey           = flag.String("tls-key", "", "private key for -tls-cert")
+	clientCA         = flag.String("client-ca", "", "PEM file with the CAs that sign client certificates; enables mutual TLS and rejects clients without a valid certificate")
+	allowedClientCNs = flag.String("allowed-client-cns", "", "comma separated client certificate common names allowed to use the server (requires -client-ca; empty = any verified client)")
+)
+
+// allowedCNs is the parsed -allowed-client-cns; nil means no restriction.
+var allowedCNs map[string]bool
+
+// configureTLS validates the TLS flags and sets up server.TLSConfig. It
+// reports whether the server should serve HTTPS.
+func configureTLS(server *http.Server) (bool, error) {
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return false, errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if *tlsCert == "" {
+		if *clientCA != "" {
+			return false, errors.New("-client-ca requires -tls-cert and -tls-key")
+		}
+		return false, nil
+	}
+
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if *clientCA == "" {
+		if *allowedClientCNs != "" {
+			return false, errors.New("-allowed-client-cns requires -client-ca")
+		}
+		return true, nil
+	}
+
+	pem, err := os.ReadFile(*clientCA)
+	if err != nil {
+		return false, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return false, fmt.Errorf("%s: no certificates found", *clientCA)
+	}
+	server.TLSConfig.ClientCAs = pool
+	server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	if *allowedClientCNs != "" {
+		allowedCNs = make(map[string]bool)
+		for _, cn := range strings.Split(*allowedClientCNs, ",") {
+			if cn = strings.TrimSpace(cn); cn != "" {
+				allowedCNs[cn] = true
+			}
+		}
+	}
+	return true, nil
+}
+
+// clientCN returns the common name of the verified client certificate, or ""
+// for plain HTTP and connections without one.
+func clientCN(r *http.Request) string {
+	return verifiedCN(r.TLS)
+}
+
+// verifiedCN returns the common name of the verified client certificate of
+// a TLS connection, or "" without one.
+func verifiedCN(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
+// requireClientCN rejects clients whose certificate common name is not in
+// -allowed-client-cns. Without an allowlist every verified client passes.
+func requireClientCN(next http.Handler) http.Handler {
+	if allowedCNs == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cn := clientCN(r); !allowedCNs[cn] {
+			slog.Warn("Rejected client certificate", "cn", cn, "path", r.URL.Path)
+			http.Error(w, "Client certificate not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	otelEndpoint    = flag.String("otel-endpoint", "", "OTLP/HTTP collector base URL, e.g. http://collector:4318 (empty = tracing disabled)")
+	otelServiceName = flag.String("otel-service-name", "atc4-hq-server", "service.name reported with exported spans")
+)
+
+const (
+	spanBatchSize     = 256
+	spanFlushInterval = 5 * time.Second
+	spanKindServer    = 2
+	spanStatusOK      = 1
+	spanStatusError   = 2
+)
+
+// span is a minimal server span exported in the OTLP JSON encoding. All
+// methods are safe on a nil span, which is what callers get while tracing
+// is disabled.
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+	status   int
+
+	mu    sync.Mutex
+	attrs map[string]any
+}
+
+type spanKey struct{}
+
+var spanQueue chan *span
+
+// startTracing launches the exporter when -otel-endpoint is set.
+func startTracing() {
+	if *otelEndpoint == "" {
+		return
+	}
+	spanQueue = make(chan *span, 4*spanBatchSize)
+	go exportSpans(strings.TrimSuffix(*otelEndpoint, "/") + "/v1/traces")
+	slog.Info("Exporting traces", "endpoint", *otelEndpoint)
+}
+
+// startSpan begins a server span, continuing the trace from an incoming
+// W3C traceparent header when there is a valid one.
+func startSpan(r *http.Request, name string) *span {
+	if spanQueue == nil {
+		return nil
+	}
+
+	s := &span{name: name, start: time.Now(), spanID: randomHex(8), attrs: make(map[string]any)}
+	if traceID, parentID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		s.traceID, s.parentID = traceID, parentID
+	} else {
+		s.traceID = randomHex(16)
+	}
+
+	s.set("http.request.method", r.Method)
+	s.set("url.path", r.URL.Path)
+	return s
+}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+func contextWithSpan(ctx context.Context, s *span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// finish records the response and queues the span for export. Spans are
+// dropped rather than blocking the request when the exporter falls behind.
+func (s *span) finish(rec *responseRecorder) {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+	s.set("http.response.status_code", rec.statusCode())
+	s.set("http.response.body.size", rec.bytes)
+	s.status = spanStatusOK
+	if rec.statusCode() >= 500 {
+		s.status = spanStatusError
+	}
+
+	select {
+	case spanQueue <- s:
+	default:
+	}
+}
+
+// parseTraceparent extracts trace and parent span IDs from a version 00
+// traceparent header.
+func parseTraceparent(header string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	if !isHex(parts[1]) || !isHex(parts[2]) ||
+		parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// exportSpans batches queued spans and posts them to the collector.
+func exportSpans(url string) {
+	ticker := time.NewTicker(spanFlushInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	var batch []*span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := postSpans(client, url, batch); err != nil {
+			slog.Warn("Failed to export spans", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-spanQueue:
+			batch = append(batch, s)
+			if len(batch) >= spanBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func postSpans(client *http.Client, url string, spans []*span) error {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.otlp())
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []any{otlpAttribute("service.name", *otelServiceName)},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "atc4-hq-server"},
+				"spans": encoded,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *span) otlp() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attrs := make([]any, 0, len(s.attrs))
+	for k, v := range s.attrs {
+		attrs = append(attrs, otlpAttribute(k, v))
+	}
+
+	encoded := map[string]any{
+		"traceId":           s.traceID,
+		"spanId":            s.spanID,
+		"name":              s.name,
+		"kind":              spanKindServer,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        attrs,
+		"status":            map[string]any{"code": s.status},
+	}
+	if s.parentID != "" {
+		encoded["parentSpanId"] = s.parentID
+	}
+	return encoded
+}
+
+// otlpAttribute encodes a key/value pair as an OTLP AnyValue.
+func otlpAttribute(key string, value any) map[string]any {
+	var v map[string]any
+	switch value := value.(type) {
+	case int:
+		v = map[string]any{"intValue": strconv.Itoa(value)}
+	case int64:
+		v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+	case bool:
+		v = map[string]any{"boolValue": value}
+	default:
+		v = map[string]any{"stringValue": fmt.Sprint(value)}
+	}
+	return map[string]any{"key": key, "value": v}
+}
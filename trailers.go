@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+)
+
+var (
+	checksumTrailer    = flag.Bool("checksum-trailer", false, "send a SHA-256 of the body as an X-Checksum-SHA256 trailer (HTTP/1.1 responses are then chunked, without Content-Length)")
+	bytesServedTrailer = flag.Bool("bytes-served-trailer", false, "send the number of body bytes actually written as an X-Bytes-Served trailer (HTTP/1.1 responses are then chunked, without Content-Length)")
+)
+
+const (
+	checksumTrailerName    = "X-Checksum-SHA256"
+	bytesServedTrailerName = "X-Bytes-Served"
+)
+
+// declareTrailers announces the trailers this response will carry. Over
+// HTTP/1.1 trailers only exist in chunked bodies, so Content-Length has to
+// go; HTTP/2 can send both. Without a length the client cannot tell a
+// body that just stops from a complete one, so a response that declared
+// trailers and does not finish has to panic with http.ErrAbortHandler.
+func declareTrailers(w http.ResponseWriter, r *http.Request, names ...string) {
+	if len(names) == 0 {
+		return
+	}
+	for _, name := range names {
+		w.Header().Add("Trailer", name)
+	}
+	if r.ProtoMajor < 2 {
+		w.Header().Del("Content-Length")
+	}
+}
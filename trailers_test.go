@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChecksumTrailer(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "checksum-trailer", "true")
+	content := strings.Repeat("checksum me\n", 1000)
+	writeTestFile(t, "a.txt", content)
+	sumOf := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name    string
+		headers []string
+		body    string
+	}{
+		{"whole file", nil, content},
+		{"range", []string{"Range", "bytes=100-199"}, content[100:200]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt", tt.headers...))
+			res := rec.Result()
+			if got := res.Header.Get("Trailer"); got != checksumTrailerName {
+				t.Errorf("Trailer = %q, want %q", got, checksumTrailerName)
+			}
+			if got := res.Header.Get("Content-Length"); got != "" {
+				t.Errorf("Content-Length = %q, want none on HTTP/1.1", got)
+			}
+			if rec.Body.String() != tt.body {
+				t.Fatalf("body differs")
+			}
+			if got, want := res.Trailer.Get(checksumTrailerName), sumOf(tt.body); got != want {
+				t.Errorf("%s = %q, want %q", checksumTrailerName, got, want)
+			}
+		})
+	}
+}
+
+func TestNoTrailersByDefault(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "a.txt", "plain")
+
+	rec := serve(downloadHandler, newRequest("GET", "/download?file=a.txt"))
+	if got := rec.Header().Get("Trailer"); got != "" {
+		t.Errorf("Trailer = %q, want none", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "5" {
+		t.Errorf("Content-Length = %q, want 5", got)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
+
+func TestBytesServedTrailer(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "bytes-served-trailer", "true")
+	writeTestFile(t, "a.bin", strings.Repeat("x", 64<<10))
+
+	rec := serve(downloadHandler, newRequest("GET", "/download?file=a.bin"))
+	if got := rec.Result().Trailer.Get(bytesServedTrailerName); got != "65536" {
+		t.Errorf("%s = %q, want 65536", bytesServedTrailerName, got)
+	}
+}
+
+// A download cut short must not look complete to a client reading the
+// chunked body through a real connection.
+func TestTruncatedDownloadWithTrailers(t *testing.T) {
+	for _, trailer := range []struct{ flag, name string }{
+		{"checksum-trailer", checksumTrailerName},
+		{"bytes-served-trailer", bytesServedTrailerName},
+	} {
+		t.Run(trailer.flag, func(t *testing.T) {
+			newTestDir(t)
+			setFlag(t, trailer.flag, "true")
+			setFlag(t, "chaos", "true")
+			setFlag(t, "chaos-abort-rate", "1")
+			writeTestFile(t, "a.bin", strings.Repeat("x", 1<<20))
+
+			srv := httptest.NewServer(workerQueue.wrap(downloadHandler))
+			defer srv.Close()
+
+			res, err := http.Get(srv.URL + "/download?file=a.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err == nil {
+				t.Fatalf("read %d of %d bytes without an error", len(body), 1<<20)
+			}
+			if got := res.Trailer.Get(trailer.name); got != "" {
+				t.Errorf("%s = %q on a truncated body", trailer.name, got)
+			}
+		})
+	}
+}
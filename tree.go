@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var maxTreeDepth = flag.Int("max-tree-depth", 8, "maximum depth /tree will descend")
+
+// treeNode is one entry of the /tree response.
+type treeNode struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Size     int64       `json:"size,omitempty"`
+	Modified time.Time   `json:"modified"`
+	Children []*treeNode `json:"children,omitempty"`
+	// Unreadable is set on directories whose contents could not be listed.
+	Unreadable bool `json:"unreadable,omitempty"`
+}
+
+type treeResponse struct {
+	Root  *treeNode `json:"root"`
+	Depth int       `json:"depth"`
+	// Partial is true when at least one directory could not be read.
+	Partial bool `json:"partial"`
+}
+
+// buildTree walks dirPath up to depth levels. Symbolic links are not
+// followed, which also rules out cycles.
+func buildTree(dirPath string, stat os.FileInfo, depth int, partial *bool) *treeNode {
+	node := &treeNode{Name: stat.Name(), Type: "dir", Modified: stat.ModTime()}
+	if depth == 0 {
+		return node
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		node.Unreadable = true
+		*partial = true
+		return node
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case entry.IsDir():
+			node.Children = append(node.Children, buildTree(filepath.Join(dirPath, entry.Name()), info, depth-1, partial))
+		case entry.Type().IsRegular():
+			node.Children = append(node.Children, &treeNode{
+				Name:     entry.Name(),
+				Type:     "file",
+				Size:     info.Size(),
+				Modified: info.ModTime(),
+			})
+		}
+	}
+	return node
+}
+
+// treeHandler handles GET /tree?dir=<subdir>&depth=N.
+func treeHandler(w http.ResponseWriter, r *http.Request) {
+	depth := 1
+	if v := r.URL.Query().Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid depth", http.StatusBadRequest)
+			return
+		}
+		depth = n
+	}
+	depth = min(depth, *maxTreeDepth)
+
+	dirPath, err := resolveDownloadPath(r.URL.Query().Get("dir"))
+	if err != nil {
+		http.Error(w, "Invalid directory", http.StatusBadRequest)
+		return
+	}
+
+	stat, err := os.Stat(dirPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !stat.IsDir() {
+		http.Error(w, "Not a directory", http.StatusBadRequest)
+		return
+	}
+
+	resp := treeResponse{Depth: depth}
+	resp.Root = buildTree(dirPath, stat, depth, &resp.Partial)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
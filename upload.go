@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	uploadEnabled   = flag.Bool("upload", false, "enable POST /upload (multipart) and PUT /upload?file= (raw body)")
+	uploadCollision = flag.String("upload-collision", "reject", "what to do when an uploaded name already exists: reject, overwrite or rename")
+	uploadSubdirs   = flag.Bool("upload-subdirs", false, "let uploads choose a subdirectory via path components in the name")
+	maxUploadSize   = flag.Int64("max-upload-size", 1<<30, "maximum upload size in bytes")
+)
+
+// renameAttempts bounds the "-1", "-2", ... suffixes tried in rename mode.
+const renameAttempts = 1000
+
+var errNameTaken = errors.New("file already exists")
+
+// normalizeUploadName turns a client supplied name into a clean relative
+// path: NFC normalized, with Windows separators converted and, unless
+// subdirectories are allowed, everything but the final element stripped.
+// Hidden names and sidecar suffixes are refused.
+func normalizeUploadName(name string, allowSubdirs bool) (string, error) {
+	name = norm.NFC.String(strings.ReplaceAll(name, `\`, "/"))
+	if strings.ContainsRune(name, 0) {
+		return "", errors.New("name contains a null byte")
+	}
+
+	if allowSubdirs {
+		name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	} else {
+		name = path.Base(name)
+	}
+
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "", errors.New("name is empty")
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return "", errors.New("name starts with a dot")
+		}
+	}
+	lower := strings.ToLower(name)
+	for _, suffix := range reservedUploadSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return "", fmt.Errorf("names ending in %s are reserved", suffix)
+		}
+	}
+	return name, nil
+}
+
+// reservedUploadSuffixes are the sidecars the server reads next to a file.
+// Uploading one would change how another file is served: its headers, its
+// body for gzip clients, or whether it passes -verify-on-serve.
+var reservedUploadSuffixes = []string{".meta", ".gz", ".sha256", zipDigestSuffix}
+
+// uploadHandler handles POST /upload with a multipart "file" field. The
+// stored name comes from the optional "name" field or the part's filename.
+// Parts are read as a stream, so the file goes straight to disk without
+// being buffered by the multipart parser.
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, *maxUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return
+	}
+
+	var requested, tmpPath string
+	var size int64
+	var sum []byte
+	defer func() {
+		if tmpPath != "" {
+			os.Remove(tmpPath)
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeUploadError(w, "", err, "Invalid multipart form")
+			return
+		}
+
+		switch part.FormName() {
+		case "name":
+			value, err := io.ReadAll(io.LimitReader(part, maxUploadNameLength+1))
+			if err != nil {
+				writeUploadError(w, "", err, "Invalid multipart form")
+				return
+			}
+			if len(value) > maxUploadNameLength {
+				http.Error(w, "Invalid file name: too long", http.StatusBadRequest)
+				return
+			}
+			requested = string(value)
+		case "file":
+			if tmpPath != "" {
+				http.Error(w, "Only one file field is allowed", http.StatusBadRequest)
+				return
+			}
+			if requested == "" {
+				requested = part.FileName()
+			}
+			tmpPath, size, sum, err = receiveUpload(part)
+			if err != nil {
+				writeUploadError(w, requested, err, "Invalid multipart form")
+				return
+			}
+		}
+		part.Close()
+	}
+	if tmpPath == "" {
+		http.Error(w, "Missing file field", http.StatusBadRequest)
+		return
+	}
+
+	finishUpload(w, r, tmpPath, requested, size, sum)
+}
+
+// rawUploadHandler handles PUT /upload?file=name, storing the request body
+// as is for clients that cannot build multipart forms.
+func rawUploadHandler(w http.ResponseWriter, r *http.Request) {
+	requested := r.URL.Query().Get("file")
+	if requested == "" {
+		http.Error(w, "Missing file parameter", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength > *maxUploadSize {
+		http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, *maxUploadSize)
+
+	tmpPath, size, sum, err := receiveUpload(r.Body)
+	if err != nil {
+		writeUploadError(w, requested, err, "Failed to read request body")
+		return
+	}
+	defer os.Remove(tmpPath)
+
+	finishUpload(w, r, tmpPath, requested, size, sum)
+}
+
+// maxUploadNameLength bounds the multipart "name" field.
+const maxUploadNameLength = 4096
+
+// receiveUpload copies body into a temp file inside downloadDir while
+// hashing it. On any error, including the size limit being hit midway, the
+// partial file is removed before returning.
+func receiveUpload(body io.Reader) (tmpPath string, size int64, sum []byte, err error) {
+	tmp, err := os.CreateTemp(downloadDir, ".upload-*")
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	hash := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), filePerm)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", size, nil, err
+	}
+	return tmp.Name(), size, hash.Sum(nil), nil
+}
+
+// writeUploadError answers a failed upload. badRequest is the message for
+// errors caused by a malformed body.
+func writeUploadError(w http.ResponseWriter, name string, err error, badRequest string) {
+	var tooBig *http.MaxBytesError
+	var pathErr *os.PathError
+	switch {
+	case errors.As(err, &tooBig):
+		slog.Info("Rejected upload over size limit", "name", name, "limit", tooBig.Limit)
+		http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+	case errors.As(err, &pathErr):
+		slog.Error("Failed to write upload", "name", name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	default:
+		slog.Info("Upload aborted", "name", name, "error", err)
+		http.Error(w, badRequest, http.StatusBadRequest)
+	}
+}
+
+// finishUpload moves a received temp file to its final name and reports the
+// stored name, size and SHA-256 to the client. Overwriting a locked file
+// takes the lock's token in X-Lock-Token, as deleting it does.
+func finishUpload(w http.ResponseWriter, r *http.Request, tmpPath, requested string, size int64, sum []byte) {
+	name, err := normalizeUploadName(requested, *uploadSubdirs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid file name: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	finalPath, err := resolveDownloadPath(name)
+	if err != nil {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(finalPath), dirPerm); err != nil {
+		slog.Error("Failed to create upload directory", "name", name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	storedPath, err := placeUpload(tmpPath, finalPath, *uploadCollision, r.Header.Get(lockTokenHeader))
+	if err != nil {
+		var locked *fileLockedError
+		switch {
+		case err == errNameTaken:
+			http.Error(w, "A file with that name already exists", http.StatusConflict)
+			return
+		case errors.As(err, &locked):
+			slog.Info("Rejected upload over a locked file", "name", name)
+			writeLockConflict(w, http.StatusLocked, name, locked.lock)
+			return
+		}
+		slog.Error("Failed to store upload", "name", name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	storedName, _ := filepath.Rel(mustAbs(downloadDir), storedPath)
+	storedName = filepath.ToSlash(storedName)
+	checksum := hex.EncodeToString(sum)
+	slog.Info("Stored upload", "name", storedName, "bytes", size, "sha256", checksum)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"name": storedName, "size": size, "sha256": checksum})
+}
+
+// placeUpload moves the finished temp file to dst according to the collision
+// policy and returns the path it ended up at. Hard links make the reject and
+// rename modes atomic: linking fails if the destination already exists.
+// Only overwriting replaces an existing file, so only it checks for a lock
+// held by someone other than the holder of token.
+func placeUpload(tmp, dst, policy, token string) (string, error) {
+	switch policy {
+	case "overwrite":
+		return dst, changeUnlessLocked(dst, token, func() error { return os.Rename(tmp, dst) })
+	case "rename":
+		ext := filepath.Ext(dst)
+		base := strings.TrimSuffix(dst, ext)
+		candidate := dst
+		for i := 1; i <= renameAttempts; i++ {
+			err := os.Link(tmp, candidate)
+			if err == nil {
+				return candidate, nil
+			}
+			if !os.IsExist(err) {
+				return "", err
+			}
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		return "", errNameTaken
+	default:
+		if err := os.Link(tmp, dst); err != nil {
+			if os.IsExist(err) {
+				return "", errNameTaken
+			}
+			return "", err
+		}
+		return dst, nil
+	}
+}
+
+func mustAbs(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	return abs
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestNormalizeUploadName(t *testing.T) {
+	tests := []struct {
+		name    string
+		subdirs bool
+		want    string
+	}{
+		{"report.pdf", false, "report.pdf"},
+		{`dir\report.pdf`, false, "report.pdf"},
+		{"a/b/report.pdf", true, "a/b/report.pdf"},
+		{"../report.pdf", true, "report.pdf"},
+		{"", false, ""},
+		{".env", false, ""},
+		{".hidden/report.pdf", true, ""},
+		{"report.pdf.meta", false, ""},
+		{"report.pdf.gz", false, ""},
+		{"report.pdf.SHA256", false, ""},
+		{"archive.zip.digest", false, ""},
+	}
+	for _, tt := range tests {
+		got, err := normalizeUploadName(tt.name, tt.subdirs)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("normalizeUploadName(%q) = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeUploadName(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
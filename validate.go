@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFileNameLength bounds the ?file= parameter in bytes.
+const maxFileNameLength = 1024
+
+// validationError describes which rule a request parameter broke. It is sent
+// to the client as JSON.
+type validationError struct {
+	Message string `json:"error"`
+	Field   string `json:"field"`
+	Reason  string `json:"reason"`
+	status  int
+}
+
+func (e *validationError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+func invalidParam(field, reason string) *validationError {
+	return &validationError{Message: "Invalid request", Field: field, Reason: reason, status: http.StatusBadRequest}
+}
+
+// validateFileParam checks a requested file name and resolves it inside
+// downloadDir. Rules are checked from cheapest to most expensive.
+func validateFileParam(field, name string) (string, *validationError) {
+	switch {
+	case name == "":
+		return "", invalidParam(field, "is required")
+	case len(name) > maxFileNameLength:
+		return "", invalidParam(field, fmt.Sprintf("is longer than %d bytes", maxFileNameLength))
+	case !utf8.ValidString(name):
+		return "", invalidParam(field, "is not valid UTF-8")
+	case strings.ContainsRune(name, 0):
+		return "", invalidParam(field, "contains null byte")
+	case strings.ContainsFunc(name, unicode.IsControl):
+		return "", invalidParam(field, "contains control characters")
+	}
+
+	filePath, err := resolveDownloadPath(name)
+	if err != nil {
+		if err == errInvalidPath {
+			return "", invalidParam(field, "points outside the download directory")
+		}
+		return "", &validationError{Message: "Internal server error", Field: field, Reason: "cannot resolve path", status: http.StatusInternalServerError}
+	}
+	// "." and friends clean to the download directory itself; a trailing
+	// slash can only name a directory
+	if filePath == mustAbs(downloadDir) || strings.HasSuffix(name, "/") || strings.HasSuffix(name, `\`) {
+		return "", errIsDirectory(field)
+	}
+
+	if !extensionAllowed(filePath) {
+		return "", &validationError{Message: "File type not allowed", Field: field, Reason: "extension is not allowed", status: http.StatusForbidden}
+	}
+	return filePath, nil
+}
+
+// errIsDirectory is the error for a file parameter naming a directory.
+func errIsDirectory(field string) *validationError {
+	return invalidParam(field, "names a directory, not a file")
+}
+
+func writeValidationError(w http.ResponseWriter, err *validationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.status)
+	json.NewEncoder(w).Encode(err)
+}
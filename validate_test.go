@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveDownloadPath(t *testing.T) {
+	dir := newTestDir(t)
+	// A sibling whose name starts with the download directory's
+	for _, sibling := range []string{downloadDir + "2", downloadDir + "-old"} {
+		if err := os.Mkdir(filepath.Join(dir, sibling), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := filepath.Join(dir, downloadDir)
+
+	tests := []struct {
+		name string
+		want string // empty when the name must be rejected
+	}{
+		{"a.txt", filepath.Join(root, "a.txt")},
+		{"sub/b.txt", filepath.Join(root, "sub", "b.txt")},
+		{"sub/../a.txt", filepath.Join(root, "a.txt")},
+		{"", root},
+		{"..", ""},
+		{"../a.txt", ""},
+		{"../" + downloadDir + "2/x", ""},
+		{"../" + downloadDir + "-old/x", ""},
+		{"sub/../../" + downloadDir + "2", ""},
+		{"/etc/passwd", filepath.Join(root, "etc", "passwd")},
+	}
+	for _, tt := range tests {
+		got, err := resolveDownloadPath(tt.name)
+		switch {
+		case tt.want == "" && !errors.Is(err, errInvalidPath):
+			t.Errorf("resolveDownloadPath(%q) = %q, %v; want errInvalidPath", tt.name, got, err)
+		case tt.want != "" && (err != nil || got != tt.want):
+			t.Errorf("resolveDownloadPath(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// Names that resolve to the download directory, or to any directory, get a
+// clear 400 instead of failing while the directory is read as a file.
+func TestDownloadDirectoryRejected(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "sub/a.txt", "hello")
+
+	tests := []struct {
+		name   string
+		file   string
+		status int
+		reason string
+	}{
+		{"dot", ".", http.StatusBadRequest, "names a directory, not a file"},
+		{"dot slash", "./", http.StatusBadRequest, "names a directory, not a file"},
+		{"cleans to root", "sub/..", http.StatusBadRequest, "names a directory, not a file"},
+		{"trailing slash", "sub/", http.StatusBadRequest, "names a directory, not a file"},
+		{"trailing backslash", `sub\`, http.StatusBadRequest, "names a directory, not a file"},
+		{"subdirectory", "sub", http.StatusBadRequest, "names a directory, not a file"},
+		{"file in subdirectory", "sub/a.txt", http.StatusOK, ""},
+		{"empty", "", http.StatusBadRequest, "is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest("GET", "/download?file="+url.QueryEscape(tt.file)))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+			var verr validationError
+			if err := json.NewDecoder(rec.Body).Decode(&verr); err != nil {
+				t.Fatal(err)
+			}
+			if verr.Field != "file" || verr.Reason != tt.reason {
+				t.Errorf("error = %s: %s, want file: %s", verr.Field, verr.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckDownloadDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T)
+		wantErr string
+	}{
+		{"missing is created", func(t *testing.T) {}, ""},
+		{"existing directory", func(t *testing.T) { os.Mkdir(downloadDir, 0755) }, ""},
+		{"regular file", func(t *testing.T) { os.WriteFile(downloadDir, []byte("oops"), 0644) }, downloadDir + " is not a directory"},
+		{"unreadable directory", func(t *testing.T) {
+			if os.Geteuid() == 0 {
+				t.Skip("root can read any directory")
+			}
+			os.Mkdir(downloadDir, 0)
+			t.Cleanup(func() { os.Chmod(downloadDir, 0755) })
+		}, "permission denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			tt.setup(t)
+
+			err := checkDownloadDir()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkDownloadDir() = %v", err)
+				}
+				if stat, err := os.Stat(downloadDir); err != nil || !stat.IsDir() {
+					t.Errorf("%s is not a directory afterwards", downloadDir)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkDownloadDir() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var verifyOnServe = flag.Bool("verify-on-serve", false, "check files against their .sha256 sidecar before serving them (costs a full read the first time)")
+
+var (
+	errChecksumMismatch = errors.New("file does not match its stored checksum")
+	errInvalidSidecar   = errors.New("invalid .sha256 sidecar")
+)
+
+// verifyKey identifies one version of a file. A change in size or
+// modification time invalidates the cached result.
+type verifyKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+var (
+	verifiedMu sync.Mutex
+	verified   = make(map[string]verifyKey)
+)
+
+// verifyChecksum checks file against the "<path>.sha256" sidecar. Files
+// without a sidecar are accepted. Successful results are cached per path and
+// modification time so each version is only read once.
+func verifyChecksum(filePath string, file io.ReaderAt, stat os.FileInfo) error {
+	key := verifyKey{path: filePath, size: stat.Size(), modTime: stat.ModTime()}
+
+	verifiedMu.Lock()
+	cached, ok := verified[filePath]
+	verifiedMu.Unlock()
+	if ok && cached == key {
+		return nil
+	}
+
+	data, err := os.ReadFile(filePath + ".sha256")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Accept both a bare digest and sha256sum's "<digest>  <name>" format
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return errInvalidSidecar
+	}
+	want, err := hex.DecodeString(fields[0])
+	if err != nil || len(want) != sha256.Size {
+		return errInvalidSidecar
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, stat.Size())); err != nil {
+		return err
+	}
+	if !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), fields[0]) {
+		verifiedMu.Lock()
+		delete(verified, filePath)
+		verifiedMu.Unlock()
+		return errChecksumMismatch
+	}
+
+	verifiedMu.Lock()
+	verified[filePath] = key
+	verifiedMu.Unlock()
+	return nil
+}
+
+// forgetVerification drops the cached result for filePath. A changed
+// checksum sidecar invalidates the file it describes.
+func forgetVerification(filePath string) {
+	verifiedMu.Lock()
+	defer verifiedMu.Unlock()
+	delete(verified, filePath)
+	delete(verified, strings.TrimSuffix(filePath, ".sha256"))
+}
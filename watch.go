@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var watchFiles = flag.Bool("watch", false, "watch the download directory and invalidate cached per-file state as soon as files change (falls back to modtime checks)")
+
+// startWatcher invalidates caches on filesystem events under downloadDir.
+// The caches already compare modification times, so the watcher only makes
+// invalidation prompt; if it cannot be set up the server carries on.
+func startWatcher() {
+	if !*watchFiles {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Warn("File watcher unavailable, relying on modtime checks", "error", err)
+		return
+	}
+
+	root := mustAbs(downloadDir)
+	if err := watchTree(watcher, root); err != nil {
+		watcher.Close()
+		slog.Warn("File watcher unavailable, relying on modtime checks", "error", err)
+		return
+	}
+	slog.Info("Watching download directory for changes", "dir", root)
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				invalidateCaches(event.Name)
+				// fsnotify is not recursive; pick up new subdirectories
+				if event.Has(fsnotify.Create) {
+					if err := watchTree(watcher, event.Name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+						slog.Warn("Failed to watch new directory", "dir", event.Name, "error", err)
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("File watcher error", "error", err)
+			}
+		}
+	}()
+}
+
+// watchTree adds dir and every directory below it. A path that is not a
+// directory is ignored.
+func watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return watcher.Add(path)
+	})
+}
+
+// invalidateCaches forgets everything cached about path.
+func invalidateCaches(path string) {
+	forgetVerification(path)
+	forgetDiskUsage(path)
+	forgetDigest(path)
+	memCache.forget(path)
+}
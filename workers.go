@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var warmup = flag.Duration("warmup", 0, "ramp allowed download concurrency up to the worker limit over this period after start (0 = disabled)")
+
+// workerPool bounds how many queued requests are processed at once.
+type workerPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+}
+
+var workers = newWorkerPool()
+
+func newWorkerPool() *workerPool {
+	p := &workerPool{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// acquire blocks until a worker slot is available.
+func (p *workerPool) acquire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.active >= allowedWorkers() {
+		p.cond.Wait()
+	}
+	p.active++
+}
+
+func (p *workerPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active--
+	p.cond.Signal()
+}
+
+func (p *workerPool) activeCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active
+}
+
+// allowedWorkers is the current concurrency limit. During warmup it grows
+// linearly from one worker to maxWorkers.
+func allowedWorkers() int {
+	if *warmup <= 0 {
+		return maxWorkers
+	}
+
+	elapsed := time.Since(serverStart)
+	if elapsed >= *warmup {
+		return maxWorkers
+	}
+	return max(1, int(float64(maxWorkers)*float64(elapsed)/float64(*warmup)))
+}
+
+// startWarmup wakes waiting workers while the limit is still rising.
+func startWarmup() {
+	if *warmup <= 0 {
+		return
+	}
+	slog.Info("Warming up", "workers", maxWorkers, "over", *warmup)
+
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			workers.cond.Broadcast()
+			if time.Since(serverStart) >= *warmup {
+				slog.Info("Warmup complete")
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"time"
+)
+
+var chunkWriteTimeout = flag.Duration("chunk-write-timeout", 0, "abort a download when a single write to the client stalls for this long (0 = only the server-wide write timeout applies)")
+
+// deadlineWriter gives every write to the client its own deadline, so a
+// stuck connection is detected after -chunk-write-timeout while a slow but
+// progressing one is never cut off. It sits directly on the response so
+// time spent waiting for rate limits does not count against a write.
+type deadlineWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func newDeadlineWriter(w http.ResponseWriter, timeout time.Duration) *deadlineWriter {
+	return &deadlineWriter{w: w, rc: http.NewResponseController(w), timeout: timeout}
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	// Writers that cannot set deadlines fall back to the server's
+	d.rc.SetWriteDeadline(time.Now().Add(d.timeout))
+	return d.w.Write(p)
+}
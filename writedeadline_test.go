@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallingWriter models a client connection: a write takes delay, or never
+// finishes when stuck, and fails once the write deadline passes.
+type stallingWriter struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+	stuck bool
+
+	mu       sync.Mutex
+	deadline time.Time
+	writes   int
+}
+
+func (s *stallingWriter) SetWriteDeadline(deadline time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadline = deadline
+	return nil
+}
+
+func (s *stallingWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	deadline := s.deadline
+	s.writes++
+	s.mu.Unlock()
+
+	wait := s.delay
+	if s.stuck {
+		wait = time.Hour
+	}
+	if !deadline.IsZero() && time.Until(deadline) < wait {
+		time.Sleep(time.Until(deadline))
+		return 0, os.ErrDeadlineExceeded
+	}
+	time.Sleep(wait)
+	return s.ResponseRecorder.Write(p)
+}
+
+func TestChunkWriteTimeout(t *testing.T) {
+	newTestDir(t)
+	content := strings.Repeat("x", 1<<20)
+	writeTestFile(t, "a.bin", content)
+	setFlag(t, "chunk-write-timeout", "100ms")
+
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		stuck    bool
+		complete bool
+	}{
+		{"slow but progressing", 20 * time.Millisecond, false, true},
+		{"stuck", 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &stallingWriter{ResponseRecorder: httptest.NewRecorder(), delay: tt.delay, stuck: tt.stuck}
+			start := time.Now()
+			downloadHandler(w, newRequest("GET", "/download?file=a.bin"))
+			if complete := w.Body.Len() == len(content); complete != tt.complete {
+				t.Errorf("sent %d of %d bytes, complete = %v, want %v", w.Body.Len(), len(content), complete, tt.complete)
+			}
+			taken := time.Since(start)
+			if tt.stuck && taken > 5*time.Second {
+				t.Errorf("stuck write aborted after %v", taken)
+			}
+			// The whole transfer outlasting the timeout shows the deadline
+			// is per write
+			if tt.complete && taken <= *chunkWriteTimeout {
+				t.Errorf("transfer took %v in %d writes, too fast to tell", taken, w.writes)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestZeroByteFile(t *testing.T) {
+	newTestDir(t)
+	writeTestFile(t, "empty.txt", "")
+
+	tests := []struct {
+		name    string
+		method  string
+		headers []string
+		status  int
+		length  string
+		rng     string
+	}{
+		{"get", "GET", nil, http.StatusOK, "0", ""},
+		{"head", "HEAD", nil, http.StatusOK, "0", ""},
+		{"range", "GET", []string{"Range", "bytes=0-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */0"},
+		{"suffix range", "GET", []string{"Range", "bytes=-10"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(downloadHandler, newRequest(tt.method, "/download?file=empty.txt", tt.headers...))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.length != "" {
+				if got := rec.Header().Get("Content-Length"); got != tt.length {
+					t.Errorf("Content-Length = %q, want %q", got, tt.length)
+				}
+				if rec.Body.Len() != 0 {
+					t.Errorf("body has %d bytes", rec.Body.Len())
+				}
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.rng {
+				t.Errorf("Content-Range = %q, want %q", got, tt.rng)
+			}
+		})
+	}
+}
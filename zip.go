@@ -0,0 +1,313 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	zipCache    = flag.Bool("zip-cache", false, "build /zip archives to a cached file first so they can be resumed with Range requests")
+	zipCacheDir = flag.String("zip-cache-dir", filepath.Join(os.TempDir(), "atc4-zip"), "directory holding cached /zip archives")
+	zipCacheTTL = flag.Duration("zip-cache-ttl", time.Hour, "how long a cached archive is kept after it was last requested")
+)
+
+// zipArchiveName is the file name offered to clients for /zip downloads.
+const zipArchiveName = "files.zip"
+
+func init() {
+	go sweepZipCache()
+}
+
+// zipFileSet resolves the ?file= parameters of a /zip request into a sorted,
+// de-duplicated list. Every entry must be a regular file.
+func zipFileSet(names []string) ([]FileInfo, error) {
+	if len(names) == 0 {
+		return nil, errors.New("at least one file is required")
+	}
+
+	files := make([]FileInfo, 0, len(names))
+	for _, name := range names {
+		if !extensionAllowed(name) {
+			return nil, errExtensionDenied
+		}
+		info, err := statDownload(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+
+	slices.SortFunc(files, func(a, b FileInfo) int { return strings.Compare(a.Name, b.Name) })
+	files = slices.CompactFunc(files, func(a, b FileInfo) bool { return a.Name == b.Name })
+	return files, nil
+}
+
+// zipHandler handles GET /zip?file=a&file=b. Without -zip-cache the archive
+// is streamed as it is built and cannot be resumed.
+func zipHandler(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["file"]
+	if verr := checkFileCount(len(names)); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+	files, err := zipFileSet(names)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidPath):
+			http.Error(w, "Invalid file path", http.StatusBadRequest)
+		case errors.Is(err, errExtensionDenied):
+			http.Error(w, "File type not allowed", http.StatusForbidden)
+		case errors.Is(err, os.ErrNotExist), errors.Is(err, errNotAFile):
+			http.Error(w, "File not found", http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	if verr := checkTotalSize(total); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	if *zipCache {
+		archivePath, digest, err := cachedZip(files)
+		if err != nil {
+			slog.Error("Failed to build zip archive", "files", len(files), "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// Archives are never compressed on the fly, so the digest always
+		// describes the body, or the whole body for ranges
+		if digest != "" {
+			w.Header().Set("Digest", digest)
+		}
+		serveFile(w, r, zipArchiveName, archivePath)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", zipArchiveName))
+	w.Header().Set("Accept-Ranges", "none")
+	applyExtraHeaders(w)
+	if err := writeZip(w, files); err != nil {
+		slog.Warn("Zip stream aborted", "files", len(files), "error", err)
+	}
+}
+
+// writeZip writes files as a zip archive. The output only depends on the
+// files' names, contents and modification times.
+func writeZip(w io.Writer, files []FileInfo) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		if err := addZipEntry(zw, f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func addZipEntry(zw *zip.Writer, f FileInfo) error {
+	filePath, err := resolveDownloadPath(f.Name)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     f.Name,
+		Method:   zip.Deflate,
+		Modified: f.Modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// zipCacheKey names the cached archive for a file set. Any change to a
+// file's size or modification time yields a new key.
+func zipCacheKey(files []FileInfo) string {
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", f.Name, f.Size, f.Modified.UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var (
+	zipBuildsMu sync.Mutex
+	zipBuilds   = make(map[string]*zipBuild)
+
+	// zipLastUsed records when each cached archive was last requested. The
+	// archive's own modification time must stay put because it is part of
+	// the ETag that resuming clients send back in If-Range.
+	zipLastUsed = make(map[string]time.Time)
+)
+
+// zipBuild lets concurrent requests for the same file set share one build.
+type zipBuild struct {
+	done   chan struct{}
+	digest string
+	err    error
+}
+
+// zipDigestSuffix names the file next to a cached archive that holds its
+// Digest header value, so the checksum survives restarts without
+// rehashing the archive. It must differ from ".sha256", which
+// -verify-on-serve reads as a hex checksum sidecar.
+const zipDigestSuffix = ".digest"
+
+// cachedZip returns the path of the cached archive for files and its Digest
+// header value, building the archive first if needed. The digest is empty
+// for an archive left by a version that did not record one.
+func cachedZip(files []FileInfo) (string, string, error) {
+	key := zipCacheKey(files)
+	archivePath := filepath.Join(*zipCacheDir, key+".zip")
+
+	zipBuildsMu.Lock()
+	zipLastUsed[key] = time.Now()
+	if build, ok := zipBuilds[key]; ok {
+		zipBuildsMu.Unlock()
+		<-build.done
+		return archivePath, build.digest, build.err
+	}
+	if _, err := os.Stat(archivePath); err == nil {
+		zipBuildsMu.Unlock()
+		slog.Debug("Serving cached zip archive", "archive", filepath.Base(archivePath))
+		return archivePath, loadZipDigest(archivePath), nil
+	}
+	build := &zipBuild{done: make(chan struct{})}
+	zipBuilds[key] = build
+	zipBuildsMu.Unlock()
+
+	build.digest, build.err = buildZip(archivePath, files)
+
+	zipBuildsMu.Lock()
+	delete(zipBuilds, key)
+	zipBuildsMu.Unlock()
+	close(build.done)
+
+	if build.err == nil {
+		slog.Info("Built zip archive", "archive", filepath.Base(archivePath), "files", len(files), "digest", build.digest)
+	}
+	return archivePath, build.digest, build.err
+}
+
+// buildZip writes the archive for files to archivePath, hashing it on the
+// way, and returns its Digest header value.
+func buildZip(archivePath string, files []FileInfo) (string, error) {
+	if err := os.MkdirAll(*zipCacheDir, dirPerm); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(*zipCacheDir, ".build-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	err = writeZip(io.MultiWriter(tmp, hash), files)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	digest := "sha-256=" + base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	// The digest is in place before the archive, so a cache hit always
+	// finds it
+	if err := os.WriteFile(archivePath+zipDigestSuffix, []byte(digest+"\n"), 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), archivePath); err != nil {
+		return "", err
+	}
+	if stat, err := os.Stat(archivePath); err == nil {
+		storeDigest(archivePath, stat, digest)
+	}
+	return digest, nil
+}
+
+// loadZipDigest reads the digest recorded when the archive was built.
+func loadZipDigest(archivePath string) string {
+	data, err := os.ReadFile(archivePath + zipDigestSuffix)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// sweepZipCache removes cached archives that have not been requested within
+// -zip-cache-ttl.
+func sweepZipCache() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if !*zipCache {
+			continue
+		}
+		entries, err := os.ReadDir(*zipCacheDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if filepath.Ext(entry.Name()) != ".zip" {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			key := strings.TrimSuffix(entry.Name(), ".zip")
+
+			// Archives left over from a previous run fall back to their
+			// modification time
+			zipBuildsMu.Lock()
+			lastUsed, ok := zipLastUsed[key]
+			if !ok {
+				lastUsed = info.ModTime()
+			}
+			expired := now.Sub(lastUsed) >= *zipCacheTTL
+			if expired {
+				delete(zipLastUsed, key)
+			}
+			zipBuildsMu.Unlock()
+			if !expired {
+				continue
+			}
+
+			archivePath := filepath.Join(*zipCacheDir, entry.Name())
+			if err := os.Remove(archivePath); err != nil && !os.IsNotExist(err) {
+				slog.Error("Failed to remove cached zip archive", "archive", entry.Name(), "error", err)
+				continue
+			}
+			os.Remove(archivePath + zipDigestSuffix)
+			slog.Info("Expired cached zip archive", "archive", entry.Name())
+		}
+	}
+}
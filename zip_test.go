@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCachedZip(t *testing.T) {
+	newTestDir(t)
+	setFlag(t, "zip-cache", "true")
+	setFlag(t, "zip-cache-dir", t.TempDir())
+	writeTestFile(t, "a.txt", "first file")
+	writeTestFile(t, "b.txt", "second file")
+
+	get := func(rangeHeader string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/zip?file=a.txt&file=b.txt", nil)
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		return serve(zipHandler, r)
+	}
+	digestOf := func(body []byte) string {
+		sum := sha256.Sum256(body)
+		return "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	first := get("")
+	if first.Code != 200 {
+		t.Fatalf("status = %d, body %q", first.Code, first.Body)
+	}
+	archive := first.Body.Bytes()
+	if got, want := first.Header().Get("Digest"), digestOf(archive); got != want {
+		t.Errorf("Digest = %q, want %q", got, want)
+	}
+
+	t.Run("cache hit", func(t *testing.T) {
+		entries, _ := filepath.Glob(filepath.Join(*zipCacheDir, "*.zip"))
+		if len(entries) != 1 {
+			t.Fatalf("cached archives = %v, want one", entries)
+		}
+		before, _ := os.Stat(entries[0])
+
+		again := get("")
+		if again.Body.String() != string(archive) || again.Header().Get("Digest") != first.Header().Get("Digest") {
+			t.Error("second request got a different archive")
+		}
+		after, _ := os.Stat(entries[0])
+		if !after.ModTime().Equal(before.ModTime()) {
+			t.Error("archive was rebuilt")
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		rec := get("bytes=10-19")
+		if rec.Code != 206 {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		if got := rec.Body.String(); got != string(archive[10:20]) {
+			t.Errorf("range body = %q, want %q", got, archive[10:20])
+		}
+	})
+
+	t.Run("verify on serve", func(t *testing.T) {
+		setFlag(t, "verify-on-serve", "true")
+		rec := get("")
+		if rec.Code != 200 {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+		}
+		body, _ := io.ReadAll(rec.Body)
+		if string(body) != string(archive) {
+			t.Error("verified archive differs")
+		}
+	})
+
+	t.Run("invalidated by source change", func(t *testing.T) {
+		path := writeTestFile(t, "b.txt", "second file, edited")
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(path, later, later)
+
+		rec := get("")
+		if rec.Code != 200 {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		if rec.Header().Get("Digest") == first.Header().Get("Digest") {
+			t.Error("archive not rebuilt after a source file changed")
+		}
+		if got, want := rec.Header().Get("Digest"), digestOf(rec.Body.Bytes()); got != want {
+			t.Errorf("Digest = %q, want %q", got, want)
+		}
+	})
+}